@@ -0,0 +1,314 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jingweno/codeface/settings"
+	"github.com/jingweno/codeface/workspace"
+	log "github.com/sirupsen/logrus"
+)
+
+var repoNameRegexp = regexp.MustCompile(`\.git$`)
+
+type Config struct {
+	Port    string `env:"PORT,required"`
+	GitRepo string `env:"GIT_REPO"`
+	// GitRef is a branch, tag, commit or pull request ref, e.g. refs/pull/1/head
+	GitRef     string `env:"GIT_REF"`
+	ProjectDir string `env:"CODEFACE_WORKSPACE_DIR,default=/home/dyno/project"`
+	// editors claimed through the server are only accessible with a token
+	// it signs, others fall back to the password auth of code-server
+	ServerURL   string `env:"CODEFACE_SERVER_URL"`
+	EditorID    string `env:"CODEFACE_EDITOR_ID"`
+	EditorToken string `env:"CODEFACE_EDITOR_TOKEN"`
+	// GitCredentials is set when git gets tokens from the server to clone
+	// and push GitHub repositories
+	GitCredentials bool `env:"CODEFACE_GIT_CREDENTIALS"`
+	// from the devcontainer.json and .codeface.yml of the repo
+	Extensions        []string `env:"CODEFACE_EXTENSIONS"`
+	ForwardPorts      []int    `env:"CODEFACE_FORWARD_PORTS"`
+	PostCreateCommand string   `env:"CODEFACE_POST_CREATE_COMMAND"`
+	Setup             string   `env:"CODEFACE_SETUP"`
+	OpenFile          string   `env:"CODEFACE_OPEN_FILE"`
+	// the dotfiles of the owner are installed before code-server starts
+	DotfilesRepo    string        `env:"CODEFACE_DOTFILES_REPO"`
+	DotfilesInstall string        `env:"CODEFACE_DOTFILES_INSTALL"`
+	DotfilesTimeout time.Duration `env:"CODEFACE_DOTFILES_TIMEOUT,default=5m"`
+	// code-server listens on localhost behind the proxy
+	CodeServerPort string `env:"CODE_SERVER_PORT,default=8081"`
+	// the status API is only reachable from inside the dyno
+	StatusAddr   string        `env:"CODEFACE_AGENT_ADDR,default=127.0.0.1:7070"`
+	CodeServer   string        `env:"CODE_SERVER_BIN,default=code-server"`
+	SyncInterval time.Duration `env:"CODEFACE_SYNC_INTERVAL,default=10m"`
+	// the dyno is killed 30s after SIGTERM. Stopping code-server, the last
+	// snapshot and pushing the settings all end by the deadline taken at
+	// SIGTERM, and code-server gets at most CodeServerStopTimeout of it.
+	ShutdownTimeout       time.Duration `env:"CODEFACE_SHUTDOWN_TIMEOUT,default=25s"`
+	CodeServerStopTimeout time.Duration `env:"CODEFACE_CODE_SERVER_STOP_TIMEOUT,default=10s"`
+}
+
+// New returns an agent. ws and st are nil if the editor isn't registered
+// with a server.
+func New(cfg Config, ws *workspace.Workspace, st *settings.Settings) *Agent {
+	return &Agent{
+		cfg:      cfg,
+		ws:       ws,
+		settings: st,
+		status: Status{
+			StartedAt: time.Now(),
+			Repo: RepoStatus{
+				URL:   cfg.GitRepo,
+				Ref:   cfg.GitRef,
+				State: RepoNone,
+			},
+			CodeServer: ProcessStatus{
+				State: ProcessStarting,
+			},
+			Setup: TaskStatus{
+				State: TaskNone,
+			},
+			Dotfiles: TaskStatus{
+				State: TaskNone,
+			},
+		},
+		logger: log.New().WithField("com", "agent"),
+	}
+}
+
+// Agent prepares the editor and supervises code-server
+type Agent struct {
+	cfg      Config
+	ws       *workspace.Workspace
+	settings *settings.Settings
+	logger   log.FieldLogger
+
+	mu     sync.Mutex
+	status Status
+	// setupDir is where the setup steps run once the owner confirms them
+	setupDir string
+
+	shutdownOnce sync.Once
+	shutdownAt   time.Time
+}
+
+// shutdownDeadline is the deadline of the shutdown, taken once ctx of Run is done
+func (a *Agent) shutdownDeadline() time.Time {
+	a.shutdownOnce.Do(func() {
+		a.shutdownAt = time.Now().Add(a.cfg.ShutdownTimeout)
+	})
+
+	return a.shutdownAt
+}
+
+// Run blocks until ctx is done and code-server is stopped
+func (a *Agent) Run(ctx context.Context) error {
+	srv := &http.Server{Addr: a.cfg.StatusAddr, Handler: a.statusHandler(ctx)}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			a.logger.WithError(err).Info("error: fail to serve status API")
+		}
+	}()
+	defer srv.Close()
+
+	go func() {
+		<-ctx.Done()
+		a.shutdownDeadline()
+	}()
+
+	if a.cfg.EditorToken != "" {
+		upstream := &url.URL{Scheme: "http", Host: "127.0.0.1:" + a.cfg.CodeServerPort}
+		proxy := &http.Server{Addr: ":" + a.cfg.Port, Handler: newProxy(a.cfg, upstream)}
+		go func() {
+			if err := proxy.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				a.logger.WithError(err).Info("error: fail to serve proxy")
+			}
+		}()
+		defer proxy.Close()
+	} else {
+		a.logger.Info("Editor is not registered with a server, code-server authenticates with a password")
+	}
+
+	if err := os.MkdirAll(a.cfg.ProjectDir, 0755); err != nil {
+		return err
+	}
+
+	if a.ws != nil {
+		if err := a.ws.Restore(ctx); err != nil && err != workspace.ErrNoSnapshot {
+			// the workspace isn't snapshotted in this session, see workspace.ErrNotRestored
+			a.logger.WithError(err).Info("error: fail to restore workspace, snapshots are disabled")
+		}
+	}
+
+	if a.cfg.GitCredentials {
+		if err := a.setupGitCredentials(ctx); err != nil {
+			a.logger.WithError(err).Info("error: fail to set up git credentials")
+		}
+	}
+
+	// a failure is reported in the status, the editor opens either way
+	if a.cfg.DotfilesRepo != "" {
+		a.installDotfiles(ctx)
+	}
+
+	folder := a.cfg.ProjectDir
+	if a.cfg.GitRepo != "" {
+		dir, cloned, err := a.clone(ctx)
+		if err != nil {
+			// the editor is still usable without the repo
+			a.logger.WithError(err).WithField("repo", a.cfg.GitRepo).Info("error: fail to clone repo")
+		} else {
+			folder = dir
+		}
+
+		// a restored repo has been set up before
+		if cloned && (a.cfg.Setup != "" || a.cfg.PostCreateCommand != "") {
+			a.proposeSetup(dir)
+		}
+	}
+
+	// the settings of the owner are applied before code-server starts
+	var ownerExts []string
+	if a.settings != nil {
+		exts, err := a.settings.Pull(ctx)
+		if err != nil {
+			a.logger.WithError(err).Info("error: fail to pull settings")
+		}
+		ownerExts = exts
+	}
+
+	a.installExtensions(ctx, append(append([]string{}, a.cfg.Extensions...), ownerExts...))
+
+	syncCtx, stopSync := context.WithCancel(context.Background())
+	syncDone := make(chan struct{})
+	go func() {
+		defer close(syncDone)
+		if a.ws == nil {
+			return
+		}
+
+		if err := a.ws.Sync(syncCtx, a.cfg.SyncInterval); err != nil {
+			a.logger.WithError(err).Info("error: fail to sync workspace")
+		}
+	}()
+
+	err := a.supervise(ctx, folder)
+
+	stopSync()
+	<-syncDone
+
+	// the last snapshot and settings are taken after code-server is stopped
+	shutdownCtx, cancel := context.WithDeadline(context.Background(), a.shutdownDeadline())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	if a.ws != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := a.ws.Snapshot(shutdownCtx); err != nil {
+				a.logger.WithError(err).Info("error: fail to snapshot workspace on shutdown")
+			}
+		}()
+	}
+	if a.settings != nil {
+		a.pushSettings(shutdownCtx, ownerExts)
+	}
+	wg.Wait()
+
+	return err
+}
+
+// clone clones the repo into the project dir unless it's already there,
+// e.g. restored from a snapshot. It reports whether the repo is cloned.
+func (a *Agent) clone(ctx context.Context) (string, bool, error) {
+	dir := filepath.Join(a.cfg.ProjectDir, repoName(a.cfg.GitRepo))
+	if _, err := os.Stat(dir); err == nil {
+		a.setRepo(RepoCloned, dir, nil)
+		return dir, false, nil
+	}
+
+	a.setRepo(RepoCloning, dir, nil)
+	a.logger.WithField("repo", a.cfg.GitRepo).Info("Cloning repo")
+
+	if err := git(ctx, a.cfg.ProjectDir, "clone", "--", a.cfg.GitRepo, dir); err != nil {
+		a.setRepo(RepoFailed, dir, err)
+		return "", false, err
+	}
+
+	var err error
+	if a.cfg.GitRef != "" {
+		if err = checkout(ctx, dir, a.cfg.GitRef); err != nil {
+			// the default branch is still usable
+			a.logger.WithError(err).WithField("ref", a.cfg.GitRef).Info("error: fail to check out ref")
+		}
+	}
+
+	a.setRepo(RepoCloned, dir, err)
+
+	return dir, true, nil
+}
+
+// checkout checks out a branch, tag or commit, or fetches a pull request
+// ref into a local branch, e.g. refs/pull/1/head into pull/1
+func checkout(ctx context.Context, dir, ref string) error {
+	if strings.HasPrefix(ref, "refs/") {
+		branch := strings.TrimSuffix(strings.TrimPrefix(ref, "refs/"), "/head")
+		if err := git(ctx, dir, "fetch", "origin", ref+":"+branch); err != nil {
+			return err
+		}
+
+		return git(ctx, dir, "checkout", branch)
+	}
+
+	if err := git(ctx, dir, "checkout", ref, "--"); err == nil {
+		return nil
+	}
+
+	// commits that aren't on any branch aren't cloned
+	if err := git(ctx, dir, "fetch", "origin", ref); err != nil {
+		return err
+	}
+
+	return git(ctx, dir, "checkout", "FETCH_HEAD", "--")
+}
+
+func git(ctx context.Context, dir string, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+func (a *Agent) setupGitCredentials(ctx context.Context) error {
+	helper, err := gitCredentialHelper()
+	if err != nil {
+		return err
+	}
+
+	return git(ctx, a.cfg.ProjectDir, "config", "--global", "credential.https://github.com.helper", helper)
+}
+
+func repoName(gitRepo string) string {
+	name := strings.TrimRight(gitRepo, "/")
+	if i := strings.LastIndexAny(name, "/:"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	name = repoNameRegexp.ReplaceAllString(name, "")
+	if name == "" {
+		return "repository"
+	}
+
+	return name
+}
@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+)
+
+// CredentialConfig is set on an editor by the server when it's claimed
+type CredentialConfig struct {
+	ServerURL   string `env:"CODEFACE_SERVER_URL,required"`
+	EditorID    string `env:"CODEFACE_EDITOR_ID,required"`
+	EditorToken string `env:"CODEFACE_EDITOR_TOKEN,required"`
+}
+
+// GitCredential asks the server for a short-lived token to clone and push
+// the repository of the editor
+func GitCredential(ctx context.Context, cfg CredentialConfig) (*model.GitCredential, error) {
+	u := fmt.Sprintf("%s/api/editors/%s/git/credentials", cfg.ServerURL, cfg.EditorID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+cfg.EditorToken)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e model.ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return nil, fmt.Errorf("error: unexpected response from server status=%d", resp.StatusCode)
+		}
+
+		return nil, fmt.Errorf("error: %s", e.Error)
+	}
+
+	var c model.GitCredential
+	return &c, json.NewDecoder(resp.Body).Decode(&c)
+}
+
+// gitCredentialHelper runs cf to get a token from the server when git asks
+// for it, so that it's never written to disk or into a remote URL
+func gitCredentialHelper() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("!%q git-credential", exe), nil
+}
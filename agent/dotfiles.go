@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// dotfilesScripts are looked up in the dotfiles repo in order when it
+// doesn't configure an install script
+var dotfilesScripts = []string{
+	"install.sh",
+	"install",
+	"bootstrap.sh",
+	"bootstrap",
+	"script/bootstrap",
+	"setup.sh",
+	"setup",
+	"script/setup",
+}
+
+// installDotfiles clones the dotfiles repo of the owner into the home dir and
+// runs its install script, or links its dotfiles into the home dir if it has
+// none. The home dir isn't persisted, so it's done every time the editor starts.
+func (a *Agent) installDotfiles(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, a.cfg.DotfilesTimeout)
+	defer cancel()
+
+	logger := a.logger.WithField("repo", a.cfg.DotfilesRepo)
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		logger.WithError(err).Info("error: fail to install dotfiles")
+		a.setDotfiles(TaskFailed, "", err)
+		return
+	}
+
+	dir := filepath.Join(home, "dotfiles")
+	if _, err := os.Stat(dir); err != nil {
+		logger.Info("Cloning dotfiles")
+		a.setDotfiles(TaskRunning, "clone", nil)
+
+		if err := git(ctx, home, "clone", "--depth", "1", "--", a.cfg.DotfilesRepo, dir); err != nil {
+			logger.WithError(err).Info("error: fail to clone dotfiles")
+			a.setDotfiles(TaskFailed, "clone", err)
+			return
+		}
+	}
+
+	script := a.cfg.DotfilesInstall
+	if script == "" {
+		for _, s := range dotfilesScripts {
+			if fi, err := os.Stat(filepath.Join(dir, s)); err == nil && fi.Mode().IsRegular() {
+				script = s
+				break
+			}
+		}
+	}
+
+	if script == "" {
+		logger.Info("Linking dotfiles")
+		a.setDotfiles(TaskRunning, "link", nil)
+
+		if err := linkDotfiles(dir, home); err != nil {
+			logger.WithError(err).Info("error: fail to link dotfiles")
+			a.setDotfiles(TaskFailed, "link", err)
+			return
+		}
+
+		a.setDotfiles(TaskSucceeded, "", nil)
+		return
+	}
+
+	logger = logger.WithField("script", script)
+	logger.Info("Running dotfiles install script")
+	a.setDotfiles(TaskRunning, script, nil)
+
+	if err := installScript(ctx, dir, script).Run(); err != nil {
+		logger.WithError(err).Info("error: fail to run dotfiles install script")
+		a.setDotfiles(TaskFailed, script, err)
+		return
+	}
+
+	a.setDotfiles(TaskSucceeded, "", nil)
+}
+
+// installScript runs an executable script as is, and others with bash
+func installScript(ctx context.Context, dir, script string) *exec.Cmd {
+	path := filepath.Join(dir, filepath.FromSlash(script))
+
+	var cmd *exec.Cmd
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&0111 != 0 {
+		cmd = exec.CommandContext(ctx, path)
+	} else {
+		cmd = exec.CommandContext(ctx, "bash", path)
+	}
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd
+}
+
+// linkDotfiles links the top level dotfiles of dir into home, keeping the
+// files that are already there
+func linkDotfiles(dir, home string) error {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range fis {
+		name := fi.Name()
+		if name[0] != '.' || name == ".git" || name == ".github" {
+			continue
+		}
+
+		target := filepath.Join(home, name)
+		if _, err := os.Lstat(target); err == nil {
+			continue
+		}
+
+		if err := os.Symlink(filepath.Join(dir, name), target); err != nil {
+			return fmt.Errorf("error: fail to link %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
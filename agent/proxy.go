@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jingweno/codeface/token"
+)
+
+const (
+	authPath = "/_codeface/auth"
+	// portsPath serves the forwarded ports of the repo, e.g. /_codeface/ports/3000/
+	portsPath     = "/_codeface/ports/"
+	sessionCookie = "codeface_session"
+	sessionTTL    = 12 * time.Hour
+)
+
+// proxy lets requests through to code-server only with a session
+// established by an access token from the server
+type proxy struct {
+	editorID  string
+	key       []byte
+	serverURL string
+	upstream  http.Handler
+	ports     map[string]http.Handler
+}
+
+func newProxy(cfg Config, upstream *url.URL) *proxy {
+	ports := make(map[string]http.Handler)
+	for _, port := range cfg.ForwardPorts {
+		prefix := portsPath + strconv.Itoa(port)
+		target := &url.URL{Scheme: "http", Host: "127.0.0.1:" + strconv.Itoa(port)}
+		ports[strconv.Itoa(port)] = http.StripPrefix(prefix, httputil.NewSingleHostReverseProxy(target))
+	}
+
+	return &proxy{
+		editorID:  cfg.EditorID,
+		key:       []byte(cfg.EditorToken),
+		serverURL: strings.TrimRight(cfg.ServerURL, "/"),
+		upstream:  httputil.NewSingleHostReverseProxy(upstream),
+		ports:     ports,
+	}
+}
+
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == authPath {
+		p.authenticate(w, r)
+		return
+	}
+
+	c, err := r.Cookie(sessionCookie)
+	if err == nil {
+		if _, err := token.Verify(p.key, c.Value, p.editorID, token.PurposeSession); err == nil {
+			p.serve(w, r)
+			return
+		}
+	}
+
+	p.login(w, r)
+}
+
+func (p *proxy) serve(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, portsPath) {
+		p.upstream.ServeHTTP(w, r)
+		return
+	}
+
+	port := strings.SplitN(strings.TrimPrefix(r.URL.Path, portsPath), "/", 2)[0]
+	h, ok := p.ports[port]
+	if !ok {
+		http.Error(w, "Port is not forwarded", http.StatusNotFound)
+		return
+	}
+
+	h.ServeHTTP(w, r)
+}
+
+// authenticate exchanges an access token for a session cookie
+func (p *proxy) authenticate(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	claims, err := token.Verify(p.key, q.Get("token"), p.editorID, token.PurposeAccess)
+	if err == token.ErrExpired {
+		p.login(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	session, err := token.Sign(p.key, token.Claims{
+		Editor:  claims.Editor,
+		Email:   claims.Email,
+		Purpose: token.PurposeSession,
+	}, sessionTTL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    session,
+		Path:     "/",
+		MaxAge:   int(sessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	next := q.Get("next")
+	if !isLocalPath(next) {
+		next = "/"
+	}
+
+	http.Redirect(w, r, next, http.StatusFound)
+}
+
+// login sends the user to the server, which logs them in and sends them back with an access token
+func (p *proxy) login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || r.Header.Get("Upgrade") != "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	next := r.URL.RequestURI()
+	if r.URL.Path == authPath {
+		next = r.URL.Query().Get("next")
+	}
+
+	u := p.serverURL + "/editors/" + url.PathEscape(p.editorID) + "/open"
+	if isLocalPath(next) && next != "/" {
+		u += "?" + url.Values{"next": {next}}.Encode()
+	}
+
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// isLocalPath prevents redirecting to another host after authenticating
+func isLocalPath(s string) bool {
+	return strings.HasPrefix(s, "/") && !strings.HasPrefix(s, "//") && !strings.HasPrefix(s, "/\\")
+}
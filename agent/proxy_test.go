@@ -0,0 +1,142 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jingweno/codeface/token"
+)
+
+func TestProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("code-server"))
+	}))
+	defer upstream.Close()
+
+	u, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const key = "editor-token"
+	p := newProxy(Config{
+		EditorID:    "editor1",
+		EditorToken: key,
+		ServerURL:   "https://codeface.example.com",
+	}, u)
+
+	sign := func(k, editor, purpose string, ttl time.Duration) string {
+		s, err := token.Sign([]byte(k), token.Claims{Editor: editor, Email: "jane@example.com", Purpose: purpose}, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	auth := func(tok, next string) string {
+		return authPath + "?" + url.Values{"token": {tok}, "next": {next}}.Encode()
+	}
+
+	cases := []struct {
+		name     string
+		path     string
+		session  string
+		status   int
+		location string
+	}{
+		{
+			name:     "no session",
+			path:     "/?folder=/home/dyno/project",
+			status:   http.StatusFound,
+			location: "https://codeface.example.com/editors/editor1/open?next=%2F%3Ffolder%3D%2Fhome%2Fdyno%2Fproject",
+		},
+		{
+			name:    "session",
+			path:    "/",
+			session: sign(key, "editor1", token.PurposeSession, time.Hour),
+			status:  http.StatusOK,
+		},
+		{
+			name:     "expired session",
+			path:     "/",
+			session:  sign(key, "editor1", token.PurposeSession, -time.Second),
+			status:   http.StatusFound,
+			location: "https://codeface.example.com/editors/editor1/open",
+		},
+		{
+			name:     "session of another editor",
+			path:     "/",
+			session:  sign(key, "editor2", token.PurposeSession, time.Hour),
+			status:   http.StatusFound,
+			location: "https://codeface.example.com/editors/editor1/open",
+		},
+		{
+			name:     "access token as a session",
+			path:     "/",
+			session:  sign(key, "editor1", token.PurposeAccess, time.Hour),
+			status:   http.StatusFound,
+			location: "https://codeface.example.com/editors/editor1/open",
+		},
+		{
+			name:     "access token",
+			path:     auth(sign(key, "editor1", token.PurposeAccess, time.Minute), "/?folder=x"),
+			status:   http.StatusFound,
+			location: "/?folder=x",
+		},
+		{
+			name:     "access token with a redirect to another host",
+			path:     auth(sign(key, "editor1", token.PurposeAccess, time.Minute), "//evil.example.com"),
+			status:   http.StatusFound,
+			location: "/",
+		},
+		{
+			name:     "expired access token",
+			path:     auth(sign(key, "editor1", token.PurposeAccess, -time.Second), "/?folder=x"),
+			status:   http.StatusFound,
+			location: "https://codeface.example.com/editors/editor1/open?next=%2F%3Ffolder%3Dx",
+		},
+		{
+			name:   "access token signed with another key",
+			path:   auth(sign("other-key", "editor1", token.PurposeAccess, time.Minute), "/"),
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "session as an access token",
+			path:   auth(sign(key, "editor1", token.PurposeSession, time.Minute), "/"),
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, c.path, nil)
+			if c.session != "" {
+				r.AddCookie(&http.Cookie{Name: sessionCookie, Value: c.session})
+			}
+			w := httptest.NewRecorder()
+
+			p.ServeHTTP(w, r)
+
+			if w.Code != c.status {
+				t.Fatalf("want status %d, got %d", c.status, w.Code)
+			}
+			if c.location != "" && w.Header().Get("Location") != c.location {
+				t.Errorf("want redirect to %s, got %s", c.location, w.Header().Get("Location"))
+			}
+
+			// a valid access token is exchanged for a session
+			if strings.HasPrefix(c.path, authPath) && c.location != "" && !strings.HasPrefix(c.location, "https://") {
+				cookies := w.Result().Cookies()
+				if len(cookies) != 1 {
+					t.Fatalf("want a session cookie, got %v", cookies)
+				}
+				if _, err := token.Verify([]byte(key), cookies[0].Value, "editor1", token.PurposeSession); err != nil {
+					t.Errorf("want a valid session, got %s", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+)
+
+// codefaceExtension is installed in the image of every editor
+const codefaceExtension = "jingweno.codeface"
+
+// pushSettings sends the settings changed in the editor to the server after
+// code-server is stopped, by the shutdown deadline
+func (a *Agent) pushSettings(ctx context.Context, ownerExts []string) {
+	installed, err := a.listExtensions(ctx)
+	if err != nil {
+		a.logger.WithError(err).Info("error: fail to list extensions")
+		return
+	}
+
+	if _, err := a.settings.Push(ctx, a.ownerExtensions(installed, ownerExts)); err != nil {
+		a.logger.WithError(err).Info("error: fail to push settings")
+	}
+}
+
+func (a *Agent) listExtensions(ctx context.Context) ([]string, error) {
+	out, err := exec.CommandContext(ctx, a.cfg.CodeServer, "--list-extensions").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var exts []string
+	for _, l := range bytes.Split(out, []byte("\n")) {
+		if ext := strings.TrimSpace(string(l)); ext != "" {
+			exts = append(exts, ext)
+		}
+	}
+
+	return exts, nil
+}
+
+// ownerExtensions are the installed extensions except the ones installed for
+// the repo or the image, unless the owner has them too
+func (a *Agent) ownerExtensions(installed, ownerExts []string) []string {
+	var exts []string
+	for _, ext := range installed {
+		if strings.EqualFold(ext, codefaceExtension) {
+			continue
+		}
+		if containsFold(a.cfg.Extensions, ext) && !containsFold(ownerExts, ext) {
+			continue
+		}
+
+		exts = append(exts, ext)
+	}
+
+	return exts
+}
+
+func containsFold(exts []string, ext string) bool {
+	for _, e := range exts {
+		if strings.EqualFold(e, ext) {
+			return true
+		}
+	}
+
+	return false
+}
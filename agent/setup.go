@@ -0,0 +1,139 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/jingweno/codeface/repoconfig"
+)
+
+// installExtensions installs the extensions of the repo and the owner before
+// code-server starts so that they are loaded in the first window
+func (a *Agent) installExtensions(ctx context.Context, exts []string) {
+	for _, ext := range exts {
+		logger := a.logger.WithField("extension", ext)
+		logger.Info("Installing extension")
+
+		cmd := exec.CommandContext(ctx, a.cfg.CodeServer, "--install-extension", ext)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			logger.WithError(err).Info("error: fail to install extension")
+		}
+	}
+}
+
+// setupSteps returns the setup steps of .codeface.yml and then the
+// postCreateCommand of devcontainer.json
+func (a *Agent) setupSteps() ([]repoconfig.Step, error) {
+	var steps []repoconfig.Step
+	if a.cfg.Setup != "" {
+		if err := json.Unmarshal([]byte(a.cfg.Setup), &steps); err != nil {
+			return nil, err
+		}
+	}
+	if a.cfg.PostCreateCommand != "" {
+		steps = append(steps, repoconfig.Step{Name: "postCreateCommand", Run: a.cfg.PostCreateCommand})
+	}
+
+	return steps, nil
+}
+
+// proposeSetup waits for the owner to confirm the setup steps before they
+// run in the repo dir. They come from the repository and run as the owner,
+// with access to everything in the editor.
+func (a *Agent) proposeSetup(dir string) {
+	steps, err := a.setupSteps()
+	if err != nil {
+		a.logger.WithError(err).Info("error: fail to parse setup steps")
+		a.setSetup(TaskFailed, "", err)
+		return
+	}
+
+	var commands []string
+	for _, step := range steps {
+		commands = append(commands, step.Run)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.setupDir = dir
+	a.status.Setup = TaskStatus{State: TaskPending, Commands: commands}
+}
+
+// confirmSetup runs the setup steps if they're waiting for the owner
+func (a *Agent) confirmSetup(ctx context.Context) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.status.Setup.State != TaskPending {
+		return false
+	}
+	a.status.Setup = TaskStatus{State: TaskRunning}
+
+	go a.setup(ctx, a.setupDir)
+
+	return true
+}
+
+// setup runs the setup steps in the repo dir and stops at the first failure
+func (a *Agent) setup(ctx context.Context, dir string) {
+	steps, err := a.setupSteps()
+	if err != nil {
+		a.logger.WithError(err).Info("error: fail to parse setup steps")
+		a.setSetup(TaskFailed, "", err)
+		return
+	}
+
+	for i, step := range steps {
+		name := step.Name
+		if name == "" {
+			name = fmt.Sprintf("step %d", i+1)
+		}
+
+		logger := a.logger.WithField("step", name)
+		logger.Info("Running setup step")
+		a.setSetup(TaskRunning, name, nil)
+
+		if err := command(ctx, dir, step.Run).Run(); err != nil {
+			logger.WithError(err).Info("error: fail to run setup step")
+			a.setSetup(TaskFailed, name, err)
+			return
+		}
+	}
+
+	a.setSetup(TaskSucceeded, "", nil)
+}
+
+// credentialVars authenticate the editor and its owner. They're left out of
+// the environment of the setup steps so that they don't end up in the logs
+// of a build script. It's not a boundary, the steps run as the same user as
+// the agent.
+var credentialVars = map[string]bool{
+	"CODEFACE_EDITOR_TOKEN": true,
+	"PASSWORD":              true,
+}
+
+func command(ctx context.Context, dir, s string) *exec.Cmd {
+	var args []string
+	if !strings.HasPrefix(s, "[") || json.Unmarshal([]byte(s), &args) != nil || len(args) == 0 {
+		args = []string{"bash", "-c", s}
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	for _, kv := range os.Environ() {
+		if !credentialVars[strings.SplitN(kv, "=", 2)[0]] {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+
+	return cmd
+}
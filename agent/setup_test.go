@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codeface-setup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("CODEFACE_EDITOR_TOKEN", "editor-token")
+	defer os.Unsetenv("CODEFACE_EDITOR_TOKEN")
+
+	a := New(Config{
+		Setup:             `[{"name":"build","run":"echo built > built"}]`,
+		PostCreateCommand: `echo "token=$CODEFACE_EDITOR_TOKEN" > env`,
+	}, nil, nil)
+	h := a.statusHandler(context.Background())
+
+	post := func(confirm bool) int {
+		r := httptest.NewRequest(http.MethodPost, "/setup", nil)
+		if confirm {
+			r.Header.Set(setupConfirmHeader, "true")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// nothing runs until the owner confirms it
+	if code := post(true); code != http.StatusConflict {
+		t.Fatalf("want no setup pending, got status %d", code)
+	}
+
+	a.proposeSetup(dir)
+	status := a.Status().Setup
+	if status.State != TaskPending || strings.Join(status.Commands, "\n") != "echo built > built\n"+`echo "token=$CODEFACE_EDITOR_TOKEN" > env` {
+		t.Fatalf("want the commands pending, got %+v", status)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "built")); !os.IsNotExist(err) {
+		t.Fatal("want setup not to run before it's confirmed")
+	}
+
+	if code := post(false); code != http.StatusBadRequest {
+		t.Fatalf("want the confirm header required, got status %d", code)
+	}
+	if code := post(true); code != http.StatusAccepted {
+		t.Fatalf("want setup confirmed, got status %d", code)
+	}
+	if code := post(true); code != http.StatusConflict {
+		t.Fatalf("want setup confirmed once, got status %d", code)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for a.Status().Setup.State != TaskSucceeded {
+		if time.Now().After(deadline) {
+			t.Fatalf("want setup to succeed, got %+v", a.Status().Setup)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "built")); err != nil || string(b) != "built\n" {
+		t.Errorf("want the setup step run, got %q, %v", b, err)
+	}
+	// the token of the editor is left out of the environment of the steps
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "env")); err != nil || string(b) != "token=\n" {
+		t.Errorf("want no editor token, got %q, %v", b, err)
+	}
+}
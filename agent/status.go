@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const (
+	RepoNone    = "none"
+	RepoCloning = "cloning"
+	RepoCloned  = "cloned"
+	RepoFailed  = "failed"
+
+	ProcessStarting = "starting"
+	ProcessRunning  = "running"
+	ProcessExited   = "exited"
+	ProcessStopped  = "stopped"
+
+	TaskNone = "none"
+	// TaskPending waits for the owner to confirm it
+	TaskPending   = "pending"
+	TaskRunning   = "running"
+	TaskSucceeded = "succeeded"
+	TaskFailed    = "failed"
+)
+
+// Status is served by the local status API
+type Status struct {
+	StartedAt  time.Time
+	Repo       RepoStatus
+	CodeServer ProcessStatus
+	// Setup runs the setup steps and post create command of the repo
+	Setup TaskStatus
+	// Dotfiles clones the dotfiles repo of the owner and runs its install script
+	Dotfiles TaskStatus
+}
+
+type RepoStatus struct {
+	URL   string
+	Ref   string `json:",omitempty"`
+	Dir   string
+	State string
+	Error string `json:",omitempty"`
+}
+
+type ProcessStatus struct {
+	State     string
+	PID       int       `json:",omitempty"`
+	StartedAt time.Time `json:",omitempty"`
+	Restarts  int
+	LastExit  string `json:",omitempty"`
+}
+
+type TaskStatus struct {
+	State string
+	// Step is running or failed
+	Step  string `json:",omitempty"`
+	Error string `json:",omitempty"`
+	// Commands are shown to the owner to confirm a pending task
+	Commands []string `json:",omitempty"`
+}
+
+// Status returns a copy of the current status
+func (a *Agent) Status() Status {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.status
+}
+
+// setupConfirmHeader must be set to confirm the setup, so that a form of
+// another site can't post it through the port forwarding of code-server
+const setupConfirmHeader = "Codeface-Confirm"
+
+func (a *Agent) statusHandler(ctx context.Context) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(a.Status())
+	})
+	// the Codeface extension asks the owner to confirm the setup steps
+	mux.HandleFunc("/setup", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get(setupConfirmHeader) != "true" {
+			http.Error(w, "POST with a Codeface-Confirm: true header to run the setup steps", http.StatusBadRequest)
+			return
+		}
+
+		if !a.confirmSetup(ctx) {
+			http.Error(w, "No setup steps are pending", http.StatusConflict)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	return mux
+}
+
+func (a *Agent) setRepo(state, dir string, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.status.Repo.State = state
+	a.status.Repo.Dir = dir
+	a.status.Repo.Error = ""
+	if err != nil {
+		a.status.Repo.Error = err.Error()
+	}
+}
+
+func (a *Agent) setProcessRunning(pid int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.status.CodeServer.State = ProcessRunning
+	a.status.CodeServer.PID = pid
+	a.status.CodeServer.StartedAt = time.Now()
+}
+
+func (a *Agent) setProcessExited(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.status.CodeServer.State = ProcessExited
+	a.status.CodeServer.PID = 0
+	a.status.CodeServer.Restarts++
+	if err != nil {
+		a.status.CodeServer.LastExit = err.Error()
+	}
+}
+
+func (a *Agent) setProcessStopped() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.status.CodeServer.State = ProcessStopped
+	a.status.CodeServer.PID = 0
+}
+
+func (a *Agent) setSetup(state, step string, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.status.Setup.State = state
+	a.status.Setup.Step = step
+	a.status.Setup.Error = ""
+	if err != nil {
+		a.status.Setup.Error = err.Error()
+	}
+}
+
+func (a *Agent) setDotfiles(state, step string, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.status.Dotfiles.State = state
+	a.status.Dotfiles.Step = step
+	a.status.Dotfiles.Error = ""
+	if err != nil {
+		a.status.Dotfiles.Error = err.Error()
+	}
+}
@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+// a run longer than this is considered healthy and resets the backoff
+const healthyRun = time.Minute
+
+// supervise runs code-server and restarts it with a backoff when it exits,
+// until ctx is done
+func (a *Agent) supervise(ctx context.Context, folder string) error {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = time.Second
+	b.MaxInterval = time.Minute
+	b.MaxElapsedTime = 0 // never give up
+
+	for {
+		started := time.Now()
+		err := a.runCodeServer(ctx, folder)
+		if ctx.Err() != nil {
+			a.setProcessStopped()
+			return nil
+		}
+
+		if time.Since(started) > healthyRun {
+			b.Reset()
+		}
+		wait := b.NextBackOff()
+
+		a.setProcessExited(err)
+		a.logger.WithError(err).WithField("restart_in", wait).Info("error: code-server exits")
+
+		select {
+		case <-ctx.Done():
+			a.setProcessStopped()
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (a *Agent) runCodeServer(ctx context.Context, folder string) error {
+	cmd := exec.Command(a.cfg.CodeServer, a.codeServerArgs(folder)...)
+	cmd.Dir = folder
+	if a.cfg.OpenFile != "" {
+		// the extension opens the file
+		cmd.Env = append(os.Environ(), "CODEFACE_OPEN_FILE="+filepath.Join(folder, a.cfg.OpenFile))
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	a.setProcessRunning(cmd.Process.Pid)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			return fmt.Errorf("error: code-server exits unexpectedly")
+		}
+		return err
+	case <-ctx.Done():
+	}
+
+	// give code-server a chance to flush its state before killing it, and
+	// leave the rest of the shutdown for the last snapshot
+	wait := time.Until(a.shutdownDeadline())
+	if wait > a.cfg.CodeServerStopTimeout {
+		wait = a.cfg.CodeServerStopTimeout
+	}
+	a.logger.Info("Stopping code-server")
+	cmd.Process.Signal(syscall.SIGTERM)
+
+	select {
+	case <-done:
+	case <-time.After(wait):
+		a.logger.Info("Killing code-server")
+		cmd.Process.Kill()
+		<-done
+	}
+
+	return nil
+}
+
+func (a *Agent) codeServerArgs(folder string) []string {
+	// never expose code-server without authentication
+	bind, auth := "0.0.0.0:"+a.cfg.Port, "password"
+	if a.cfg.EditorToken != "" {
+		bind, auth = "127.0.0.1:"+a.cfg.CodeServerPort, "none"
+	}
+
+	return []string{
+		"--bind-addr", bind,
+		"--disable-telemetry",
+		"--disable-updates",
+		"--auth", auth,
+		folder,
+	}
+}
@@ -0,0 +1,59 @@
+package command
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jingweno/codeface/agent"
+	"github.com/jingweno/codeface/settings"
+	"github.com/jingweno/codeface/workspace"
+	"github.com/joeshaw/envdecode"
+	"github.com/spf13/cobra"
+)
+
+func agentCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "agent",
+		Short: "Start the agent that runs inside an editor",
+		RunE:  agentRunE,
+	}
+}
+
+func agentRunE(c *cobra.Command, args []string) error {
+	var cfg agent.Config
+	if err := envdecode.StrictDecode(&cfg); err != nil {
+		return err
+	}
+
+	// the workspace and settings are only persisted when the editor is
+	// registered with a server
+	var (
+		ws *workspace.Workspace
+		st *settings.Settings
+	)
+	if os.Getenv("CODEFACE_EDITOR_ID") != "" {
+		var err error
+		if ws, err = newWorkspace(); err != nil {
+			return err
+		}
+
+		var stCfg settings.Config
+		if err := envdecode.StrictDecode(&stCfg); err != nil {
+			return err
+		}
+		st = settings.New(stCfg)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-sigs
+		cancel()
+	}()
+
+	return agent.New(cfg, ws, st).Run(ctx)
+}
@@ -2,7 +2,10 @@ package command
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"strings"
 
 	"github.com/jingweno/codeface/editor"
 	"github.com/pkg/browser"
@@ -13,8 +16,25 @@ var (
 	appIdentity string
 	recipient   string
 	gitRepo     string
+	dotfiles    string
+	// recipientToken checks the apps of the recipient
+	recipientToken string
+	appLimit       int
+	checkOnly      bool
 )
 
+// passwordVar is the password of code-server
+const passwordVar = "PASSWORD"
+
+func newPassword() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
 func claimCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "claim",
@@ -23,25 +43,69 @@ func claimCmd() *cobra.Command {
 	}
 
 	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", "", "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&herokuTeam, "team", "", "", "Heroku Team of the editors (optional)")
 	cmd.PersistentFlags().StringVarP(&appIdentity, "app", "a", "", "Heroku app identity (optional)")
 	cmd.PersistentFlags().StringVarP(&recipient, "recipient", "r", "", "recipient (required)")
 	cmd.PersistentFlags().StringVarP(&gitRepo, "git", "g", "", "Git repository (required)")
+	cmd.PersistentFlags().StringVarP(&dotfiles, "dotfiles", "", "", "dotfiles repository of the recipient (optional)")
+	cmd.PersistentFlags().StringVarP(&recipientToken, "recipient-token", "", "", "Heroku API token of the recipient to check their apps (optional)")
+	cmd.PersistentFlags().IntVarP(&appLimit, "app-limit", "", editor.DefaultAppLimit, "max number of apps the recipient can own, 0 skips the check")
+	cmd.PersistentFlags().BoolVarP(&checkOnly, "check", "", false, "only check that the recipient can own an editor")
 
 	return cmd
 }
 
 func claimRunE(c *cobra.Command, args []string) error {
-	if herokuAPIToken == "" || recipient == "" || gitRepo == "" {
+	if herokuAPIToken == "" || recipient == "" || (gitRepo == "" && !checkOnly) {
 		return fmt.Errorf("missing required flags")
 	}
 
-	t := editor.NewClaimer(herokuAPIToken)
-	app, err := t.Claim(context.Background(), appIdentity, recipient, gitRepo)
+	// editors claimed without the server can't be managed
+	ownership, err := editor.ParseOwnership("", herokuTeam)
+	if err != nil {
+		return err
+	}
+
+	t := editor.NewClaimer(herokuAPIToken, ownership, herokuTeam)
+	t.AppLimit = appLimit
+	if checkOnly {
+		check, err := t.CheckRecipient(context.Background(), recipient, recipientToken)
+		if err != nil {
+			if e, ok := err.(*editor.RecipientError); ok {
+				return fmt.Errorf("%s (%s)", e.Error(), e.Code)
+			}
+
+			return err
+		}
+
+		// only the recipient can look up their account and apps
+		if !check.Verified() {
+			return fmt.Errorf("error: fail to verify that %s can own an editor, skipped checks: %s. Pass --recipient-token with a token of the recipient", recipient, strings.Join(check.Skipped, ", "))
+		}
+
+		fmt.Printf("%s can own an editor\n", recipient)
+		return nil
+	}
+
+	// editors claimed without the server aren't accessible with a token it
+	// signs, so code-server asks for a password
+	password, err := newPassword()
+	if err != nil {
+		return err
+	}
+
+	app, err := t.Claim(context.Background(), appIdentity, recipient, editor.ClaimOptions{
+		GitRepo:        gitRepo,
+		Vars:           map[string]string{passwordVar: password},
+		DotfilesRepo:   dotfiles,
+		RecipientToken: recipientToken,
+	})
 	if err != nil {
 		return err
 	}
 
 	url := editor.EditorAppURL(app)
 	fmt.Printf("Visit %s\n", url)
+	fmt.Printf("Password: %s (the %s config var of %s)\n", password, passwordVar, app.Name)
 	return browser.OpenURL(url)
 }
@@ -0,0 +1,70 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/jingweno/codeface/model"
+)
+
+var (
+	serverURL string
+)
+
+// defaultServerURL is set in editors claimed through the server
+func defaultServerURL() string {
+	return os.Getenv("CODEFACE_SERVER_URL")
+}
+
+// serverDo sends a request to the server authenticated with the Heroku API
+// token and decodes the response into out
+func serverDo(method, path string, in, out interface{}) error {
+	if herokuAPIToken == "" || serverURL == "" {
+		return fmt.Errorf("missing required flags")
+	}
+
+	var body []byte
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = b
+	}
+
+	u := strings.TrimRight(serverURL, "/") + path
+	req, err := http.NewRequestWithContext(context.Background(), method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+herokuAPIToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var e model.ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("error: unexpected response from server status=%d", resp.StatusCode)
+		}
+
+		// errors of the server may already be prefixed
+		return fmt.Errorf("error: %s", strings.TrimPrefix(e.Error, "error: "))
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
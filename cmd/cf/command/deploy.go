@@ -16,6 +16,7 @@ func deployCmd() *cobra.Command {
 	}
 
 	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", "", "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&herokuTeam, "team", "", "", "Heroku Team of the editors (optional)")
 	cmd.PersistentFlags().StringVarP(&templateDir, "template", "", "./template", "deployment template directory")
 
 	return cmd
@@ -26,7 +27,7 @@ func deployRunE(c *cobra.Command, args []string) error {
 		return fmt.Errorf("missing required flags")
 	}
 
-	d := editor.NewDeployer(herokuAPIToken, templateDir)
+	d := editor.NewDeployer(herokuAPIToken, templateDir, herokuTeam)
 	app, err := d.DeployEditorAndScaleDown(context.Background())
 	if err != nil {
 		return err
@@ -0,0 +1,47 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/jingweno/codeface/agent"
+	"github.com/joeshaw/envdecode"
+	"github.com/spf13/cobra"
+)
+
+func gitCredentialCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:    "git-credential <get|store|erase>",
+		Short:  "Get a token to clone and push the repository of an editor, used as a git credential helper",
+		Hidden: true,
+		Args:   cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			// git writes the attributes of the credential to stdin
+			if _, err := io.Copy(ioutil.Discard, os.Stdin); err != nil {
+				return err
+			}
+
+			// tokens expire on their own, there's nothing to store or erase
+			if args[0] != "get" {
+				return nil
+			}
+
+			var cfg agent.CredentialConfig
+			if err := envdecode.StrictDecode(&cfg); err != nil {
+				return err
+			}
+
+			cred, err := agent.GitCredential(context.Background(), cfg)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("username=%s\npassword=%s\n", cred.Username, cred.Password)
+
+			return nil
+		},
+	}
+}
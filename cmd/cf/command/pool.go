@@ -0,0 +1,188 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/worker"
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+var (
+	output   string
+	outdated bool
+)
+
+func poolCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pool",
+		Short: "Operate the pool of idle Codeface apps",
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if output != outputText && output != outputJSON {
+				return fmt.Errorf("unsupported output format %q", output)
+			}
+			return nil
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&templateDir, "template", "", defaultTemplateDir(), "deployment template directory")
+	cmd.PersistentFlags().StringVarP(&output, "output", "o", outputText, "output format: text or json")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show apps in the pool by state and template version",
+		Long: `Show apps in the pool by state and template version, and the breaker that
+pauses pool fills after consecutive build failures.
+
+The breaker is only shown if the worker shares it through DATABASE_URL or
+LOCK_BACKEND=file, with the same settings here. Otherwise it's kept in the
+memory of the worker, which exposes it as the codeface_breaker_open metric
+on its METRICS_PORT.`,
+		RunE: poolStatusRunE,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "fill",
+		Short: "Deploy apps until the pool is full",
+		RunE:  poolFillRunE,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "drain",
+		Short: "Remove all idle apps from the pool",
+		RunE:  poolDrainRunE,
+	})
+
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove idle apps from the pool",
+		RunE:  poolPruneRunE,
+	}
+	pruneCmd.Flags().BoolVarP(&outdated, "outdated", "", false, "remove idle apps built from an outdated template version (required)")
+	pruneCmd.MarkFlagRequired("outdated")
+	cmd.AddCommand(pruneCmd)
+
+	return cmd
+}
+
+func poolStatusRunE(c *cobra.Command, args []string) error {
+	w, err := newWorker()
+	if err != nil {
+		return err
+	}
+
+	status, err := w.Status(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if output == outputJSON {
+		return printJSON(status)
+	}
+
+	fmt.Printf("Current version: %s\n", status.CurrentVersion)
+	fmt.Printf("Pool size: %d (%s)\n", status.PoolSize, status.PoolSizeReason)
+	if b := status.Breaker; b != nil {
+		fmt.Printf("Breaker: %s", b.State)
+		if b.State == worker.BreakerOpen {
+			fmt.Printf(" until %s", b.OpenUntil.Format(time.RFC3339))
+		}
+		if b.ConsecutiveFailures > 0 {
+			fmt.Printf(" (%d consecutive failure(s), last: %s)", b.ConsecutiveFailures, b.LastError)
+		}
+		fmt.Println()
+	} else {
+		fmt.Println("Breaker: not shared with this process. Set DATABASE_URL or LOCK_BACKEND=file for the worker and here, or see the codeface_breaker_open metric of the worker")
+	}
+	if status.OldestIdle != nil {
+		fmt.Printf("Oldest idle app: %s (version %s, age %s)\n", status.OldestIdle.Name, status.OldestIdle.Version, status.OldestIdle.Age)
+	}
+	fmt.Println()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATE\tVERSION\tCOUNT")
+	for _, c := range status.Counts {
+		fmt.Fprintf(tw, "%s\t%s\t%d\n", c.State, c.Version, c.Count)
+	}
+
+	return tw.Flush()
+}
+
+func poolFillRunE(c *cobra.Command, args []string) error {
+	w, err := newWorker()
+	if err != nil {
+		return err
+	}
+
+	apps, err := w.Fill(context.Background())
+	if perr := printApps("Added", apps); perr != nil {
+		return perr
+	}
+
+	return err
+}
+
+func poolDrainRunE(c *cobra.Command, args []string) error {
+	w, err := newWorker()
+	if err != nil {
+		return err
+	}
+
+	apps, err := w.Drain(context.Background())
+	if perr := printApps("Removed", apps); perr != nil {
+		return perr
+	}
+
+	return err
+}
+
+func poolPruneRunE(c *cobra.Command, args []string) error {
+	if !outdated {
+		// --outdated=false passes the required flag check
+		return fmt.Errorf("--outdated is required, only outdated apps can be pruned")
+	}
+
+	w, err := newWorker()
+	if err != nil {
+		return err
+	}
+
+	apps, err := w.PruneOutdated(context.Background())
+	if perr := printApps("Removed", apps); perr != nil {
+		return perr
+	}
+
+	return err
+}
+
+func printApps(action string, apps []heroku.App) error {
+	names := make([]string, 0, len(apps))
+	for _, app := range apps {
+		names = append(names, app.Name)
+	}
+
+	if output == outputJSON {
+		return printJSON(map[string][]string{"apps": names})
+	}
+
+	fmt.Printf("%s %d app(s)\n", action, len(names))
+	for _, name := range names {
+		fmt.Printf("  %s\n", name)
+	}
+
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
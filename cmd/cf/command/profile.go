@@ -0,0 +1,68 @@
+package command
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/jingweno/codeface/model"
+	"github.com/spf13/cobra"
+)
+
+var (
+	dotfilesInstall string
+)
+
+func profileCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Show or update the profile applied to claimed editors",
+		Args:  cobra.NoArgs,
+		RunE:  profileRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", "", "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", defaultServerURL(), "Codeface server URL (required)")
+	cmd.PersistentFlags().StringVarP(&dotfiles, "dotfiles", "", "", "dotfiles repository, \"none\" to remove it")
+	cmd.PersistentFlags().StringVarP(&dotfilesInstall, "dotfiles-install", "", "", "install script in the dotfiles repository")
+
+	return cmd
+}
+
+func profileRunE(c *cobra.Command, args []string) error {
+	var p model.Profile
+	if err := serverDo(http.MethodGet, "/profile", nil, &p); err != nil {
+		return err
+	}
+
+	if c.Flags().Changed("dotfiles") || c.Flags().Changed("dotfiles-install") {
+		if c.Flags().Changed("dotfiles") {
+			p.DotfilesRepo = dotfiles
+			if dotfiles == "none" {
+				p.DotfilesRepo = ""
+				p.DotfilesInstall = ""
+			}
+		}
+		if c.Flags().Changed("dotfiles-install") {
+			p.DotfilesInstall = dotfilesInstall
+		}
+
+		if err := serverDo(http.MethodPut, "/profile", p, &p); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Dotfiles: %s\n", orNone(p.DotfilesRepo))
+	if p.DotfilesInstall != "" {
+		fmt.Printf("Dotfiles install script: %s\n", p.DotfilesInstall)
+	}
+
+	return nil
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+
+	return s
+}
@@ -6,6 +6,7 @@ import (
 
 var (
 	herokuAPIToken string
+	herokuTeam     string
 )
 
 func Root() *cobra.Command {
@@ -18,6 +19,13 @@ func Root() *cobra.Command {
 	rootCmd.AddCommand(deployCmd())
 	rootCmd.AddCommand(workerCmd())
 	rootCmd.AddCommand(serverCmd())
+	rootCmd.AddCommand(poolCmd())
+	rootCmd.AddCommand(upgradeCmd())
+	rootCmd.AddCommand(workspaceCmd())
+	rootCmd.AddCommand(agentCmd())
+	rootCmd.AddCommand(gitCredentialCmd())
+	rootCmd.AddCommand(secretsCmd())
+	rootCmd.AddCommand(profileCmd())
 
 	return rootCmd
 }
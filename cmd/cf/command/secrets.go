@@ -0,0 +1,96 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jingweno/codeface/model"
+	"github.com/spf13/cobra"
+)
+
+var (
+	secretRepo string
+)
+
+func secretsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "secrets",
+		Short: "Manage secrets set as config vars of claimed editors",
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", "", "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", defaultServerURL(), "Codeface server URL (required)")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List secrets without their values",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			var list []model.Secret
+			if err := serverDo(http.MethodGet, "/secrets", nil, &list); err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tREPO\tUPDATED")
+			for _, s := range list {
+				repo := s.Repo
+				if repo == "" {
+					repo = "*"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", s.Name, repo, s.UpdatedAt.Format("2006-01-02 15:04"))
+			}
+
+			return w.Flush()
+		},
+	}
+
+	setCmd := &cobra.Command{
+		Use:   "set NAME [VALUE]",
+		Short: "Set a secret, reading the value from stdin if it's omitted",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(c *cobra.Command, args []string) error {
+			var value string
+			if len(args) == 2 {
+				value = args[1]
+			} else {
+				// keeps the value out of the shell history
+				b, err := ioutil.ReadAll(os.Stdin)
+				if err != nil {
+					return err
+				}
+				value = strings.TrimRight(string(b), "\r\n")
+			}
+
+			return serverDo(http.MethodPut, "/secrets/"+url.PathEscape(args[0]), model.SecretRequest{
+				Value: value,
+				Repo:  secretRepo,
+			}, nil)
+		},
+	}
+	setCmd.Flags().StringVarP(&secretRepo, "repo", "g", "", "limit the secret to a Git repository")
+
+	unsetCmd := &cobra.Command{
+		Use:   "unset NAME",
+		Short: "Remove a secret",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			path := "/secrets/" + url.PathEscape(args[0])
+			if secretRepo != "" {
+				path += "?repo=" + url.QueryEscape(secretRepo)
+			}
+
+			return serverDo(http.MethodDelete, path, nil, nil)
+		},
+	}
+	unsetCmd.Flags().StringVarP(&secretRepo, "repo", "g", "", "Git repository the secret is limited to")
+
+	cmd.AddCommand(listCmd, setCmd, unsetCmd)
+
+	return cmd
+}
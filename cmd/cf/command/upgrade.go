@@ -0,0 +1,105 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/spf13/cobra"
+)
+
+var (
+	upgradeAll bool
+	assumeYes  bool
+)
+
+func upgradeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "upgrade [editor]",
+		Short: "Upgrade a claimed Codeface editor to the current template version",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  upgradeRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", "", "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&herokuTeam, "team", "", "", "Heroku Team of the editors (optional)")
+	cmd.PersistentFlags().StringVarP(&templateDir, "template", "", defaultTemplateDir(), "deployment template directory")
+	cmd.PersistentFlags().BoolVarP(&upgradeAll, "all", "", false, "upgrade all outdated editors accessible to the token")
+	cmd.PersistentFlags().BoolVarP(&assumeYes, "yes", "y", false, "upgrade without confirmation")
+
+	return cmd
+}
+
+func upgradeRunE(c *cobra.Command, args []string) error {
+	if herokuAPIToken == "" {
+		return fmt.Errorf("missing required flags")
+	}
+
+	if upgradeAll == (len(args) > 0) {
+		return fmt.Errorf("specify either an editor or --all")
+	}
+
+	ctx := context.Background()
+	d := editor.NewDeployer(herokuAPIToken, templateDir, herokuTeam)
+
+	var apps []heroku.App
+	if upgradeAll {
+		outdated, err := d.OutdatedEditors(ctx)
+		if err != nil {
+			return err
+		}
+		apps = outdated
+	} else {
+		app, err := d.CheckUpgrade(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		apps = append(apps, *app)
+	}
+
+	if len(apps) == 0 {
+		fmt.Println("All editors are up to date")
+		return nil
+	}
+
+	fmt.Println(editor.UpgradeWarning)
+	for _, app := range apps {
+		fmt.Printf("  %s\n", app.Name)
+	}
+	if !assumeYes && !confirm("Upgrade the editor(s) above?") {
+		return fmt.Errorf("upgrade is cancelled")
+	}
+
+	var failed int
+	for _, app := range apps {
+		if _, err := d.Upgrade(ctx, app.Name); err != nil {
+			failed++
+			fmt.Printf("Fail to upgrade %s: %s\n", app.Name, err)
+			continue
+		}
+
+		fmt.Printf("Upgraded %s\n", app.Name)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("fail to upgrade %d editor(s)", failed)
+	}
+
+	return nil
+}
+
+func confirm(prompt string) bool {
+	fmt.Printf("%s [y/N] ", prompt)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
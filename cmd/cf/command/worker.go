@@ -14,6 +14,7 @@ import (
 
 var (
 	templateDir string
+	once        bool
 )
 
 func workerCmd() *cobra.Command {
@@ -23,18 +24,15 @@ func workerCmd() *cobra.Command {
 		RunE:  workerRunE,
 	}
 
-	pwd, err := os.Getwd()
-	if err != nil {
-		panic(err)
-	}
-	cmd.PersistentFlags().StringVarP(&templateDir, "template", "", filepath.Join(pwd, "template"), "deployment template directory")
+	cmd.PersistentFlags().StringVarP(&templateDir, "template", "", defaultTemplateDir(), "deployment template directory")
+	cmd.Flags().BoolVarP(&once, "once", "", false, "run a single reconciliation pass and exit")
 
 	return cmd
 }
 
 func workerRunE(c *cobra.Command, args []string) error {
-	var cfg worker.Config
-	if err := envdecode.StrictDecode(&cfg); err != nil {
+	w, err := newWorker()
+	if err != nil {
 		return err
 	}
 
@@ -47,8 +45,29 @@ func workerRunE(c *cobra.Command, args []string) error {
 		cancel()
 	}()
 
+	if once {
+		return w.RunOnce(ctx)
+	}
+
+	return w.Start(ctx)
+}
+
+func newWorker() (*worker.Worker, error) {
+	var cfg worker.Config
+	if err := envdecode.StrictDecode(&cfg); err != nil {
+		return nil, err
+	}
+
 	cfg.TemplateDir = templateDir
 
-	worker := worker.New(cfg)
-	return worker.Start(ctx)
+	return worker.New(cfg)
+}
+
+func defaultTemplateDir() string {
+	pwd, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+
+	return filepath.Join(pwd, "template")
 }
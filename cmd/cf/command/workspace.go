@@ -0,0 +1,91 @@
+package command
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jingweno/codeface/workspace"
+	"github.com/joeshaw/envdecode"
+	"github.com/spf13/cobra"
+)
+
+var (
+	syncInterval time.Duration
+)
+
+func workspaceCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "workspace",
+		Short: "Persist the workspace of an editor",
+	}
+
+	restoreCmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Restore the workspace from its last snapshot",
+		RunE: func(c *cobra.Command, args []string) error {
+			ws, err := newWorkspace()
+			if err != nil {
+				return err
+			}
+
+			err = ws.Restore(context.Background())
+			if err == workspace.ErrNoSnapshot {
+				return nil
+			}
+
+			return err
+		},
+	}
+
+	snapshotCmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Snapshot the workspace",
+		RunE: func(c *cobra.Command, args []string) error {
+			ws, err := newWorkspace()
+			if err != nil {
+				return err
+			}
+
+			return ws.Snapshot(context.Background())
+		},
+	}
+
+	syncCmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Snapshot the workspace periodically and on SIGTERM",
+		RunE: func(c *cobra.Command, args []string) error {
+			ws, err := newWorkspace()
+			if err != nil {
+				return err
+			}
+
+			sigs := make(chan os.Signal, 1)
+			signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				<-sigs
+				cancel()
+			}()
+
+			return ws.Sync(ctx, syncInterval)
+		},
+	}
+	syncCmd.Flags().DurationVarP(&syncInterval, "interval", "", 10*time.Minute, "interval between snapshots")
+
+	cmd.AddCommand(restoreCmd, snapshotCmd, syncCmd)
+
+	return cmd
+}
+
+func newWorkspace() (*workspace.Workspace, error) {
+	var cfg workspace.Config
+	if err := envdecode.StrictDecode(&cfg); err != nil {
+		return nil, err
+	}
+
+	return workspace.New(cfg), nil
+}
@@ -0,0 +1,217 @@
+// Package devcontainer applies the parts of a devcontainer.json that make
+// sense for an editor. Editors are claimed from a pool of apps built ahead of
+// time from the Codeface image, which is what makes claiming fast. An image
+// of the repository would have to be built when claiming, so anything about
+// the container itself is reported as unsupported.
+package devcontainer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jingweno/codeface/model"
+)
+
+// Paths are where a devcontainer.json is looked up, in order
+var Paths = []string{".devcontainer/devcontainer.json", ".devcontainer.json"}
+
+// containerKeys choose the container, which editors can't change
+var containerKeys = map[string]bool{
+	"image":             true,
+	"build":             true,
+	"dockerFile":        true,
+	"context":           true,
+	"dockerComposeFile": true,
+	"service":           true,
+	"runArgs":           true,
+	"mounts":            true,
+	"features":          true,
+}
+
+// ignoredKeys don't change how an editor works
+var ignoredKeys = map[string]bool{
+	"name":    true,
+	"$schema": true,
+}
+
+// Result is what an editor applies from a devcontainer.json
+type Result struct {
+	Vars map[string]string
+	// Warnings tell the user what isn't applied
+	Warnings []string
+}
+
+type config struct {
+	ContainerEnv      map[string]string `json:"containerEnv"`
+	ForwardPorts      []interface{}     `json:"forwardPorts"`
+	Extensions        []string          `json:"extensions"`
+	PostCreateCommand interface{}       `json:"postCreateCommand"`
+	Customizations    struct {
+		VSCode struct {
+			Extensions []string `json:"extensions"`
+		} `json:"vscode"`
+	} `json:"customizations"`
+}
+
+// Apply returns the config vars of an editor for a devcontainer.json
+func Apply(b []byte) (*Result, error) {
+	b = stripJSONC(b)
+
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, err
+	}
+
+	var cfg config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+
+	res := &Result{Vars: make(map[string]string)}
+
+	for _, k := range sortedKeys(keys) {
+		switch {
+		case containerKeys[k]:
+			res.warnf("%s is not supported, editors run on the Codeface image, install what the repository needs in postCreateCommand", k)
+		case ignoredKeys[k]:
+		case k == "containerEnv", k == "forwardPorts", k == "extensions", k == "postCreateCommand", k == "customizations":
+		default:
+			res.warnf("%s is not supported", k)
+		}
+	}
+
+	for _, k := range sortedKeys(cfg.ContainerEnv) {
+		if model.ReservedVar(k) {
+			res.warnf("containerEnv %s is reserved by Codeface", k)
+			continue
+		}
+		res.Vars[k] = cfg.ContainerEnv[k]
+	}
+
+	if ports := res.ports(cfg.ForwardPorts); len(ports) > 0 {
+		res.Vars[model.ForwardPortsVar] = strings.Join(ports, ";")
+	}
+
+	extensions := append(cfg.Extensions, cfg.Customizations.VSCode.Extensions...)
+	if len(extensions) > 0 {
+		res.Vars[model.ExtensionsVar] = strings.Join(extensions, ";")
+	}
+
+	switch cmd := cfg.PostCreateCommand.(type) {
+	case nil:
+	case string:
+		res.Vars[model.PostCreateCommandVar] = cmd
+	case []interface{}:
+		// the agent runs a JSON array without a shell
+		b, _ := json.Marshal(cmd)
+		res.Vars[model.PostCreateCommandVar] = string(b)
+	default:
+		res.warnf("postCreateCommand with parallel commands is not supported")
+	}
+
+	return res, nil
+}
+
+// ports accepts 3000 and "localhost:3000"
+func (r *Result) ports(ports []interface{}) []string {
+	var result []string
+	for _, p := range ports {
+		var port int
+		switch v := p.(type) {
+		case float64:
+			port = int(v)
+		case string:
+			split := strings.Split(v, ":")
+			if len(split) == 2 && split[0] == "localhost" {
+				port, _ = strconv.Atoi(split[1])
+			}
+		}
+
+		if port <= 0 || port > 65535 {
+			r.warnf("forwardPorts %v is not supported", p)
+			continue
+		}
+
+		result = append(result, strconv.Itoa(port))
+	}
+
+	return result
+}
+
+func (r *Result) warnf(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]json.RawMessage:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]string:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// stripJSONC removes the comments and trailing commas that devcontainer.json allows
+func stripJSONC(b []byte) []byte {
+	var (
+		out      []byte
+		inString bool
+	)
+
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+
+		if inString {
+			out = append(out, c)
+			if c == '\\' && i+1 < len(b) {
+				i++
+				out = append(out, b[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		switch {
+		case c == '"':
+			inString = true
+			out = append(out, c)
+		case c == '/' && i+1 < len(b) && b[i+1] == '/':
+			for i < len(b) && b[i] != '\n' {
+				i++
+			}
+			out = append(out, '\n')
+		case c == '/' && i+1 < len(b) && b[i+1] == '*':
+			i += 2
+			for i+1 < len(b) && !(b[i] == '*' && b[i+1] == '/') {
+				i++
+			}
+			i++
+		case c == ']' || c == '}':
+			// drop a trailing comma before the closing bracket
+			j := len(out) - 1
+			for j >= 0 && strings.ContainsRune(" \t\r\n", rune(out[j])) {
+				j--
+			}
+			if j >= 0 && out[j] == ',' {
+				out = append(out[:j], out[j+1:]...)
+			}
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+
+	return out
+}
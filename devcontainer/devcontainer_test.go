@@ -0,0 +1,108 @@
+package devcontainer
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestStripJSONC(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "plain JSON",
+			in:   `{"a": [1, 2], "b": {"c": "d"}}`,
+			want: `{"a": [1, 2], "b": {"c": "d"}}`,
+		},
+		{
+			name: "line comments",
+			in:   "{\n  // the name\n  \"name\": \"x\" // trailing\n}",
+			want: "{\n  \n  \"name\": \"x\" \n}",
+		},
+		{
+			name: "block comments",
+			in:   `{/* a */"name": /* b
+			c */"x"}`,
+			want: `{"name": "x"}`,
+		},
+		{
+			name: "trailing commas",
+			in:   "{\"a\": [1, 2,\n], \"b\": {\"c\": 1, },\n}",
+			want: "{\"a\": [1, 2\n], \"b\": {\"c\": 1 }\n}",
+		},
+		{
+			name: "comment markers in strings",
+			in:   `{"url": "https://example.com/*x*/", "glob": "src/**"}`,
+			want: `{"url": "https://example.com/*x*/", "glob": "src/**"}`,
+		},
+		{
+			name: "escaped quotes in strings",
+			in:   `{"cmd": "echo \"// not a comment\"", }`,
+			want: `{"cmd": "echo \"// not a comment\"" }`,
+		},
+		{
+			name: "commas in strings",
+			in:   `{"a": "x,]"}`,
+			want: `{"a": "x,]"}`,
+		},
+		{
+			name: "unterminated block comment",
+			in:   `{"a": 1} /* b`,
+			want: `{"a": 1} `,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := string(stripJSONC([]byte(c.in)))
+			if got != c.want {
+				t.Fatalf("want %q, got %q", c.want, got)
+			}
+
+			if c.name != "unterminated block comment" && !json.Valid([]byte(got)) {
+				t.Errorf("want valid JSON, got %q", got)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	res, err := Apply([]byte(`{
+		// comments and trailing commas are allowed
+		"name": "codeface",
+		"image": "mcr.microsoft.com/devcontainers/go",
+		"containerEnv": {"GOFLAGS": "-mod=vendor", "CODEFACE_SETUP": "x", "PASSWORD": "x"},
+		"forwardPorts": [3000, "localhost:8080", "db:5432"],
+		"extensions": ["golang.go"],
+		"customizations": {"vscode": {"extensions": ["eamodio.gitlens"]}},
+		"postCreateCommand": ["go", "mod", "download"],
+		"remoteUser": "vscode",
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantVars := map[string]string{
+		"GOFLAGS":                      "-mod=vendor",
+		"CODEFACE_FORWARD_PORTS":       "3000;8080",
+		"CODEFACE_EXTENSIONS":          "golang.go;eamodio.gitlens",
+		"CODEFACE_POST_CREATE_COMMAND": `["go","mod","download"]`,
+	}
+	if !reflect.DeepEqual(res.Vars, wantVars) {
+		t.Errorf("want vars %v, got %v", wantVars, res.Vars)
+	}
+
+	wantWarnings := []string{
+		"image is not supported, editors run on the Codeface image, install what the repository needs in postCreateCommand",
+		"remoteUser is not supported",
+		"containerEnv CODEFACE_SETUP is reserved by Codeface",
+		"containerEnv PASSWORD is reserved by Codeface",
+		"forwardPorts db:5432 is not supported",
+	}
+	if !reflect.DeepEqual(res.Warnings, wantWarnings) {
+		t.Errorf("want warnings %q, got %q", wantWarnings, res.Warnings)
+	}
+}
@@ -2,35 +2,66 @@ package editor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/metrics"
+	"github.com/jingweno/codeface/model"
 	log "github.com/sirupsen/logrus"
 )
 
-func NewClaimer(accessToken string) *Claimer {
-	client := &http.Client{
-		Transport: &heroku.Transport{
-			BearerToken: accessToken,
-		},
-	}
+var ErrEmptyPool = errors.New("error: no qualified app is found in the pool")
 
+// NewClaimer returns a claimer that takes apps from the pool of the Heroku Team
+// if team isn't empty, and hands them over to recipients by the ownership,
+// see ParseOwnership
+func NewClaimer(accessToken, ownership, team string) *Claimer {
 	return &Claimer{
-		heroku:      heroku.NewService(client),
+		heroku:      NewHerokuService(accessToken),
+		AppLimit:    DefaultAppLimit,
+		ownership:   ownership,
+		team:        team,
 		logger:      log.New().WithField("com", "claimer"),
 		accessToken: accessToken,
 	}
 }
 
 type Claimer struct {
-	heroku      *heroku.Service
+	heroku *heroku.Service
+	// AppLimit is the max number of apps a recipient of a transferred editor
+	// can own, 0 drops the check, see CheckRecipient
+	AppLimit    int
+	ownership   string
+	team        string
 	logger      log.FieldLogger
 	accessToken string
 }
 
-func (t *Claimer) Claim(ctx context.Context, appIdentity, recipient, gitRepo string) (*heroku.App, error) {
+// ClaimOptions configure a claimed editor
+type ClaimOptions struct {
+	GitRepo string
+	// Vars are config vars besides GIT_REPO
+	Vars map[string]string
+	// Size is the dyno size, the size of the pool app if empty
+	Size string
+	// DotfilesRepo of the recipient is installed by the editor before the
+	// project opens
+	DotfilesRepo string
+	// DotfilesInstall is the install script in DotfilesRepo, looked up by
+	// the editor if empty
+	DotfilesInstall string
+	// RecipientToken is a token of the recipient to check their account
+	// before claiming, see CheckRecipient
+	RecipientToken string
+}
+
+// Claim takes an app from the pool, or the app of appIdentity, configures it
+// with opts, scales it up and hands it over to the recipient
+func (t *Claimer) Claim(ctx context.Context, appIdentity, recipient string, opts ClaimOptions) (*heroku.App, error) {
 	logger := t.logger.WithFields(log.Fields{"app": appIdentity, "recipient": recipient})
 
 	var (
@@ -38,6 +69,29 @@ func (t *Claimer) Claim(ctx context.Context, appIdentity, recipient, gitRepo str
 		err error
 	)
 
+	defer func() {
+		switch {
+		case err == nil:
+			metrics.Claims.WithLabelValues(metrics.ClaimOutcomeSuccess).Inc()
+		case errors.Is(err, ErrEmptyPool):
+			metrics.Claims.WithLabelValues(metrics.ClaimOutcomeEmptyPool).Inc()
+		case errors.As(err, new(*RecipientError)):
+			metrics.Claims.WithLabelValues(metrics.ClaimOutcomeRejected).Inc()
+		default:
+			metrics.Claims.WithLabelValues(metrics.ClaimOutcomeFailure).Inc()
+		}
+	}()
+
+	logger.Info("Checking recipient")
+	check, err := t.CheckRecipient(ctx, recipient, opts.RecipientToken)
+	if err != nil {
+		return nil, err
+	}
+	// the claim fails later if a skipped check would have
+	if !check.Verified() {
+		logger.WithField("skipped", check.Skipped).Info("Recipient is partly checked")
+	}
+
 	if appIdentity == "" {
 		logger.Info("Taking one app from the pool")
 		app, err = t.findOneIdledApp(ctx)
@@ -79,24 +133,34 @@ func (t *Claimer) Claim(ctx context.Context, appIdentity, recipient, gitRepo str
 		return app, err
 	}
 
-	err = t.transferOwnership(ctx, app, recipient, gitRepo)
+	err = t.transferOwnership(ctx, app, recipient, opts)
 
 	return app, err
 }
 
-func (t *Claimer) transferOwnership(ctx context.Context, app *heroku.App, recipient, gitRepo string) error {
+func (t *Claimer) transferOwnership(ctx context.Context, app *heroku.App, recipient string, opts ClaimOptions) error {
 	logger := t.logger.WithField("app", app.Name)
 
 	logger.Infof("Adding Git repo")
-	if err := t.addGitRepo(ctx, app.Name, gitRepo); err != nil {
+	if err := t.addGitRepo(ctx, app.Name, opts); err != nil {
 		return err
 	}
 
-	logger.Infof("Scaling up app")
-	if err := t.scaleUpApp(ctx, app.Name); err != nil {
+	logger.WithField("size", opts.Size).Infof("Scaling up app")
+	if err := t.scaleUpApp(ctx, app.Name, opts.Size); err != nil {
 		return err
 	}
 
+	switch t.ownership {
+	case OwnershipManaged:
+		// the app stays with the service account
+		return nil
+	case OwnershipTeam:
+		// the app stays in the team with the recipient as a collaborator
+		logger.Infof("Adding team collaborator")
+		return t.addTeamCollaborator(ctx, app.Name, recipient)
+	}
+
 	// the app is already owned by the recipient
 	if app.Owner.Email == recipient || app.Owner.ID == recipient {
 		return nil
@@ -126,24 +190,30 @@ func (t *Claimer) transferOwnership(ctx context.Context, app *heroku.App, recipi
 }
 
 func (t *Claimer) findOneIdledApp(ctx context.Context) (*heroku.App, error) {
-	currentVersion, otherVersion, err := AllIdledApps(ctx, t.heroku)
+	defer metrics.ObserveClaimStep("find_app", time.Now())
+
+	currentVersion, otherVersion, err := AllIdledApps(ctx, t.heroku, t.team)
 	if err != nil {
 		return nil, err
 	}
 
 	apps := append(currentVersion, otherVersion...)
 	if len(apps) == 0 {
-		return nil, fmt.Errorf("error: no qualified app is found in the pool")
+		return nil, ErrEmptyPool
 	}
 
 	return &apps[0], nil
 }
 
 func (t *Claimer) app(ctx context.Context, appIdentity string) (*heroku.App, error) {
+	defer metrics.ObserveClaimStep("get_app", time.Now())
+
 	return t.heroku.AppInfo(ctx, appIdentity)
 }
 
 func (t *Claimer) markAppAsClaimed(ctx context.Context, app *heroku.App) (*heroku.App, error) {
+	defer metrics.ObserveClaimStep("mark_claimed", time.Now())
+
 	if idleAppRegexp.MatchString(app.Name) {
 		cfID := idleAppRegexp.FindStringSubmatch(app.Name)
 		newIdentity := buildClaimedAppName(cfID[1])
@@ -161,22 +231,45 @@ func (t *Claimer) markAppAsClaimed(ctx context.Context, app *heroku.App) (*herok
 	return app, nil
 }
 
-func (t *Claimer) addGitRepo(ctx context.Context, appIdentity, gitRepo string) error {
-	_, err := t.heroku.ConfigVarUpdate(ctx, appIdentity, map[string]*string{
-		"GIT_REPO": &gitRepo,
-	})
+func (t *Claimer) addGitRepo(ctx context.Context, appIdentity string, opts ClaimOptions) error {
+	defer metrics.ObserveClaimStep("add_git_repo", time.Now())
+
+	config := map[string]*string{
+		"GIT_REPO": &opts.GitRepo,
+	}
+	for k, v := range opts.Vars {
+		v := v
+		config[k] = &v
+	}
+	if opts.DotfilesRepo != "" {
+		config[model.DotfilesRepoVar] = &opts.DotfilesRepo
+	}
+	if opts.DotfilesInstall != "" {
+		config[model.DotfilesInstallVar] = &opts.DotfilesInstall
+	}
+
+	_, err := t.heroku.ConfigVarUpdate(ctx, appIdentity, config)
 	return err
 }
 
-func (t *Claimer) scaleUpApp(ctx context.Context, appIdentity string) error {
+func (t *Claimer) scaleUpApp(ctx context.Context, appIdentity, size string) error {
+	defer metrics.ObserveClaimStep("scale_up", time.Now())
+
 	qty := 1
-	_, err := t.heroku.FormationUpdate(ctx, appIdentity, "web", heroku.FormationUpdateOpts{
+	opts := heroku.FormationUpdateOpts{
 		Quantity: &qty,
-	})
+	}
+	if size != "" {
+		opts.Size = &size
+	}
+
+	_, err := t.heroku.FormationUpdate(ctx, appIdentity, "web", opts)
 	return err
 }
 
 func (t *Claimer) addCollaborator(ctx context.Context, appIdentity, recipient string) error {
+	defer metrics.ObserveClaimStep("add_collaborator", time.Now())
+
 	silent := true
 	_, err := t.heroku.CollaboratorCreate(ctx, appIdentity, heroku.CollaboratorCreateOpts{
 		Silent: &silent,
@@ -185,12 +278,50 @@ func (t *Claimer) addCollaborator(ctx context.Context, appIdentity, recipient st
 	return err
 }
 
+// teamPermissions let the recipient use, deploy and restart the editor.
+// Without manage the recipient can't add collaborators, rename or transfer
+// the app out of the team.
+var teamPermissions = []string{"view", "deploy", "operate"}
+
+func (t *Claimer) addTeamCollaborator(ctx context.Context, appIdentity, recipient string) error {
+	defer metrics.ObserveClaimStep("add_team_collaborator", time.Now())
+
+	silent := true
+	var permissions []*string
+	for i := range teamPermissions {
+		permissions = append(permissions, &teamPermissions[i])
+	}
+
+	_, err := t.heroku.TeamAppCollaboratorCreate(ctx, appIdentity, heroku.TeamAppCollaboratorCreateOpts{
+		Permissions: permissions,
+		Silent:      &silent,
+		User:        recipient,
+	})
+
+	// the recipient is already a collaborator, e.g. of an editor claimed
+	// again, so its permissions are reset instead
+	var herr heroku.Error
+	if errors.As(err, &herr) && herr.StatusCode == http.StatusUnprocessableEntity {
+		if _, uerr := t.heroku.TeamAppCollaboratorUpdate(ctx, appIdentity, recipient, heroku.TeamAppCollaboratorUpdateOpts{
+			Permissions: teamPermissions,
+		}); uerr == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
 func (t *Claimer) removeOwner(ctx context.Context, appIdentity, owner string) error {
+	defer metrics.ObserveClaimStep("remove_owner", time.Now())
+
 	_, err := t.heroku.CollaboratorDelete(ctx, appIdentity, owner)
 	return err
 }
 
 func (t *Claimer) transferApp(ctx context.Context, appIdentity, recipient string) (*heroku.AppTransfer, error) {
+	defer metrics.ObserveClaimStep("transfer_app", time.Now())
+
 	silent := true
 	return t.heroku.AppTransferCreate(ctx, heroku.AppTransferCreateOpts{
 		App:       appIdentity,
@@ -200,6 +331,8 @@ func (t *Claimer) transferApp(ctx context.Context, appIdentity, recipient string
 }
 
 func (t *Claimer) acceptTransfer(ctx context.Context, transferID string) error {
+	defer metrics.ObserveClaimStep("accept_transfer", time.Now())
+
 	_, err := t.heroku.AppTransferUpdate(ctx, transferID, heroku.AppTransferUpdateOpts{
 		State: "auto-accepted",
 	})
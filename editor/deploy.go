@@ -16,6 +16,7 @@ import (
 	"time"
 
 	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/metrics"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -24,22 +25,20 @@ var (
 	version        = "0.0.2" // TODO load from env var
 )
 
-func NewDeployer(accessToken, templateDir string) *Deployer {
-	client := &http.Client{
-		Transport: &heroku.Transport{
-			BearerToken: accessToken,
-		},
-	}
-
+// NewDeployer returns a deployer that creates apps in the Heroku Team if team
+// isn't empty, or in the account of accessToken
+func NewDeployer(accessToken, templateDir, team string) *Deployer {
 	return &Deployer{
 		templateDir: templateDir,
-		heroku:      heroku.NewService(client),
+		team:        team,
+		heroku:      NewHerokuService(accessToken),
 		logger:      log.New().WithField("com", "deployer"),
 	}
 }
 
 type Deployer struct {
 	templateDir string
+	team        string
 	heroku      *heroku.Service
 	logger      log.FieldLogger
 }
@@ -83,10 +82,13 @@ func (d *Deployer) DeployEditorAndScaleDown(ctx context.Context) (*heroku.App, e
 		}
 	}()
 
+	start := time.Now()
 	err = d.buildAndScaleDown(ctx, cfApp, logger)
 	if err != nil {
+		metrics.BuildFailures.Inc()
 		return cfApp, err
 	}
+	metrics.BuildDuration.Observe(time.Since(start).Seconds())
 
 	logger.Infof("Marking app as idled")
 	cfApp, err = d.markAppAsIdled(ctx, cfApp)
@@ -94,6 +96,68 @@ func (d *Deployer) DeployEditorAndScaleDown(ctx context.Context) (*heroku.App, e
 	return cfApp, err
 }
 
+// BuildOutcome is the outcome of FinishBuild
+type BuildOutcome string
+
+const (
+	// BuildPromoted is an app marked as idled after its build succeeded
+	BuildPromoted BuildOutcome = "promoted"
+	// BuildPending is an app whose latest build is still running
+	BuildPending BuildOutcome = "pending"
+	// BuildFailed is an app that is not usable and should be removed, i.e.
+	// its latest build failed, it has no build or it's of an outdated version
+	BuildFailed BuildOutcome = "failed"
+)
+
+// FinishBuild finishes deploying a building app left behind by a crashed
+// deployer. If the latest build of the app succeeded, the app is scaled down
+// and marked as idled.
+func (d *Deployer) FinishBuild(ctx context.Context, app *heroku.App) (*heroku.App, BuildOutcome, error) {
+	logger := d.logger.WithField("app", app.Name)
+
+	if !buildingAppCurrentVersionRegexp.MatchString(app.Name) {
+		logger.Info("Building app is of an outdated version")
+		return app, BuildFailed, nil
+	}
+
+	builds, err := d.heroku.BuildList(ctx, app.Name, &heroku.ListRange{
+		Field:      "created_at",
+		Descending: true,
+		Max:        1,
+	})
+	if err != nil {
+		return app, "", err
+	}
+
+	if len(builds) == 0 {
+		logger.Info("Building app has no build")
+		return app, BuildFailed, nil
+	}
+
+	switch build := builds[0]; {
+	case build.Status == "failed":
+		logger.WithField("build", build.ID).Info("Building app has a failed build")
+		return app, BuildFailed, nil
+	// the release of a succeeded build may still be running
+	case build.Status != "succeeded" || build.Release == nil:
+		logger.WithField("build", build.ID).Info("Building app is still building")
+		return app, BuildPending, nil
+	}
+
+	logger.Infof("Scaling down app")
+	if err := d.scaleDownApp(ctx, app.Name); err != nil {
+		return app, "", err
+	}
+
+	logger.Infof("Marking app as idled")
+	app, err = d.markAppAsIdled(ctx, app)
+	if err != nil {
+		return app, "", err
+	}
+
+	return app, BuildPromoted, nil
+}
+
 func (d *Deployer) markAppAsIdled(ctx context.Context, app *heroku.App) (*heroku.App, error) {
 	if buildingAppCurrentVersionRegexp.MatchString(app.Name) {
 		cfID := buildingAppCurrentVersionRegexp.FindStringSubmatch(app.Name)
@@ -113,6 +177,16 @@ func (d *Deployer) markAppAsIdled(ctx context.Context, app *heroku.App) (*heroku
 }
 
 func (d *Deployer) buildAndScaleDown(ctx context.Context, cfApp *heroku.App, logger *log.Entry) error {
+	if err := d.build(ctx, cfApp, logger); err != nil {
+		return err
+	}
+
+	logger.Infof("Scaling down app")
+	return d.scaleDownApp(ctx, cfApp.Name)
+}
+
+// build builds the app from the template and waits for the release
+func (d *Deployer) build(ctx context.Context, cfApp *heroku.App, logger *log.Entry) error {
 	logger.Infof("Uploading source")
 	src, err := d.uploadSource(ctx, d.templateDir, map[string]string{})
 	if err != nil {
@@ -132,12 +206,7 @@ func (d *Deployer) buildAndScaleDown(ctx context.Context, cfApp *heroku.App, log
 		return err
 	}
 
-	if err := d.waitForRelease(ctx, build, logger); err != nil {
-		return err
-	}
-
-	logger.Infof("Scaling down app")
-	return d.scaleDownApp(ctx, cfApp.Name)
+	return d.waitForRelease(ctx, build, logger)
 }
 
 func (d *Deployer) scaleDownApp(ctx context.Context, appIdentity string) error {
@@ -163,6 +232,10 @@ func (d *Deployer) app(ctx context.Context, appName string) (*heroku.App, error)
 func (d *Deployer) createCFApp(ctx context.Context, acct *heroku.Account) (*heroku.App, error) {
 	region := "us"
 	name := genBuildingAppName()
+	if d.team != "" {
+		return d.createTeamApp(ctx, name, region)
+	}
+
 	cfApp, err := d.heroku.AppCreate(ctx, heroku.AppCreateOpts{
 		Name:   &name,
 		Region: &region,
@@ -174,6 +247,25 @@ func (d *Deployer) createCFApp(ctx context.Context, acct *heroku.Account) (*hero
 	return cfApp, nil
 }
 
+// createTeamApp creates a locked app in the team so that team members can't
+// join the editor of another member
+func (d *Deployer) createTeamApp(ctx context.Context, name, region string) (*heroku.App, error) {
+	locked := true
+	teamApp, err := d.heroku.TeamAppCreate(ctx, heroku.TeamAppCreateOpts{
+		Name:   &name,
+		Region: &region,
+		Stack:  &containerStack,
+		Team:   &d.team,
+		Locked: &locked,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var cfApp heroku.App
+	return &cfApp, convertTeamApp(teamApp, &cfApp)
+}
+
 func (d *Deployer) uploadSource(ctx context.Context, dir string, tmplData map[string]string) (*heroku.Source, error) {
 	src, err := d.heroku.SourceCreate(ctx)
 	if err != nil {
@@ -181,7 +273,7 @@ func (d *Deployer) uploadSource(ctx context.Context, dir string, tmplData map[st
 	}
 
 	buf := bytes.NewBuffer(nil)
-	if err := compress("./template", buf, tmplData); err != nil {
+	if err := compress(dir, buf, tmplData); err != nil {
 		return nil, err
 	}
 
@@ -300,9 +392,20 @@ func compress(src string, buf io.Writer, tmplData map[string]string) error {
 	zr := gzip.NewWriter(buf)
 	tw := tar.NewWriter(zr)
 
+	// entries are relative to the parent of src so that the tarball has a single top-level directory
+	base := filepath.Dir(filepath.Clean(src))
+
 	// walk through every file in the folder
 	err := filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
-		path := filepath.ToSlash(file)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(base, file)
+		if err != nil {
+			return err
+		}
+		path := filepath.ToSlash(rel)
 
 		if !fi.IsDir() {
 			dir, err := ioutil.TempDir("", "tmp")
@@ -0,0 +1,187 @@
+package editor
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestCompress(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "codeface")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	files := map[string]string{
+		"template/Dockerfile": "FROM {{.Image}}\n",
+		"template/heroku.yml": "build:\n",
+		"template/bin/start":  "#!/bin/sh\n",
+	}
+	for name, content := range files {
+		file := filepath.Join(tmp, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(tmp); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// the files of ./template, the default TEMPLATE_DIR, keep their paths
+	// under template/, and any other directory is uploaded with the same paths
+	want := []string{"template", "template/Dockerfile", "template/bin", "template/bin/start", "template/heroku.yml"}
+	for _, src := range []string{"./template", "template", filepath.Join(tmp, "template"), filepath.Join(tmp, "template") + "/"} {
+		t.Run(src, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := compress(src, &buf, map[string]string{"Image": "heroku/heroku:18"}); err != nil {
+				t.Fatal(err)
+			}
+
+			got := entries(t, &buf)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("want entries %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+// entries returns the sorted names of the entries of a gzipped tarball
+func entries(t *testing.T, r io.Reader) []string {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	tr := tar.NewReader(zr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names = append(names, header.Name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func TestFinishBuild(t *testing.T) {
+	building := "cf-abc-" + CurrentVersion() + "b"
+
+	cases := []struct {
+		name string
+		app  string
+		// builds is the response of the build list, the latest first
+		builds string
+		want   BuildOutcome
+	}{
+		{
+			name:   "succeeded",
+			app:    building,
+			builds: `[{"id": "1", "status": "succeeded", "release": {"id": "r1"}}]`,
+			want:   BuildPromoted,
+		},
+		{
+			name:   "pending",
+			app:    building,
+			builds: `[{"id": "1", "status": "pending"}]`,
+			want:   BuildPending,
+		},
+		{
+			name:   "succeeded without a release yet",
+			app:    building,
+			builds: `[{"id": "1", "status": "succeeded"}]`,
+			want:   BuildPending,
+		},
+		{
+			name:   "failed",
+			app:    building,
+			builds: `[{"id": "1", "status": "failed"}]`,
+			want:   BuildFailed,
+		},
+		{
+			name:   "no build",
+			app:    building,
+			builds: `[]`,
+			want:   BuildFailed,
+		},
+		{
+			name: "outdated version",
+			app:  "cf-abc-00000000b",
+			want: BuildFailed,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var renamed string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/builds"):
+					w.Write([]byte(c.builds))
+				case r.Method == http.MethodPatch && strings.Contains(r.URL.Path, "/formation/"):
+					w.Write([]byte(`{}`))
+				case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/apps/"):
+					var opts heroku.AppUpdateOpts
+					json.NewDecoder(r.Body).Decode(&opts)
+					renamed = *opts.Name
+					json.NewEncoder(w).Encode(heroku.App{Name: renamed})
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer srv.Close()
+
+			logger := log.New()
+			logger.Out = ioutil.Discard
+			d := &Deployer{heroku: heroku.NewService(srv.Client()), logger: logger}
+			d.heroku.URL = srv.URL
+
+			app, got, err := d.FinishBuild(context.Background(), &heroku.App{Name: c.app})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("want outcome %s, got %s", c.want, got)
+			}
+
+			if c.want == BuildPromoted {
+				if want := "cf-abc-" + CurrentVersion() + "i"; renamed != want || app.Name != want {
+					t.Errorf("want app renamed to %s, got %s", want, app.Name)
+				}
+			} else if renamed != "" {
+				t.Errorf("want app not renamed, got %s", renamed)
+			}
+		})
+	}
+}
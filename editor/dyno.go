@@ -0,0 +1,18 @@
+package editor
+
+import "strings"
+
+// DynoSizes are the dyno sizes of Heroku from the smallest to the largest
+var DynoSizes = []string{"free", "hobby", "standard-1x", "standard-2x", "performance-m", "performance-l"}
+
+// DynoSizeRank returns the position of a size in DynoSizes, or -1 if it's unknown.
+// Sizes are case insensitive, e.g. Standard-1X.
+func DynoSizeRank(size string) int {
+	for i, s := range DynoSizes {
+		if strings.EqualFold(s, size) {
+			return i
+		}
+	}
+
+	return -1
+}
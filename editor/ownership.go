@@ -0,0 +1,41 @@
+package editor
+
+import "fmt"
+
+// how claimed editors are owned
+const (
+	// OwnershipTransfer transfers an editor to the personal account of the user
+	OwnershipTransfer = "transfer"
+	// OwnershipTeam keeps an editor in a Heroku Team with the user as a collaborator
+	OwnershipTeam = "team"
+	// OwnershipManaged keeps an editor in the service account, so users don't
+	// need a Heroku account with billing. Access is enforced by the server
+	// and the agent of the editor.
+	OwnershipManaged = "managed"
+)
+
+// ParseOwnership validates the ownership of editors against the Heroku Team
+// of the pool. It defaults to team if there is a team, or transfer.
+func ParseOwnership(ownership, team string) (string, error) {
+	switch ownership {
+	case "":
+		if team != "" {
+			return OwnershipTeam, nil
+		}
+
+		return OwnershipTransfer, nil
+	case OwnershipTransfer:
+		if team != "" {
+			return "", fmt.Errorf("error: apps of a Heroku Team aren't transferred, use %s or %s ownership", OwnershipTeam, OwnershipManaged)
+		}
+	case OwnershipTeam:
+		if team == "" {
+			return "", fmt.Errorf("error: %s ownership requires a Heroku Team", OwnershipTeam)
+		}
+	case OwnershipManaged:
+	default:
+		return "", fmt.Errorf("error: unsupported editor ownership %q", ownership)
+	}
+
+	return ownership, nil
+}
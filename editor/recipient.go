@@ -0,0 +1,153 @@
+package editor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	heroku "github.com/heroku/heroku-go/v5"
+)
+
+// codes of a RecipientError
+const (
+	RecipientNotFound   = "recipient_not_found"
+	RecipientUnverified = "recipient_unverified"
+	RecipientSuspended  = "recipient_suspended"
+	RecipientDelinquent = "recipient_delinquent"
+	RecipientAppLimit   = "recipient_app_limit"
+)
+
+// checks of CheckRecipient that can be skipped
+const (
+	RecipientCheckAccount  = "account"
+	RecipientCheckAppLimit = "app_limit"
+)
+
+// DefaultAppLimit is the max number of apps a verified Heroku account can own
+const DefaultAppLimit = 100
+
+// RecipientCheck is the result of a CheckRecipient that found no problem.
+// Skipped are the checks that couldn't be run, e.g. without a token of the
+// recipient, so the recipient may still be rejected by the claim.
+type RecipientCheck struct {
+	Skipped []string
+}
+
+// Verified tells whether every check was run
+func (c *RecipientCheck) Verified() bool {
+	return len(c.Skipped) == 0
+}
+
+// RecipientError tells why an editor can't be handed over to a recipient
+type RecipientError struct {
+	Code    string
+	Message string
+}
+
+func (e *RecipientError) Error() string {
+	return "error: " + e.Message
+}
+
+// CheckRecipient checks that an editor can be handed over to the recipient by
+// the ownership of the claimer, so that a claim doesn't fail after taking an
+// app from the pool. The apps of the recipient are only counted with
+// recipientToken, a token of the recipient with the read scope, and unless
+// AppLimit of the claimer is 0.
+func (t *Claimer) CheckRecipient(ctx context.Context, recipient, recipientToken string) (*RecipientCheck, error) {
+	// the service account keeps managed editors
+	if t.ownership == OwnershipManaged {
+		return &RecipientCheck{}, nil
+	}
+
+	logger := t.logger.WithField("recipient", recipient)
+
+	client := t.heroku
+	if recipientToken != "" {
+		client = NewHerokuService(recipientToken)
+		client.URL = t.heroku.URL
+	}
+
+	var (
+		acct *heroku.Account
+		err  error
+	)
+	if recipientToken != "" {
+		acct, err = client.AccountInfo(ctx)
+	} else {
+		acct, err = client.AccountInfoByUser(ctx, recipient)
+	}
+	// the client wraps the error of the transport
+	var herr heroku.Error
+	if errors.As(err, &herr) && herr.StatusCode == http.StatusNotFound {
+		return nil, &RecipientError{
+			Code:    RecipientNotFound,
+			Message: fmt.Sprintf("%s doesn't have a Heroku account, sign up at https://signup.heroku.com", recipient),
+		}
+	}
+	if err != nil {
+		// only the recipient or an admin of their team can look up an account
+		logger.WithError(err).Info("error: fail to look up recipient, skipping checks")
+		return &RecipientCheck{Skipped: []string{RecipientCheckAccount, RecipientCheckAppLimit}}, nil
+	}
+
+	if acct.SuspendedAt != nil {
+		return nil, &RecipientError{
+			Code:    RecipientSuspended,
+			Message: fmt.Sprintf("The Heroku account of %s is suspended, contact Heroku support at https://help.heroku.com", recipient),
+		}
+	}
+
+	// team apps are billed to the team
+	if t.ownership == OwnershipTeam {
+		return &RecipientCheck{}, nil
+	}
+
+	if acct.DelinquentAt != nil {
+		return nil, &RecipientError{
+			Code:    RecipientDelinquent,
+			Message: fmt.Sprintf("The Heroku account of %s has unpaid invoices, pay them at https://dashboard.heroku.com/account/billing", recipient),
+		}
+	}
+
+	if !acct.Verified {
+		return nil, &RecipientError{
+			Code:    RecipientUnverified,
+			Message: fmt.Sprintf("The Heroku account of %s isn't verified, add billing information at https://dashboard.heroku.com/account/billing", recipient),
+		}
+	}
+
+	if t.AppLimit <= 0 {
+		return &RecipientCheck{}, nil
+	}
+
+	if recipientToken == "" {
+		return &RecipientCheck{Skipped: []string{RecipientCheckAppLimit}}, nil
+	}
+
+	apps, err := client.AppList(ctx, &heroku.ListRange{
+		Field: "name",
+		Max:   1000,
+	})
+	if err != nil {
+		// the token of the user may only have the identity scope
+		logger.WithError(err).Info("error: fail to list apps of recipient, skipping app limit check")
+		return &RecipientCheck{Skipped: []string{RecipientCheckAppLimit}}, nil
+	}
+
+	owned := 0
+	for _, app := range apps {
+		if app.Owner.ID == acct.ID {
+			owned++
+		}
+	}
+
+	if owned >= t.AppLimit {
+		return nil, &RecipientError{
+			Code:    RecipientAppLimit,
+			Message: fmt.Sprintf("%s owns %d apps, which reaches the limit of %d apps. Delete an app at https://dashboard.heroku.com/apps", recipient, owned, t.AppLimit),
+		}
+	}
+
+	return &RecipientCheck{}, nil
+}
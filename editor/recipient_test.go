@@ -0,0 +1,200 @@
+package editor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestCheckRecipient(t *testing.T) {
+	const (
+		verified   = `{"id": "u1", "email": "jane@example.com", "verified": true}`
+		unverified = `{"id": "u1", "email": "jane@example.com", "verified": false}`
+		suspended  = `{"id": "u1", "email": "jane@example.com", "verified": true, "suspended_at": "2020-01-01T00:00:00Z"}`
+		delinquent = `{"id": "u1", "email": "jane@example.com", "verified": true, "delinquent_at": "2020-01-01T00:00:00Z"}`
+		notFound   = `404 {"id": "not_found", "message": "Couldn't find that user."}`
+		forbidden  = `403 {"id": "forbidden", "message": "You do not have access to the account."}`
+	)
+
+	// apps returns a list of n apps of the recipient and an app of others
+	apps := func(n int) string {
+		list := []string{`{"name": "shared", "owner": {"id": "u2"}}`}
+		for i := 0; i < n; i++ {
+			list = append(list, fmt.Sprintf(`{"name": "app%d", "owner": {"id": "u1"}}`, i))
+		}
+
+		return "[" + strings.Join(list, ",") + "]"
+	}
+
+	cases := []struct {
+		name      string
+		ownership string
+		token     string
+		appLimit  int
+		// account and apps are the responses of the Heroku API, prefixed
+		// with the status code unless it's 200
+		account string
+		apps    string
+		// code is the code of the RecipientError
+		code    string
+		skipped []string
+	}{
+		{
+			name:      "managed",
+			ownership: OwnershipManaged,
+			account:   notFound,
+		},
+		{
+			name:      "not found",
+			ownership: OwnershipTransfer,
+			account:   notFound,
+			code:      RecipientNotFound,
+		},
+		{
+			name:      "suspended",
+			ownership: OwnershipTransfer,
+			account:   suspended,
+			code:      RecipientSuspended,
+		},
+		{
+			name:      "suspended member of the team",
+			ownership: OwnershipTeam,
+			account:   suspended,
+			code:      RecipientSuspended,
+		},
+		{
+			name:      "delinquent",
+			ownership: OwnershipTransfer,
+			account:   delinquent,
+			code:      RecipientDelinquent,
+		},
+		{
+			name:      "delinquent member of the team",
+			ownership: OwnershipTeam,
+			account:   delinquent,
+		},
+		{
+			name:      "unverified",
+			ownership: OwnershipTransfer,
+			account:   unverified,
+			code:      RecipientUnverified,
+		},
+		{
+			name:      "account isn't accessible",
+			ownership: OwnershipTransfer,
+			account:   forbidden,
+			skipped:   []string{RecipientCheckAccount, RecipientCheckAppLimit},
+		},
+		{
+			name:      "apps aren't counted without a token of the recipient",
+			ownership: OwnershipTransfer,
+			account:   verified,
+			skipped:   []string{RecipientCheckAppLimit},
+		},
+		{
+			name:      "apps aren't accessible to the token",
+			ownership: OwnershipTransfer,
+			token:     "recipient",
+			account:   verified,
+			apps:      forbidden,
+			skipped:   []string{RecipientCheckAppLimit},
+		},
+		{
+			name:      "below the app limit",
+			ownership: OwnershipTransfer,
+			token:     "recipient",
+			account:   verified,
+			apps:      apps(DefaultAppLimit - 1),
+		},
+		{
+			name:      "at the app limit",
+			ownership: OwnershipTransfer,
+			token:     "recipient",
+			account:   verified,
+			apps:      apps(DefaultAppLimit),
+			code:      RecipientAppLimit,
+		},
+		{
+			name:      "at a configured app limit",
+			ownership: OwnershipTransfer,
+			token:     "recipient",
+			appLimit:  2,
+			account:   verified,
+			apps:      apps(2),
+			code:      RecipientAppLimit,
+		},
+		{
+			name:      "app limit is dropped",
+			ownership: OwnershipTransfer,
+			// 0 keeps the default in the cases
+			appLimit: -1,
+			account:  verified,
+			apps:     forbidden,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var resp string
+				switch {
+				case r.URL.Path == "/account" && c.token != "":
+					if r.Header.Get("Authorization") != "Bearer "+c.token {
+						t.Errorf("want the account looked up with the token of the recipient, got %s", r.Header.Get("Authorization"))
+					}
+					resp = c.account
+				case r.URL.Path == "/users/jane@example.com" && c.token == "":
+					resp = c.account
+				case r.URL.Path == "/apps":
+					resp = c.apps
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+					http.NotFound(w, r)
+					return
+				}
+
+				status := http.StatusOK
+				fmt.Sscanf(resp, "%d ", &status)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(status)
+				w.Write([]byte(strings.TrimLeft(resp, "0123456789 ")))
+			}))
+			defer srv.Close()
+
+			claimer := NewClaimer("service", c.ownership, "team")
+			claimer.heroku.URL = srv.URL
+			if c.appLimit != 0 {
+				claimer.AppLimit = c.appLimit
+			}
+			logger := log.New()
+			logger.Out = ioutil.Discard
+			claimer.logger = logger
+
+			check, err := claimer.CheckRecipient(context.Background(), "jane@example.com", c.token)
+			if c.code != "" {
+				e, ok := err.(*RecipientError)
+				if !ok || e.Code != c.code {
+					t.Fatalf("want recipient error %s, got %v", c.code, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(check.Skipped, c.skipped) {
+				t.Errorf("want skipped checks %v, got %v", c.skipped, check.Skipped)
+			}
+			if want := len(c.skipped) == 0; check.Verified() != want {
+				t.Errorf("want verified %t, got %t", want, check.Verified())
+			}
+		})
+	}
+}
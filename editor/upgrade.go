@@ -0,0 +1,102 @@
+package editor
+
+import (
+	"context"
+	"errors"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrUpToDate  = errors.New("error: editor is already built from the current template version")
+	ErrNotEditor = errors.New("error: app is not a claimed Codeface editor")
+)
+
+// UpgradeWarning is shown before upgrading an editor since its dyno is restarted
+const UpgradeWarning = "Upgrading restarts the editor and the dyno's disk is wiped. Commit and push your work before upgrading."
+
+// EditorVersion returns the template version the claimed editor is built from
+func (d *Deployer) EditorVersion(ctx context.Context, app *heroku.App) (string, error) {
+	builds, err := d.heroku.BuildList(ctx, app.Name, &heroku.ListRange{
+		Field:      "created_at",
+		Descending: true,
+		Max:        10,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for _, b := range builds {
+		if b.Status == "succeeded" && b.SourceBlob.Version != nil {
+			return *b.SourceBlob.Version, nil
+		}
+	}
+
+	return "", nil
+}
+
+// CheckUpgrade returns the editor if it can be upgraded, ErrNotEditor if the
+// app is not a claimed editor or ErrUpToDate if it is already up to date
+func (d *Deployer) CheckUpgrade(ctx context.Context, appIdentity string) (*heroku.App, error) {
+	app, err := d.heroku.AppInfo(ctx, appIdentity)
+	if err != nil {
+		return nil, err
+	}
+
+	if state, _, ok := ParseAppName(app.Name); !ok || state != AppStateClaimed {
+		return app, ErrNotEditor
+	}
+
+	v, err := d.EditorVersion(ctx, app)
+	if err != nil {
+		return app, err
+	}
+	if v == version {
+		return app, ErrUpToDate
+	}
+
+	return app, nil
+}
+
+// Upgrade rebuilds a claimed editor from the current template in place. The
+// app keeps its name, owner and config vars, so the URL and GIT_REPO stay the same.
+func (d *Deployer) Upgrade(ctx context.Context, appIdentity string) (*heroku.App, error) {
+	app, err := d.CheckUpgrade(ctx, appIdentity)
+	if err != nil {
+		return app, err
+	}
+
+	logger := d.logger.WithFields(log.Fields{"app": app.Name, "version": version})
+	logger.Info("Upgrading editor")
+
+	return app, d.build(ctx, app, logger)
+}
+
+// OutdatedEditors returns the claimed editors accessible to the account that
+// are not built from the current template version
+func (d *Deployer) OutdatedEditors(ctx context.Context) ([]heroku.App, error) {
+	apps, err := AllApps(ctx, d.heroku, d.team)
+	if err != nil {
+		return nil, err
+	}
+
+	var outdated []heroku.App
+	for _, app := range apps {
+		app := app
+		if state, _, _ := ParseAppName(app.Name); state != AppStateClaimed {
+			continue
+		}
+
+		v, err := d.EditorVersion(ctx, &app)
+		if err != nil {
+			return nil, err
+		}
+
+		if v != version {
+			outdated = append(outdated, app)
+		}
+	}
+
+	return outdated, nil
+}
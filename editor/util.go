@@ -2,11 +2,14 @@ package editor
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"regexp"
 	"strings"
 
 	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/metrics"
 	"github.com/rs/xid"
 	log "github.com/sirupsen/logrus"
 )
@@ -18,8 +21,44 @@ var (
 	idleAppCurrentVersionRegexp = regexp.MustCompile(fmt.Sprintf(`cf-(.+)-%si`, dashizedVersion()))
 	// idle app name is in the format of cf-#{ID}-#{VERSION}i
 	idleAppRegexp = regexp.MustCompile(`cf-(.+)-(\d+)i`)
+	// app name of any state is in the format of cf-#{ID}-#{VERSION}[b|i]
+	appNameRegexp = regexp.MustCompile(`^cf-(.+)-(\d+)([bi]?)$`)
 )
 
+// AppState is the state of a Codeface app encoded in its name
+type AppState string
+
+const (
+	AppStateBuilding AppState = "building"
+	AppStateIdle     AppState = "idle"
+	AppStateClaimed  AppState = "claimed"
+)
+
+// ParseAppName returns the state and the dashized template version of a Codeface app.
+// ok is false if the name is not a Codeface app name.
+func ParseAppName(name string) (state AppState, version string, ok bool) {
+	m := appNameRegexp.FindStringSubmatch(name)
+	if m == nil {
+		return "", "", false
+	}
+
+	switch m[3] {
+	case "b":
+		state = AppStateBuilding
+	case "i":
+		state = AppStateIdle
+	default:
+		state = AppStateClaimed
+	}
+
+	return state, m[2], true
+}
+
+// CurrentVersion returns the dashized template version of newly deployed apps
+func CurrentVersion() string {
+	return dashizedVersion()
+}
+
 func buildClaimedAppName(id string) string {
 	return fmt.Sprintf("cf-%s-%s", id, dashizedVersion())
 }
@@ -36,15 +75,64 @@ func dashizedVersion() string {
 	return strings.ReplaceAll(version, ".", "")
 }
 
-func AllIdledApps(ctx context.Context, client *heroku.Service) (currentVersion []heroku.App, otherVersion []heroku.App, err error) {
-	apps, err := client.AppListOwnedAndCollaborated(ctx, "~", &heroku.ListRange{
+// NewHerokuService returns a Heroku API client whose requests are instrumented
+func NewHerokuService(accessToken string) *heroku.Service {
+	client := &http.Client{
+		Transport: &heroku.Transport{
+			BearerToken: accessToken,
+			Transport:   metrics.HerokuTransport(http.DefaultTransport),
+		},
+	}
+
+	return heroku.NewService(client)
+}
+
+// AllApps returns all Codeface apps of the account, or of the Heroku Team if
+// team isn't empty, regardless of their state
+func AllApps(ctx context.Context, client *heroku.Service, team string) ([]heroku.App, error) {
+	lr := &heroku.ListRange{
 		Field: "name",
 		Max:   1000, // FIXME: hardcode
-	})
+	}
+
+	var (
+		apps []heroku.App
+		err  error
+	)
+	if team == "" {
+		apps, err = client.AppListOwnedAndCollaborated(ctx, "~", lr)
+	} else {
+		var teamApps []heroku.TeamApp
+		if teamApps, err = client.TeamAppListByTeam(ctx, team, lr); err == nil {
+			err = convertTeamApp(teamApps, &apps)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var result []heroku.App
+	for _, app := range apps {
+		if _, _, ok := ParseAppName(app.Name); ok {
+			result = append(result, app)
+		}
+	}
+
+	return result, nil
+}
+
+func AllIdledApps(ctx context.Context, client *heroku.Service, team string) (currentVersion []heroku.App, otherVersion []heroku.App, err error) {
+	apps, err := AllApps(ctx, client, team)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	currentVersion, otherVersion = SplitIdledApps(apps)
+	return currentVersion, otherVersion, nil
+}
+
+// SplitIdledApps picks the idle apps by whether they are built from the current template version
+func SplitIdledApps(apps []heroku.App) (currentVersion []heroku.App, otherVersion []heroku.App) {
 	for _, app := range apps {
 		if idleAppCurrentVersionRegexp.MatchString(app.Name) {
 			currentVersion = append(currentVersion, app)
@@ -53,7 +141,7 @@ func AllIdledApps(ctx context.Context, client *heroku.Service) (currentVersion [
 		}
 	}
 
-	return currentVersion, otherVersion, nil
+	return currentVersion, otherVersion
 }
 
 func Account(ctx context.Context, client *heroku.Service) (*heroku.Account, error) {
@@ -69,7 +157,7 @@ func Account(ctx context.Context, client *heroku.Service) (*heroku.Account, erro
 	return acct, nil
 }
 
-func DeleteApp(client *heroku.Service, app *heroku.App, logger log.FieldLogger) {
+func DeleteApp(client *heroku.Service, app *heroku.App, logger log.FieldLogger) error {
 	logger = logger.WithField("app", app.Name)
 
 	logger.Info("Removing app")
@@ -78,4 +166,17 @@ func DeleteApp(client *heroku.Service, app *heroku.App, logger log.FieldLogger)
 	if err != nil {
 		logger.WithError(err).Info("Fail to remove app")
 	}
+
+	return err
+}
+
+// convertTeamApp converts team apps into apps, which have the same attributes
+// in the Heroku API
+func convertTeamApp(teamApp, app interface{}) error {
+	b, err := json.Marshal(teamApp)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, app)
 }
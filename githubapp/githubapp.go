@@ -0,0 +1,208 @@
+// Package githubapp issues installation tokens of a GitHub App. They expire
+// after an hour and are scoped to a single repository, so that an editor
+// never holds the OAuth token of its owner.
+package githubapp
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultBaseURL = "https://api.github.com"
+
+// jwtTTL is below the 10 minutes allowed by GitHub
+const jwtTTL = 9 * time.Minute
+
+type Config struct {
+	AppID string `env:"GITHUB_APP_ID"`
+	// the PEM private key of the app, newlines may be escaped as \n
+	PrivateKey string `env:"GITHUB_APP_PRIVATE_KEY"`
+}
+
+// Token is an installation token
+type Token struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func New(cfg Config) (*App, error) {
+	key, err := parseKey(cfg.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &App{
+		id:      cfg.AppID,
+		key:     key,
+		baseURL: defaultBaseURL,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+type App struct {
+	id      string
+	key     *rsa.PrivateKey
+	baseURL string
+	client  *http.Client
+}
+
+// Token issues a token for a repository, e.g. jingweno/codeface, that can
+// read its contents, or push to it if write is true
+func (a *App) Token(ctx context.Context, repo string, write bool) (*Token, error) {
+	split := strings.Split(repo, "/")
+	if len(split) != 2 {
+		return nil, fmt.Errorf("error: invalid GitHub repository %s", repo)
+	}
+
+	var inst struct {
+		ID int64 `json:"id"`
+	}
+	if err := a.do(ctx, http.MethodGet, "/repos/"+repo+"/installation", nil, &inst); err != nil {
+		return nil, err
+	}
+
+	contents := "read"
+	if write {
+		contents = "write"
+	}
+	in := map[string]interface{}{
+		"repositories": []string{split[1]},
+		"permissions":  map[string]string{"contents": contents, "metadata": "read"},
+	}
+
+	var tok Token
+	if err := a.do(ctx, http.MethodPost, fmt.Sprintf("/app/installations/%d/access_tokens", inst.ID), in, &tok); err != nil {
+		return nil, err
+	}
+
+	return &tok, nil
+}
+
+// CanPush tells whether the user of an OAuth token can push to a repository
+func (a *App) CanPush(ctx context.Context, userToken, repo string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.baseURL+"/repos/"+repo, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "token "+userToken)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+
+	var out struct {
+		Permissions struct {
+			Push bool `json:"push"`
+		} `json:"permissions"`
+	}
+	if err := a.send(req, &out); err != nil {
+		return false, err
+	}
+
+	return out.Permissions.Push, nil
+}
+
+func (a *App) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = b
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, a.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	jwt, err := a.jwt(time.Now())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("Content-Type", "application/json")
+
+	return a.send(req, out)
+}
+
+func (a *App) send(req *http.Request, out interface{}) error {
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var e struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+
+		return fmt.Errorf("error: unexpected response from GitHub status=%d message=%q", resp.StatusCode, e.Message)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// jwt authenticates as the app. It's issued a minute in the past to allow
+// for clock drift.
+func (a *App) jwt(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(jwtTTL).Unix(),
+		"iss": a.id,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(payload))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func parseKey(s string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(strings.ReplaceAll(s, `\n`, "\n")))
+	if block == nil {
+		return nil, fmt.Errorf("error: GITHUB_APP_PRIVATE_KEY is not a PEM private key")
+	}
+
+	// GitHub issues PKCS #1 keys
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error: fail to parse GITHUB_APP_PRIVATE_KEY: %w", err)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("error: GITHUB_APP_PRIVATE_KEY is not an RSA key")
+	}
+
+	return rsaKey, nil
+}
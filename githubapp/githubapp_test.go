@@ -0,0 +1,126 @@
+package githubapp
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	var body map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := verifyJWT(&key.PublicKey, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")); err != nil {
+			t.Errorf("%s %s: %s", r.Method, r.URL.Path, err)
+		}
+
+		switch r.URL.Path {
+		case "/repos/jingweno/codeface/installation":
+			w.Write([]byte(`{"id":42}`))
+		case "/app/installations/42/access_tokens":
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"token":"ghs_1234","expires_at":"2024-01-01T01:00:00Z"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"Not Found"}`))
+		}
+	}))
+	defer srv.Close()
+
+	// the key is usually set with escaped newlines
+	a, err := New(Config{AppID: "1234", PrivateKey: strings.ReplaceAll(string(pemKey), "\n", `\n`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.baseURL = srv.URL
+
+	for _, write := range []bool{false, true} {
+		tok, err := a.Token(context.Background(), "jingweno/codeface", write)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok.Token != "ghs_1234" || !tok.ExpiresAt.Equal(time.Date(2024, time.January, 1, 1, 0, 0, 0, time.UTC)) {
+			t.Errorf("unexpected token %+v", tok)
+		}
+
+		// the token is only for the repository
+		if repos, _ := json.Marshal(body["repositories"]); string(repos) != `["codeface"]` {
+			t.Errorf("want token for codeface, got %s", repos)
+		}
+		want := map[bool]string{false: "read", true: "write"}[write]
+		if perms := body["permissions"].(map[string]interface{}); perms["contents"] != want {
+			t.Errorf("want contents permission %s, got %v", want, perms["contents"])
+		}
+	}
+
+	if _, err := a.Token(context.Background(), "jingweno/other", false); err == nil {
+		t.Error("want error for a repository without the app installed")
+	}
+	if _, err := a.Token(context.Background(), "jingweno", false); err == nil {
+		t.Error("want error for an invalid repository")
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{AppID: "1234", PrivateKey: "not a key"}); err == nil {
+		t.Error("want error for an invalid key")
+	}
+}
+
+func verifyJWT(pub *rsa.PublicKey, jwt string) error {
+	split := strings.Split(jwt, ".")
+	if len(split) != 3 {
+		return errInvalidJWT
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(split[2])
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(split[0] + "." + split[1]))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig); err != nil {
+		return err
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(split[1])
+	if err != nil {
+		return err
+	}
+	var claims struct {
+		Iss string `json:"iss"`
+		Iat int64  `json:"iat"`
+		Exp int64  `json:"exp"`
+	}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	if claims.Iss != "1234" || claims.Iat > now || claims.Exp <= now || claims.Exp-claims.Iat > 600 {
+		return errInvalidJWT
+	}
+
+	return nil
+}
+
+var errInvalidJWT = errors.New("invalid JWT")
@@ -3,8 +3,7 @@ module github.com/jingweno/codeface
 go 1.14
 
 require (
-	github.com/golang/protobuf v1.3.2 // indirect
-	github.com/google/uuid v1.1.1 // indirect
+	github.com/cenkalti/backoff v2.1.1+incompatible
 	github.com/gopherjs/vecty v0.0.0-20200328200803-52636d1f7aba
 	github.com/gorilla/mux v1.7.4
 	github.com/gorilla/securecookie v1.1.1
@@ -12,16 +11,16 @@ require (
 	github.com/heroku/heroku-go/v5 v5.2.0
 	github.com/joeshaw/envdecode v0.0.0-20200121155833-099f1fc765bd
 	github.com/konsorten/go-windows-terminal-sequences v1.0.3 // indirect
-	github.com/oklog/run v1.1.0
+	github.com/lib/pq v1.8.0
+	github.com/minio/minio-go/v7 v7.0.5
 	github.com/pkg/browser v0.0.0-20180916011732-0a3d74bf9ce4
+	github.com/prometheus/client_golang v1.7.1
 	github.com/rs/xid v1.2.1
 	github.com/shurcooL/httpgzip v0.0.0-20190720172056-320755c1c1b0
 	github.com/sirupsen/logrus v1.5.0
 	github.com/spf13/cobra v1.0.0
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/stretchr/testify v1.5.1 // indirect
-	golang.org/x/net v0.0.0-20200226121028-0de0cce0169b // indirect
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
-	golang.org/x/sys v0.0.0-20200501052902-10377860bb8e // indirect
-	gopkg.in/yaml.v2 v2.2.7 // indirect
+	gopkg.in/yaml.v2 v2.2.8
 )
@@ -0,0 +1,168 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+
+	checkTimeout = 5 * time.Second
+	// CacheTTL is how long the result of a check calling the Heroku API is reused
+	CacheTTL = time.Minute
+)
+
+// Check reports whether a dependency is usable. Detail is included in the
+// readiness report as is.
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) (detail interface{}, err error)
+}
+
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+type Result struct {
+	Status string      `json:"status"`
+	Error  string      `json:"error,omitempty"`
+	Detail interface{} `json:"detail,omitempty"`
+}
+
+// Cached reuses the result of c for ttl, so that frequent probes don't use
+// up the rate limit of a dependency. Concurrent probes wait for one run.
+func Cached(c Check, ttl time.Duration) Check {
+	var (
+		mu      sync.Mutex
+		checked time.Time
+		detail  interface{}
+		err     error
+	)
+
+	return Check{
+		Name: c.Name,
+		Check: func(ctx context.Context) (interface{}, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if time.Since(checked) < ttl {
+				return detail, err
+			}
+
+			detail, err = c.Check(ctx)
+			checked = time.Now()
+			return detail, err
+		},
+	}
+}
+
+// Run runs all checks concurrently. The report fails if any check fails.
+func Run(ctx context.Context, checks []Check) Report {
+	report := Report{
+		Status: StatusOK,
+		Checks: make(map[string]Result, len(checks)),
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c Check) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+
+			detail, err := c.Check(ctx)
+			result := Result{Status: StatusOK, Detail: detail}
+			if err != nil {
+				result.Status = StatusFail
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[c.Name] = result
+			if err != nil {
+				report.Status = StatusFail
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	return report
+}
+
+// LivenessHandler reports that the process is up without checking any dependency
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, StatusOK)
+	})
+}
+
+// ReadinessHandler reports the result of the checks as JSON. It responds with
+// 503 if any check fails.
+func ReadinessHandler(checks ...Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := Run(r.Context(), checks)
+
+		status := http.StatusOK
+		if report.Status != StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+// HerokuCheck checks that the Heroku API is reachable with the client's credentials
+func HerokuCheck(client *heroku.Service) Check {
+	return Check{
+		Name: "heroku",
+		Check: func(ctx context.Context) (interface{}, error) {
+			rl, err := client.RateLimitInfo(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			return map[string]int{"ratelimit_remaining": rl.Remaining}, nil
+		},
+	}
+}
+
+// PoolCheck reports the number of idle apps by template version. An empty
+// pool does not fail the check since editors can still be claimed by identity.
+func PoolCheck(client *heroku.Service, team string) Check {
+	return Check{
+		Name: "pool",
+		Check: func(ctx context.Context) (interface{}, error) {
+			currentVersion, otherVersion, err := editor.AllIdledApps(ctx, client, team)
+			if err != nil {
+				return nil, err
+			}
+
+			idle := map[string]int{editor.CurrentVersion(): len(currentVersion)}
+			for _, app := range otherVersion {
+				_, version, _ := editor.ParseAppName(app.Name)
+				idle[version]++
+			}
+
+			return map[string]interface{}{"idle": idle}, nil
+		},
+	}
+}
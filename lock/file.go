@@ -0,0 +1,66 @@
+//go:build !windows
+// +build !windows
+
+package lock
+
+import (
+	"context"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// NewFile returns a lock shared by the processes of a host. The lock is
+// released by the OS if the process holding it dies.
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+type File struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+func (l *File) TryLock(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f != nil {
+		return false, nil
+	}
+
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return false, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+
+		if err == syscall.EWOULDBLOCK {
+			return false, nil
+		}
+		return false, err
+	}
+
+	l.f = f
+	return true, nil
+}
+
+func (l *File) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return ErrNotLocked
+	}
+
+	defer func() {
+		l.f.Close()
+		l.f = nil
+	}()
+
+	return syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+}
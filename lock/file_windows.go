@@ -0,0 +1,21 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+)
+
+// NewFile is not supported on Windows, TryLock always fails
+func NewFile(path string) *File {
+	return &File{}
+}
+
+type File struct{}
+
+func (l *File) TryLock(ctx context.Context) (bool, error) {
+	return false, fmt.Errorf("error: file lock is not supported on windows")
+}
+
+func (l *File) Unlock(ctx context.Context) error {
+	return ErrNotLocked
+}
@@ -0,0 +1,54 @@
+// Package lock provides mutually exclusive locks shared by workers so that
+// only one of them changes the pool at a time.
+package lock
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrNotLocked = errors.New("error: lock is not held")
+
+// Locker is a non-blocking mutually exclusive lock
+type Locker interface {
+	// TryLock acquires the lock without waiting. ok is false if the lock is
+	// held by someone else.
+	TryLock(ctx context.Context) (ok bool, err error)
+	// Unlock releases the lock acquired by TryLock
+	Unlock(ctx context.Context) error
+}
+
+// NewMemory returns a lock shared by the workers of a single process
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+type Memory struct {
+	mu     sync.Mutex
+	locked bool
+}
+
+func (m *Memory) TryLock(ctx context.Context) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.locked {
+		return false, nil
+	}
+
+	m.locked = true
+	return true, nil
+}
+
+func (m *Memory) Unlock(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.locked {
+		return ErrNotLocked
+	}
+
+	m.locked = false
+	return nil
+}
@@ -0,0 +1,85 @@
+package lock
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"hash/fnv"
+	"sync"
+
+	// register the postgres driver
+	_ "github.com/lib/pq"
+)
+
+// NewPostgres returns a lock backed by a Postgres session level advisory
+// lock. The lock is released by Postgres if the connection holding it is closed.
+func NewPostgres(db *sql.DB, name string) *Postgres {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+
+	return &Postgres{
+		db:  db,
+		key: int64(h.Sum64()),
+	}
+}
+
+type Postgres struct {
+	db  *sql.DB
+	key int64
+
+	mu   sync.Mutex
+	conn *sql.Conn
+}
+
+func (l *Postgres) TryLock(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn != nil {
+		return false, nil
+	}
+
+	// advisory locks belong to a session so the same connection must be used to unlock
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var ok bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&ok); err != nil {
+		conn.Close()
+		return false, err
+	}
+
+	if !ok {
+		conn.Close()
+		return false, nil
+	}
+
+	l.conn = conn
+	return true, nil
+}
+
+func (l *Postgres) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return ErrNotLocked
+	}
+
+	conn := l.conn
+	l.conn = nil
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.key); err != nil {
+		// the session may still hold the lock, so it's discarded instead of
+		// going back to the pool, and Postgres releases the lock
+		conn.Raw(func(interface{}) error {
+			return driver.ErrBadConn
+		})
+		conn.Close()
+		return err
+	}
+
+	return conn.Close()
+}
@@ -0,0 +1,182 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "codeface"
+
+var (
+	// PoolApps is the number of Codeface apps by state and template version,
+	// e.g. alert on sum(codeface_pool_apps{state="idle"}) == 0 for an empty pool
+	PoolApps = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pool_apps",
+		Help:      "Number of Codeface apps by state and template version.",
+	}, []string{"state", "version"})
+
+	PoolTargetSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pool_target_size",
+		Help:      "Number of idle apps of the current version the worker aims to keep.",
+	})
+
+	BuildDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "build_duration_seconds",
+		Help:      "Duration of successful editor builds.",
+		Buckets:   prometheus.ExponentialBuckets(30, 1.5, 10), // 30s to ~19m
+	})
+
+	// BreakerOpen is known to the worker even if the breaker isn't shared
+	// with other processes, e.g. alert on codeface_breaker_open == 1
+	BreakerOpen = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "breaker_open",
+		Help:      "1 while pool fills are paused after consecutive build failures.",
+	})
+
+	BuildFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "build_failures_total",
+		Help:      "Number of failed editor builds.",
+	})
+
+	ClaimStepDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "claim_step_duration_seconds",
+		Help:      "Duration of each step of claiming an editor.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"step"})
+
+	Claims = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "claims_total",
+		Help:      "Number of editor claims by outcome.",
+	}, []string{"outcome"})
+
+	HerokuAPIRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "heroku_api_requests_total",
+		Help:      "Number of Heroku API requests by method and status code.",
+	}, []string{"method", "code"})
+
+	HerokuRateLimitRemaining = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "heroku_ratelimit_remaining",
+		Help:      "Remaining Heroku API requests reported by the last response.",
+	})
+
+	// RepoLookups shows how many lookups of Git repositories are served by the cache
+	RepoLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "repo_lookups_total",
+		Help:      "Number of Git repository lookups by host and result.",
+	}, []string{"host", "result"})
+)
+
+const (
+	ClaimOutcomeSuccess   = "success"
+	ClaimOutcomeEmptyPool = "empty_pool"
+	ClaimOutcomeFailure   = "failure"
+	// ClaimOutcomeRejected is a claim for a recipient who can't own an editor
+	ClaimOutcomeRejected = "rejected"
+
+	RepoLookupCached      = "cached"
+	RepoLookupNotModified = "not_modified"
+	RepoLookupFetched     = "fetched"
+	RepoLookupError       = "error"
+)
+
+func init() {
+	prometheus.MustRegister(
+		PoolApps,
+		PoolTargetSize,
+		BuildDuration,
+		BreakerOpen,
+		BuildFailures,
+		ClaimStepDuration,
+		Claims,
+		HerokuAPIRequests,
+		HerokuRateLimitRemaining,
+		RepoLookups,
+	)
+}
+
+// Handler serves the registered metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// PoolCount is the number of apps of a state and version
+type PoolCount struct {
+	State   string
+	Version string
+	Count   int
+}
+
+// poolLabels are the states and versions last set by SetPoolApps
+var (
+	poolLabels   = map[[2]string]bool{}
+	poolLabelsMu sync.Mutex
+)
+
+// SetPoolApps sets the pool gauges and removes states and versions that are
+// no longer reported. A state without apps must be in counts with a zero
+// count, otherwise its series disappears instead of dropping to 0.
+func SetPoolApps(counts []PoolCount) {
+	poolLabelsMu.Lock()
+	defer poolLabelsMu.Unlock()
+
+	labels := make(map[[2]string]bool, len(counts))
+	for _, c := range counts {
+		PoolApps.WithLabelValues(c.State, c.Version).Set(float64(c.Count))
+		labels[[2]string{c.State, c.Version}] = true
+	}
+
+	for l := range poolLabels {
+		if !labels[l] {
+			PoolApps.DeleteLabelValues(l[0], l[1])
+		}
+	}
+	poolLabels = labels
+}
+
+// ObserveClaimStep records the duration of a claim step started at start
+func ObserveClaimStep(step string, start time.Time) {
+	ClaimStepDuration.WithLabelValues(step).Observe(time.Since(start).Seconds())
+}
+
+// HerokuTransport counts requests to the Heroku API and records the remaining rate limit
+func HerokuTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := base.RoundTrip(req)
+		if err != nil {
+			HerokuAPIRequests.WithLabelValues(req.Method, "error").Inc()
+			return resp, err
+		}
+
+		HerokuAPIRequests.WithLabelValues(req.Method, strconv.Itoa(resp.StatusCode)).Inc()
+		if remaining, err := strconv.Atoi(resp.Header.Get("RateLimit-Remaining")); err == nil {
+			HerokuRateLimitRemaining.Set(float64(remaining))
+		}
+
+		return resp, nil
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -1,51 +1,123 @@
 package model
 
 import (
-	"fmt"
-	"net/http"
-	"net/url"
-	"path"
-	"strings"
+	"time"
 )
 
 type EditorRequest struct {
 	GitRepo string
+	// Ref overrides the branch, tag, commit or pull request of GitRepo
+	Ref string
 }
 
-func ParseGitHubRepoURL(s string) (string, error) {
-	u, err := url.ParseRequestURI(s)
-	if err != nil {
-		return "", err
-	}
-
-	if u.Scheme != "https" || u.Host != "github.com" {
-		return "", fmt.Errorf("Please provide a GitHub repository URL")
-	}
+type EditorResponse struct {
+	ID  string
+	URL string
+	// Warnings tell what of the repository configuration isn't applied
+	Warnings []string `json:",omitempty"`
+}
 
-	split := strings.Split(strings.TrimLeft(path.Clean(u.Path), "/"), "/")
-	if len(split) < 2 {
-		return "", fmt.Errorf("Please provide a valid GitHub repository URL")
-	}
+type ErrorResponse struct {
+	Error string
+	// Code identifies the error for clients to act on, e.g. recipient_unverified
+	Code string `json:",omitempty"`
+	// Errors are all the problems when there are several, e.g. of a .codeface.yml
+	Errors []string `json:",omitempty"`
+}
 
-	user := split[0]
-	repo := split[1]
+// GitHubStatus tells whether the user can use private GitHub repositories
+type GitHubStatus struct {
+	Enabled   bool
+	Connected bool
+}
 
-	resp, err := http.Get(fmt.Sprintf("https://api.github.com/repos/%s/%s", user, repo))
-	if err != nil {
-		return "", err
-	}
+// GitCredential is a short-lived token for an editor to clone and push its
+// repository
+type GitCredential struct {
+	Username  string
+	Password  string
+	ExpiresAt time.Time
+}
 
-	if resp.StatusCode == 200 {
-		return fmt.Sprintf("https://github.com/%s/%s", user, repo), nil
-	}
+type UpgradeRequest struct {
+	// Confirm acknowledges that the editor is restarted and its disk is wiped
+	Confirm bool
+}
 
-	return "", fmt.Errorf("GitHub repository is not found or accessible")
+type UpgradeResponse struct {
+	Apps    []string
+	Message string
 }
 
-type EditorResponse struct {
+const (
+	WorkspaceSnapshot = "snapshot"
+	WorkspaceRestore  = "restore"
+
+	WorkspaceSucceeded = "succeeded"
+	WorkspaceFailed    = "failed"
+	// WorkspaceEmpty is reported by a restore when there is no snapshot yet
+	WorkspaceEmpty = "empty"
+)
+
+type WorkspaceURLResponse struct {
 	URL string
 }
 
-type ErrorResponse struct {
-	Error string
+// WorkspaceStatus is reported by an editor after a workspace snapshot or restore
+type WorkspaceStatus struct {
+	Operation string
+	Status    string
+	Error     string `json:",omitempty"`
+	Size      int64
+	At        time.Time
+}
+
+// Secret is a secret of a user. Its value is never returned by the server.
+type Secret struct {
+	Name string
+	// Repo is empty for a secret of every repository
+	Repo      string `json:",omitempty"`
+	UpdatedAt time.Time
+}
+
+type SecretRequest struct {
+	Value string
+	// Repo limits the secret to a repository
+	Repo string
+}
+
+// Profile is kept by the server for each user
+type Profile struct {
+	// DotfilesRepo is cloned into the home dir of every editor claimed by the user
+	DotfilesRepo string
+	// DotfilesInstall is the install script in DotfilesRepo, e.g. script/setup.
+	// The agent looks for install.sh, bootstrap.sh and the like if it's empty.
+	DotfilesInstall string `json:",omitempty"`
+}
+
+// user settings synced across the editors of a user
+const (
+	SettingsJSON    = "settings.json"
+	KeybindingsJSON = "keybindings.json"
+	// ExtensionsJSON is a JSON array of extension IDs
+	ExtensionsJSON = "extensions.json"
+)
+
+type SettingsFile struct {
+	Name      string
+	Content   string
+	UpdatedAt time.Time
+	// Base is when the version an editor changed was updated. The file is
+	// in conflict if the server has another version by then.
+	Base time.Time
+}
+
+type SettingsRequest struct {
+	Files []SettingsFile
+}
+
+type SettingsResponse struct {
+	Files []SettingsFile
+	// Conflicts are the files of which the older version is backed up
+	Conflicts []string `json:",omitempty"`
 }
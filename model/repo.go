@@ -0,0 +1,476 @@
+package model
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnsupportedRepo = fmt.Errorf("Please provide a Git repository URL")
+
+	// branches, tags and commits, refs/... for pull requests
+	refRegexp = regexp.MustCompile(`^[\w][\w./-]*$`)
+
+	// git@host:owner/repo.git, neither the user nor the host starts with a
+	// dash so that ssh never takes them as options
+	scpRepoRegexp = regexp.MustCompile(`^(\w[\w.-]*)@(\w[\w.-]*):([^/].*)$`)
+)
+
+// Repo is a Git repository to open an editor for
+type Repo struct {
+	// URL is the normalized URL to clone the repository
+	URL string
+	// Host serves the repository, e.g. github.com
+	Host string
+	// Path of the repository on the host without .git, e.g. jingweno/codeface
+	Path string
+	// Ref to check out after cloning if the URL points to a branch, tag,
+	// commit or pull request, e.g. main, v1.0.0 or refs/pull/1/head
+	Ref string
+	// RefCandidates are the possible branches of a tree or blob page, longest
+	// first, as a branch may contain slashes, e.g. tree/feature/x is either
+	// the branch feature/x or the directory x of the branch feature. Ref is
+	// the shortest until the host tells which branch exists.
+	RefCandidates []string `json:",omitempty"`
+}
+
+// ValidateRef checks that a ref is safe to pass to git
+func ValidateRef(ref string) error {
+	if !refRegexp.MatchString(ref) || strings.Contains(ref, "..") {
+		return fmt.Errorf("Please provide a valid branch, tag or commit")
+	}
+
+	return nil
+}
+
+// RepoResolver understands the repository URLs of a Git host. It never
+// accesses the network so that it can run in the browser, the server sends
+// the lookup requests.
+type RepoResolver interface {
+	// Normalize returns the repository of the URL or ErrUnsupportedRepo if
+	// the URL isn't for this host
+	Normalize(s string) (*Repo, error)
+	// LookupRequest returns a request that succeeds if the repository is
+	// accessible, or nil if it can't be checked. The token is optional.
+	LookupRequest(repo *Repo, token string) (*http.Request, error)
+	// FileRequest returns a request for the raw content of a file at the
+	// ref of the repository, or nil if the host has no API for it
+	FileRequest(repo *Repo, path, token string) (*http.Request, error)
+	// BranchRequest returns a request that succeeds if the branch exists, or
+	// nil if the host has no API for it
+	BranchRequest(repo *Repo, branch, token string) (*http.Request, error)
+}
+
+// maxBranchSegments limits the lookups of the candidates of a ref
+const maxBranchSegments = 5
+
+// DefaultRepoResolvers returns the resolvers for the hosted Git services,
+// GitHub Enterprise hosts and any other Git host, in that order
+func DefaultRepoResolvers(githubEnterpriseHosts ...string) []RepoResolver {
+	resolvers := []RepoResolver{NewGitHubResolver()}
+	for _, h := range githubEnterpriseHosts {
+		resolvers = append(resolvers, NewGitHubEnterpriseResolver(h))
+	}
+
+	return append(resolvers, NewGitLabResolver(), NewBitbucketResolver(), NewGitResolver())
+}
+
+// NormalizeRepo returns the repository of the URL from the first resolver that handles it
+func NormalizeRepo(resolvers []RepoResolver, s string) (*Repo, RepoResolver, error) {
+	s = strings.TrimSpace(s)
+	for _, r := range resolvers {
+		repo, err := r.Normalize(s)
+		if err == ErrUnsupportedRepo {
+			continue
+		}
+
+		return repo, r, err
+	}
+
+	return nil, nil, ErrUnsupportedRepo
+}
+
+// NewGitHubResolver returns a resolver for github.com
+func NewGitHubResolver() *HostedRepoResolver {
+	return &HostedRepoResolver{
+		Host: "github.com",
+		apiURL: func(repo *Repo) string {
+			return "https://api.github.com/repos/" + repo.Path
+		},
+		fileURL:   githubFileURL("https://api.github.com"),
+		branchURL: githubBranchURL("https://api.github.com"),
+		authorize: githubAuthorization,
+		ref:       githubRef,
+	}
+}
+
+// NewGitHubEnterpriseResolver returns a resolver for a GitHub Enterprise Server
+func NewGitHubEnterpriseResolver(host string) *HostedRepoResolver {
+	return &HostedRepoResolver{
+		Host: host,
+		apiURL: func(repo *Repo) string {
+			return fmt.Sprintf("https://%s/api/v3/repos/%s", repo.Host, repo.Path)
+		},
+		fileURL:   githubFileURL(fmt.Sprintf("https://%s/api/v3", host)),
+		branchURL: githubBranchURL(fmt.Sprintf("https://%s/api/v3", host)),
+		authorize: githubAuthorization,
+		ref:       githubRef,
+	}
+}
+
+// NewGitLabResolver returns a resolver for gitlab.com. Projects may be
+// nested in subgroups.
+func NewGitLabResolver() *HostedRepoResolver {
+	return &HostedRepoResolver{
+		Host:   "gitlab.com",
+		nested: true,
+		apiURL: func(repo *Repo) string {
+			return "https://gitlab.com/api/v4/projects/" + url.PathEscape(repo.Path)
+		},
+		fileURL: func(repo *Repo, path string) string {
+			ref := repo.Ref
+			if ref == "" {
+				ref = "HEAD"
+			}
+			return fmt.Sprintf("https://gitlab.com/api/v4/projects/%s/repository/files/%s/raw?%s",
+				url.PathEscape(repo.Path), url.PathEscape(path), url.Values{"ref": {ref}}.Encode())
+		},
+		branchURL: func(repo *Repo, branch string) string {
+			return fmt.Sprintf("https://gitlab.com/api/v4/projects/%s/repository/branches/%s",
+				url.PathEscape(repo.Path), url.PathEscape(branch))
+		},
+		authorize: bearerAuthorization,
+		ref:       gitlabRef,
+	}
+}
+
+// NewBitbucketResolver returns a resolver for bitbucket.org. Files aren't read
+// from Bitbucket.
+func NewBitbucketResolver() *HostedRepoResolver {
+	return &HostedRepoResolver{
+		Host: "bitbucket.org",
+		apiURL: func(repo *Repo) string {
+			return "https://api.bitbucket.org/2.0/repositories/" + repo.Path
+		},
+		authorize: bearerAuthorization,
+		ref:       bitbucketRef,
+	}
+}
+
+// HostedRepoResolver resolves https URLs of a Git host with a REST API to
+// look up repositories
+type HostedRepoResolver struct {
+	Host string
+
+	// nested allows more than owner/repo in the path
+	nested    bool
+	apiURL    func(repo *Repo) string
+	fileURL   func(repo *Repo, path string) string
+	branchURL func(repo *Repo, branch string) string
+	authorize func(req *http.Request, token string)
+	// ref returns the candidates of the ref of the page after owner/repo,
+	// longest first, e.g. main for tree/main
+	ref func(page []string) ([]string, error)
+}
+
+func (r *HostedRepoResolver) Normalize(s string) (*Repo, error) {
+	// anchors of a page, e.g. #L10
+	if i := strings.Index(s, "#"); i >= 0 {
+		s = s[:i]
+	}
+
+	u, err := url.ParseRequestURI(s)
+	if err != nil || u.Host != r.Host {
+		return nil, ErrUnsupportedRepo
+	}
+
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("Please provide an https URL of the %s repository", r.Host)
+	}
+
+	split := strings.Split(strings.Trim(path.Clean(u.Path), "/"), "/")
+	if len(split) < 2 || split[0] == "" {
+		return nil, fmt.Errorf("Please provide a valid %s repository URL", r.Host)
+	}
+
+	n := 2
+	if r.nested {
+		// pages of a project are under /-/, e.g. group/sub/repo/-/tree/main
+		n = len(split)
+		for i, s := range split {
+			if s == "-" {
+				n = i
+				break
+			}
+		}
+		if n < 2 {
+			return nil, fmt.Errorf("Please provide a valid %s repository URL", r.Host)
+		}
+	}
+
+	p := strings.TrimSuffix(strings.Join(split[:n], "/"), ".git")
+
+	page := split[n:]
+	if len(page) > 0 && page[0] == "-" {
+		page = page[1:]
+	}
+
+	var refs []string
+	if len(page) > 0 {
+		if refs, err = r.ref(page); err != nil {
+			return nil, err
+		}
+		for _, ref := range refs {
+			if err := ValidateRef(ref); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	repo := &Repo{
+		URL:  fmt.Sprintf("https://%s/%s", r.Host, p),
+		Host: r.Host,
+		Path: p,
+	}
+	if len(refs) > 0 {
+		repo.Ref = refs[len(refs)-1]
+	}
+	if len(refs) > 1 {
+		repo.RefCandidates = refs
+	}
+
+	return repo, nil
+}
+
+func (r *HostedRepoResolver) LookupRequest(repo *Repo, token string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, r.apiURL(repo), nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		r.authorize(req, token)
+	}
+
+	return req, nil
+}
+
+func (r *HostedRepoResolver) FileRequest(repo *Repo, path, token string) (*http.Request, error) {
+	if r.fileURL == nil {
+		return nil, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, r.fileURL(repo, path), nil)
+	if err != nil {
+		return nil, err
+	}
+	// the raw content instead of a JSON document with the content in base64
+	req.Header.Set("Accept", "application/vnd.github.v3.raw")
+	if token != "" {
+		r.authorize(req, token)
+	}
+
+	return req, nil
+}
+
+func (r *HostedRepoResolver) BranchRequest(repo *Repo, branch, token string) (*http.Request, error) {
+	if r.branchURL == nil {
+		return nil, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, r.branchURL(repo, branch), nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		r.authorize(req, token)
+	}
+
+	return req, nil
+}
+
+// githubBranchURL returns the URL of a branch of GitHub or a GitHub Enterprise Server
+func githubBranchURL(api string) func(repo *Repo, branch string) string {
+	return func(repo *Repo, branch string) string {
+		return fmt.Sprintf("%s/repos/%s/branches/%s", api, repo.Path, branch)
+	}
+}
+
+// githubFileURL returns the URL of the contents API of GitHub or a GitHub Enterprise Server
+func githubFileURL(api string) func(repo *Repo, path string) string {
+	return func(repo *Repo, path string) string {
+		u := fmt.Sprintf("%s/repos/%s/contents/%s", api, repo.Path, path)
+		if repo.Ref != "" {
+			u += "?" + url.Values{"ref": {repo.Ref}}.Encode()
+		}
+		return u
+	}
+}
+
+// githubRef understands tree/<branch>/<dir>, blob/<ref>/<file>, commit/<sha> and pull/<n>
+func githubRef(page []string) ([]string, error) {
+	return pageRef(page, map[string]string{
+		"tree":   "tree",
+		"blob":   "blob",
+		"commit": "commit",
+		"pull":   "refs/pull/%s/head",
+	})
+}
+
+// gitlabRef understands -/tree/<branch>/<dir>, -/blob/<ref>/<file>, -/commit/<sha> and -/merge_requests/<n>
+func gitlabRef(page []string) ([]string, error) {
+	return pageRef(page, map[string]string{
+		"tree":           "tree",
+		"blob":           "blob",
+		"commit":         "commit",
+		"merge_requests": "refs/merge-requests/%s/head",
+	})
+}
+
+// bitbucketRef understands src/<ref>/<file>, branch/<branch> and commits/<sha>.
+// Bitbucket doesn't expose pull requests as refs.
+func bitbucketRef(page []string) ([]string, error) {
+	if page[0] == "pull-requests" {
+		return nil, fmt.Errorf("Please open the source branch of the Bitbucket pull request")
+	}
+
+	return pageRef(page, map[string]string{
+		"src":     "blob",
+		"branch":  "branch",
+		"commits": "commit",
+	})
+}
+
+// pageRef returns the candidates of the ref of a page by its kind, longest
+// first. The branch of a tree or blob may contain slashes and is followed by
+// a path, the rest of a branch page is the branch and a pull request is
+// formatted into its ref. Other pages, e.g. issues, have no ref.
+func pageRef(page []string, kinds map[string]string) ([]string, error) {
+	kind, ok := kinds[page[0]]
+	if !ok || len(page) < 2 {
+		return nil, nil
+	}
+
+	switch kind {
+	case "tree", "blob":
+		// the last segment of a blob is a file
+		n := len(page)
+		if kind == "blob" && n > 2 {
+			n--
+		}
+		if n > maxBranchSegments+1 {
+			n = maxBranchSegments + 1
+		}
+
+		var refs []string
+		for i := n; i >= 2; i-- {
+			refs = append(refs, strings.Join(page[1:i], "/"))
+		}
+		return refs, nil
+	case "branch":
+		return []string{strings.Join(page[1:], "/")}, nil
+	case "commit":
+		return []string{page[1]}, nil
+	default:
+		if _, err := strconv.Atoi(page[1]); err != nil {
+			return nil, fmt.Errorf("Please provide a valid pull request URL")
+		}
+		return []string{fmt.Sprintf(kind, page[1])}, nil
+	}
+}
+
+func githubAuthorization(req *http.Request, token string) {
+	req.Header.Set("Authorization", "token "+token)
+}
+
+func bearerAuthorization(req *http.Request, token string) {
+	req.Header.Set("Authorization", "Bearer "+token)
+}
+
+// NewGitResolver returns a resolver for any Git host over https, ssh or
+// the scp-like form git@host:owner/repo.git. A ref may follow #, e.g.
+// https://example.com/repo.git#main.
+func NewGitResolver() *GitResolver {
+	return &GitResolver{}
+}
+
+type GitResolver struct{}
+
+func (r *GitResolver) Normalize(s string) (*Repo, error) {
+	// git takes the URL as an option
+	if strings.HasPrefix(s, "-") {
+		return nil, fmt.Errorf("Please provide a valid Git repository URL")
+	}
+
+	var ref string
+	if i := strings.LastIndex(s, "#"); i >= 0 {
+		s, ref = s[:i], s[i+1:]
+		if err := ValidateRef(ref); err != nil {
+			return nil, err
+		}
+	}
+
+	if m := scpRepoRegexp.FindStringSubmatch(s); m != nil && !strings.Contains(s, "://") {
+		return &Repo{
+			URL:  s,
+			Host: m[2],
+			Path: strings.TrimSuffix(strings.Trim(m[3], "/"), ".git"),
+			Ref:  ref,
+		}, nil
+	}
+
+	u, err := url.ParseRequestURI(s)
+	if err != nil || u.Host == "" {
+		return nil, ErrUnsupportedRepo
+	}
+
+	switch u.Scheme {
+	case "https", "ssh":
+	default:
+		return nil, ErrUnsupportedRepo
+	}
+
+	p := strings.Trim(path.Clean(u.Path), "/")
+	if p == "" || p == "." {
+		return nil, fmt.Errorf("Please provide a valid Git repository URL")
+	}
+
+	// credentials in the URL would be visible to anyone with access to the editor
+	if _, ok := u.User.Password(); ok {
+		return nil, fmt.Errorf("Please provide a Git repository URL without a password")
+	}
+
+	u.RawQuery = ""
+
+	return &Repo{
+		URL:  strings.TrimRight(u.String(), "/"),
+		Host: u.Hostname(),
+		Path: strings.TrimSuffix(p, ".git"),
+		Ref:  ref,
+	}, nil
+}
+
+// FileRequest returns nil as there is no common API to read files
+func (r *GitResolver) FileRequest(repo *Repo, path, token string) (*http.Request, error) {
+	return nil, nil
+}
+
+// BranchRequest returns nil as there is no common API to look up branches
+func (r *GitResolver) BranchRequest(repo *Repo, branch, token string) (*http.Request, error) {
+	return nil, nil
+}
+
+// LookupRequest asks an https host for the refs of the repository with the
+// smart HTTP protocol. Repositories over ssh can't be checked without the
+// keys of the user.
+func (r *GitResolver) LookupRequest(repo *Repo, token string) (*http.Request, error) {
+	if !strings.HasPrefix(repo.URL, "https://") {
+		return nil, nil
+	}
+
+	return http.NewRequest(http.MethodGet, repo.URL+"/info/refs?service=git-upload-pack", nil)
+}
@@ -0,0 +1,33 @@
+package model
+
+import "strings"
+
+// config vars the agent of an editor reads to set up the repository
+const (
+	ExtensionsVar        = "CODEFACE_EXTENSIONS"
+	ForwardPortsVar      = "CODEFACE_FORWARD_PORTS"
+	PostCreateCommandVar = "CODEFACE_POST_CREATE_COMMAND"
+	SetupVar             = "CODEFACE_SETUP"
+	OpenFileVar          = "CODEFACE_OPEN_FILE"
+)
+
+// WarningsVar tells the owner in the editor what of the repository
+// configuration isn't applied, one warning per line
+const WarningsVar = "CODEFACE_WARNINGS"
+
+// config vars the agent reads to install the dotfiles of the owner
+const (
+	DotfilesRepoVar    = "CODEFACE_DOTFILES_REPO"
+	DotfilesInstallVar = "CODEFACE_DOTFILES_INSTALL"
+)
+
+// ReservedVar tells whether a config var is set by Heroku or Codeface, so
+// that a repository can't override it
+func ReservedVar(name string) bool {
+	switch name {
+	case "PORT", "DYNO", "GIT_REPO", "GIT_REF", "HOME", "PATH", "PASSWORD":
+		return true
+	}
+
+	return strings.HasPrefix(name, "CODEFACE_")
+}
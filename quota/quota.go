@@ -0,0 +1,178 @@
+// Package quota limits the editors a user can claim.
+package quota
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jingweno/codeface/editor"
+)
+
+// codes of an Error
+const (
+	MaxRunning      = "quota_max_running"
+	MaxTotal        = "quota_max_total"
+	MaxClaimsPerDay = "quota_max_claims_per_day"
+	MaxDynoSize     = "quota_max_dyno_size"
+)
+
+// Limits of a user. A zero limit is unlimited.
+type Limits struct {
+	// MaxRunning is the max number of editors scaled up at a time
+	MaxRunning int
+	// MaxTotal is the max number of editors whether they are running or not
+	MaxTotal int
+	// MaxClaimsPerDay is the max number of claims in the last 24 hours
+	MaxClaimsPerDay int
+	// MaxDynoSize is the largest dyno size, see editor.DynoSizes
+	MaxDynoSize string
+}
+
+// Decode implements envdecode.Decoder. Limits are space separated, e.g.
+// "running=2 total=5 daily=10 size=standard-1x".
+func (l *Limits) Decode(v string) error {
+	limits, err := ParseLimits(v)
+	if err != nil {
+		return err
+	}
+
+	*l = limits
+	return nil
+}
+
+// ParseLimits parses limits in the format of Limits.Decode
+func ParseLimits(v string) (Limits, error) {
+	var l Limits
+	for _, field := range strings.Fields(v) {
+		split := strings.SplitN(field, "=", 2)
+		if len(split) != 2 {
+			return l, fmt.Errorf("invalid limit %q, expect NAME=VALUE", field)
+		}
+
+		name, value := split[0], split[1]
+		if name == "size" {
+			if editor.DynoSizeRank(value) < 0 {
+				return l, fmt.Errorf("unknown dyno size %q", value)
+			}
+			l.MaxDynoSize = value
+			continue
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return l, fmt.Errorf("invalid limit %q", field)
+		}
+
+		switch name {
+		case "running":
+			l.MaxRunning = n
+		case "total":
+			l.MaxTotal = n
+		case "daily":
+			l.MaxClaimsPerDay = n
+		default:
+			return l, fmt.Errorf("unknown limit %q", name)
+		}
+	}
+
+	return l, nil
+}
+
+// Groups are the limits of users by email separated by semicolons, e.g.
+// "@example.com running=3 total=10; contractor running=1". A user is in a
+// group if their email contains its pattern, and the first matching group wins.
+type Groups []Group
+
+type Group struct {
+	Pattern string
+	Limits  Limits
+}
+
+// Decode implements envdecode.Decoder
+func (g *Groups) Decode(v string) error {
+	var groups Groups
+	for _, raw := range strings.Split(v, ";") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		fields := strings.SplitN(raw, " ", 2)
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid quota group %q, expect PATTERN LIMITS", raw)
+		}
+
+		l, err := ParseLimits(fields[1])
+		if err != nil {
+			return fmt.Errorf("invalid quota group %q: %w", raw, err)
+		}
+
+		groups = append(groups, Group{Pattern: fields[0], Limits: l})
+	}
+
+	*g = groups
+	return nil
+}
+
+// For returns the limits of the group of email, or defaults if it's in no group
+func (g Groups) For(email string, defaults Limits) Limits {
+	for _, group := range g {
+		if strings.Contains(email, group.Pattern) {
+			return group.Limits
+		}
+	}
+
+	return defaults
+}
+
+// Usage is what a user has claimed
+type Usage struct {
+	Running     int
+	Total       int
+	ClaimsToday int
+}
+
+// Error tells the limit a claim hits
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return "error: " + e.Message
+}
+
+// Check returns an *Error if claiming another editor of size exceeds the
+// limits. An empty size is the size of the pool apps, which isn't limited.
+func (l Limits) Check(u Usage, size string) error {
+	if l.MaxDynoSize != "" && size != "" && editor.DynoSizeRank(size) > editor.DynoSizeRank(l.MaxDynoSize) {
+		return &Error{
+			Code:    MaxDynoSize,
+			Message: fmt.Sprintf("Dyno size %s is larger than your limit of %s", size, l.MaxDynoSize),
+		}
+	}
+
+	if l.MaxClaimsPerDay > 0 && u.ClaimsToday >= l.MaxClaimsPerDay {
+		return &Error{
+			Code:    MaxClaimsPerDay,
+			Message: fmt.Sprintf("You have claimed %d editors in the last 24 hours, which is your limit", u.ClaimsToday),
+		}
+	}
+
+	if l.MaxTotal > 0 && u.Total >= l.MaxTotal {
+		return &Error{
+			Code:    MaxTotal,
+			Message: fmt.Sprintf("You have %d editors, which is your limit. Delete an editor to claim another one", u.Total),
+		}
+	}
+
+	if l.MaxRunning > 0 && u.Running >= l.MaxRunning {
+		return &Error{
+			Code:    MaxRunning,
+			Message: fmt.Sprintf("You have %d running editors, which is your limit. Scale down an editor to claim another one", u.Running),
+		}
+	}
+
+	return nil
+}
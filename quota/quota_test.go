@@ -0,0 +1,189 @@
+package quota
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLimits(t *testing.T) {
+	cases := []struct {
+		value string
+		want  Limits
+		err   bool
+	}{
+		{
+			value: "",
+		},
+		{
+			value: "running=2 total=5 daily=10 size=standard-1x",
+			want:  Limits{MaxRunning: 2, MaxTotal: 5, MaxClaimsPerDay: 10, MaxDynoSize: "standard-1x"},
+		},
+		{
+			value: "  total=3   running=0 ",
+			want:  Limits{MaxTotal: 3},
+		},
+		{
+			value: "running",
+			err:   true,
+		},
+		{
+			value: "running=-1",
+			err:   true,
+		},
+		{
+			value: "running=two",
+			err:   true,
+		},
+		{
+			value: "size=huge",
+			err:   true,
+		},
+		{
+			value: "weekly=3",
+			err:   true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.value, func(t *testing.T) {
+			got, err := ParseLimits(c.value)
+			if c.err {
+				if err == nil {
+					t.Fatalf("want an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != c.want {
+				t.Errorf("want %+v, got %+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestGroups(t *testing.T) {
+	var g Groups
+	if err := g.Decode("@example.com running=3 total=10; contractor running=1;"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := Groups{
+		{Pattern: "@example.com", Limits: Limits{MaxRunning: 3, MaxTotal: 10}},
+		{Pattern: "contractor", Limits: Limits{MaxRunning: 1}},
+	}
+	if !reflect.DeepEqual(g, want) {
+		t.Fatalf("want groups %+v, got %+v", want, g)
+	}
+
+	defaults := Limits{MaxRunning: 2}
+	cases := []struct {
+		email string
+		want  Limits
+	}{
+		{email: "jane@example.com", want: want[0].Limits},
+		{email: "contractor@other.com", want: want[1].Limits},
+		// the first matching group wins
+		{email: "contractor@example.com", want: want[0].Limits},
+		{email: "joe@other.com", want: defaults},
+	}
+
+	for _, c := range cases {
+		if got := g.For(c.email, defaults); got != c.want {
+			t.Errorf("%s: want %+v, got %+v", c.email, c.want, got)
+		}
+	}
+
+	for _, v := range []string{"@example.com", "@example.com running=x"} {
+		if err := new(Groups).Decode(v); err == nil {
+			t.Errorf("%q: want an error", v)
+		}
+	}
+}
+
+func TestLimits_Check(t *testing.T) {
+	limits := Limits{MaxRunning: 2, MaxTotal: 5, MaxClaimsPerDay: 10, MaxDynoSize: "standard-1x"}
+
+	cases := []struct {
+		name   string
+		limits Limits
+		usage  Usage
+		size   string
+		// code is the code of the Error
+		code string
+	}{
+		{
+			name:   "unlimited",
+			limits: Limits{},
+			usage:  Usage{Running: 100, Total: 100, ClaimsToday: 100},
+			size:   "performance-l",
+		},
+		{
+			name:   "below the limits",
+			limits: limits,
+			usage:  Usage{Running: 1, Total: 4, ClaimsToday: 9},
+			size:   "standard-1x",
+		},
+		{
+			name:   "size of the pool",
+			limits: limits,
+		},
+		{
+			name:   "sizes are case insensitive",
+			limits: limits,
+			size:   "Standard-1X",
+		},
+		{
+			name:   "larger size",
+			limits: limits,
+			size:   "standard-2x",
+			code:   MaxDynoSize,
+		},
+		{
+			name:   "running",
+			limits: limits,
+			usage:  Usage{Running: 2, Total: 2},
+			code:   MaxRunning,
+		},
+		{
+			name:   "total",
+			limits: limits,
+			usage:  Usage{Total: 5},
+			code:   MaxTotal,
+		},
+		{
+			name:   "daily",
+			limits: limits,
+			usage:  Usage{ClaimsToday: 10},
+			code:   MaxClaimsPerDay,
+		},
+		{
+			// a claim that is never allowed is reported before one that
+			// is allowed later
+			name:   "size before usage",
+			limits: limits,
+			usage:  Usage{Running: 2, Total: 5, ClaimsToday: 10},
+			size:   "performance-m",
+			code:   MaxDynoSize,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.limits.Check(c.usage, c.size)
+			if c.code == "" {
+				if err != nil {
+					t.Fatalf("want no error, got %v", err)
+				}
+				return
+			}
+
+			e, ok := err.(*Error)
+			if !ok || e.Code != c.code {
+				t.Errorf("want error %s, got %v", c.code, err)
+			}
+		})
+	}
+}
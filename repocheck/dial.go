@@ -0,0 +1,120 @@
+package repocheck
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// errPrivateAddress is returned for a host on a private network that isn't trusted
+var errPrivateAddress = errors.New("error: host resolves to a private address")
+
+// privateNetworks aren't reachable from the internet, e.g. loopback, the
+// cloud metadata service on a link-local address or the private networks of
+// the dyno
+var privateNetworks = parseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"224.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets
+}
+
+func isPrivate(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dialer refuses to connect to private addresses unless the host is
+// trusted, so that a repository URL can't make the server reach into its
+// network. It checks the resolved addresses and connects to one of them, so
+// that a public name resolving to a private address is refused too.
+type dialer struct {
+	net.Dialer
+	resolver *net.Resolver
+	trusted  map[string]bool
+}
+
+func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.trusted[strings.ToLower(host)] {
+		return d.Dialer.DialContext(ctx, network, addr)
+	}
+
+	ips, err := d.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	for _, ip := range ips {
+		if isPrivate(ip.IP) {
+			return nil, errPrivateAddress
+		}
+	}
+
+	return d.Dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].IP.String(), port))
+}
+
+// newClient returns a client that only connects to public addresses and to
+// the trusted hosts
+func newClient(trustedHosts []string) *http.Client {
+	d := &dialer{
+		Dialer:   net.Dialer{Timeout: 10 * time.Second},
+		resolver: net.DefaultResolver,
+		trusted:  make(map[string]bool),
+	}
+	for _, h := range trustedHosts {
+		d.trusted[strings.ToLower(h)] = true
+	}
+
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			// a proxy would connect to the private address instead
+			Proxy:               nil,
+			DialContext:         d.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConnsPerHost: 10,
+		},
+	}
+}
@@ -0,0 +1,341 @@
+// Package repocheck checks that Git repositories are accessible before an
+// editor is claimed for them and reads their configuration files. Lookups
+// are cached and revalidated with ETags, which don't count against the rate
+// limit of GitHub.
+package repocheck
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jingweno/codeface/metrics"
+	"github.com/jingweno/codeface/model"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// negativeTTL is shorter so that a repository made public or accessible is picked up soon
+	negativeTTL = time.Minute
+	maxEntries  = 1000
+	// maxFileSize is plenty for configuration files
+	maxFileSize = 1 << 20
+)
+
+type ErrorKind int
+
+const (
+	NotFound ErrorKind = iota
+	Private
+	RateLimited
+	Unreachable
+)
+
+// Error tells why a repository isn't accessible
+type Error struct {
+	Kind ErrorKind
+	Host string
+	// Reset is when the rate limit resets, if known
+	Reset time.Time
+	Err   error
+}
+
+func (e *Error) Error() string {
+	switch e.Kind {
+	case NotFound:
+		return "Git repository is not found or you don't have access to it"
+	case Private:
+		return "Git repository is not found or is private"
+	case RateLimited:
+		if e.Reset.IsZero() {
+			return fmt.Sprintf("Rate limit of %s is exceeded, please try again later", e.Host)
+		}
+		return fmt.Sprintf("Rate limit of %s is exceeded, please try again in %s", e.Host, time.Until(e.Reset).Round(time.Second))
+	default:
+		return fmt.Sprintf("%s is unreachable, please try again later", e.Host)
+	}
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New returns a validator that caches successful lookups for ttl. Service
+// tokens by host are used when the user has no token for the host. Hosts
+// on a private network are only looked up if they're trusted.
+func New(ttl time.Duration, serviceTokens map[string]string, trustedHosts ...string) *Validator {
+	return &Validator{
+		ttl:           ttl,
+		serviceTokens: serviceTokens,
+		client:        newClient(trustedHosts),
+		logger:        log.New().WithField("com", "repocheck"),
+		cache:         make(map[string]*entry),
+	}
+}
+
+type Validator struct {
+	ttl           time.Duration
+	serviceTokens map[string]string
+	client        *http.Client
+	logger        log.FieldLogger
+
+	mu    sync.Mutex
+	cache map[string]*entry
+}
+
+type entry struct {
+	etag string
+	// body of a file, nil if it doesn't exist
+	body    []byte
+	err     error
+	expires time.Time
+}
+
+// Validate checks that the repository is accessible with the token of the user
+func (v *Validator) Validate(resolver model.RepoResolver, repo *model.Repo, token string) error {
+	token, user := v.token(repo, token)
+
+	req, err := resolver.LookupRequest(repo, token)
+	if err != nil {
+		return err
+	}
+	if req == nil {
+		return nil
+	}
+
+	e, err := v.lookup(req, repo.Host, token, user, false)
+	if err != nil {
+		return err
+	}
+
+	return e.err
+}
+
+// File returns the content of a file in the repository, or nil if the file
+// doesn't exist or the host has no API to read it
+func (v *Validator) File(resolver model.RepoResolver, repo *model.Repo, path, token string) ([]byte, error) {
+	token, user := v.token(repo, token)
+
+	req, err := resolver.FileRequest(repo, path, token)
+	if err != nil || req == nil {
+		return nil, err
+	}
+
+	e, err := v.lookup(req, repo.Host, token, user, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.body, e.err
+}
+
+// token returns the token of the user, or the service token of the host and
+// false if the user has none
+func (v *Validator) token(repo *model.Repo, token string) (string, bool) {
+	if token != "" {
+		return token, true
+	}
+
+	return v.serviceTokens[repo.Host], false
+}
+
+// lookup sends the request unless its result is cached, revalidating an
+// expired result with its ETag. The body is only read for a file, which is
+// nil if it doesn't exist. Rate limits and unreachable hosts aren't cached
+// and are returned as the error.
+func (v *Validator) lookup(req *http.Request, host, token string, user, file bool) (*entry, error) {
+	// results with a token are only valid for the token
+	key := req.URL.String()
+	if token != "" {
+		sum := sha256.Sum256([]byte(token))
+		key += "#" + hex.EncodeToString(sum[:8])
+	}
+
+	cached := v.get(key)
+	if cached != nil && time.Now().Before(cached.expires) {
+		metrics.RepoLookups.WithLabelValues(host, metrics.RepoLookupCached).Inc()
+		return cached, nil
+	}
+	if cached != nil && cached.etag != "" {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := v.client.Do(req)
+	if errors.Is(err, errPrivateAddress) {
+		// as if it doesn't exist, not to tell what's on the private network
+		metrics.RepoLookups.WithLabelValues(host, metrics.RepoLookupError).Inc()
+		return nil, &Error{Kind: NotFound, Host: host, Err: err}
+	}
+	if err != nil {
+		metrics.RepoLookups.WithLabelValues(host, metrics.RepoLookupError).Inc()
+		return nil, v.unreachable(host, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		metrics.RepoLookups.WithLabelValues(host, metrics.RepoLookupNotModified).Inc()
+		e := *cached
+		e.expires = time.Now().Add(v.ttl)
+		v.put(key, &e)
+		return &e, nil
+	}
+
+	e := &entry{etag: resp.Header.Get("ETag")}
+	// a missing file isn't an error
+	if !file || resp.StatusCode != http.StatusNotFound {
+		e.err = lookupError(resp, host, user)
+	}
+
+	if le, ok := e.err.(*Error); ok && (le.Kind == RateLimited || le.Kind == Unreachable) {
+		// try again next time
+		metrics.RepoLookups.WithLabelValues(host, metrics.RepoLookupError).Inc()
+		if le.Kind == Unreachable {
+			return nil, v.unreachable(host, le.Err)
+		}
+		return nil, le
+	}
+
+	if file && e.err == nil && resp.StatusCode == http.StatusOK {
+		if e.body, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxFileSize)); err != nil {
+			metrics.RepoLookups.WithLabelValues(host, metrics.RepoLookupError).Inc()
+			return nil, v.unreachable(host, err)
+		}
+	}
+
+	metrics.RepoLookups.WithLabelValues(host, metrics.RepoLookupFetched).Inc()
+
+	ttl := v.ttl
+	if e.err != nil || (file && e.body == nil) {
+		ttl = negativeTTL
+	}
+	e.expires = time.Now().Add(ttl)
+	v.put(key, e)
+
+	return e, nil
+}
+
+// unreachable logs why the host is unreachable, which isn't told to the
+// user as it may be about the network of the server
+func (v *Validator) unreachable(host string, err error) error {
+	v.logger.WithError(err).WithField("host", host).Info("error: fail to reach Git host")
+	return &Error{Kind: Unreachable, Host: host, Err: err}
+}
+
+// ResolveRef returns the candidate of the ref of the repository that is a
+// branch, the longest first, or the shortest if none is found
+func (v *Validator) ResolveRef(resolver model.RepoResolver, repo *model.Repo, token string) (string, error) {
+	if len(repo.RefCandidates) < 2 {
+		return repo.Ref, nil
+	}
+
+	token, user := v.token(repo, token)
+
+	last := len(repo.RefCandidates) - 1
+	for _, ref := range repo.RefCandidates[:last] {
+		req, err := resolver.BranchRequest(repo, ref, token)
+		if err != nil {
+			return "", err
+		}
+		if req == nil {
+			return "", fmt.Errorf("error: %s has no API to look up branches", repo.Host)
+		}
+
+		resp, err := v.client.Do(req)
+		if err != nil {
+			return "", v.unreachable(repo.Host, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if err := lookupError(resp, repo.Host, user); err != nil {
+			return "", err
+		}
+
+		return ref, nil
+	}
+
+	return repo.RefCandidates[last], nil
+}
+
+// lookupError classifies the response. A host hides a private repository
+// unless the user has access to it with their own token.
+func lookupError(resp *http.Response, host string, user bool) error {
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode == http.StatusForbidden && resp.Header.Get("X-RateLimit-Remaining") == "0":
+		return &Error{Kind: RateLimited, Host: host, Reset: rateLimitReset(resp)}
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		return &Error{Kind: Private, Host: host}
+	case resp.StatusCode == http.StatusNotFound:
+		// the service token isn't the user, who may have access to it
+		if !user {
+			return &Error{Kind: Private, Host: host}
+		}
+		return &Error{Kind: NotFound, Host: host}
+	default:
+		return &Error{Kind: Unreachable, Host: host, Err: fmt.Errorf("unexpected status %d", resp.StatusCode)}
+	}
+}
+
+// rateLimitReset reads the reset time of GitHub and GitLab, or Retry-After
+func rateLimitReset(resp *http.Response) time.Time {
+	for _, h := range []string{"X-RateLimit-Reset", "RateLimit-Reset"} {
+		if sec, err := strconv.ParseInt(resp.Header.Get(h), 10, 64); err == nil {
+			return time.Unix(sec, 0)
+		}
+	}
+
+	if sec, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		return time.Now().Add(time.Duration(sec) * time.Second)
+	}
+
+	return time.Time{}
+}
+
+func (v *Validator) get(key string) *entry {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.cache[key]
+}
+
+func (v *Validator) put(key string, e *entry) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if _, ok := v.cache[key]; !ok && len(v.cache) >= maxEntries {
+		v.evict()
+	}
+
+	v.cache[key] = e
+}
+
+// evict drops expired entries, or an arbitrary one if none has expired
+func (v *Validator) evict() {
+	now := time.Now()
+	for k, e := range v.cache {
+		if now.After(e.expires) {
+			delete(v.cache, k)
+		}
+	}
+
+	if len(v.cache) < maxEntries {
+		return
+	}
+
+	for k := range v.cache {
+		delete(v.cache, k)
+		return
+	}
+}
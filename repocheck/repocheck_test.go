@@ -0,0 +1,287 @@
+package repocheck
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+)
+
+func TestPrivateAddress(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &model.Repo{URL: srv.URL + "/owner/repo", Host: u.Hostname(), Path: "owner/repo"}
+
+	v := New(time.Minute, nil)
+	err = v.Validate(model.NewGitResolver(), repo, "")
+	if e, ok := err.(*Error); !ok || e.Kind != NotFound {
+		t.Fatalf("want not found for a private address, got %v", err)
+	}
+
+	// trusted hosts are looked up, the test server isn't trusted by TLS
+	v = New(time.Minute, nil, u.Hostname())
+	v.client.Transport.(*http.Transport).TLSClientConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig
+	if err := v.Validate(model.NewGitResolver(), repo, ""); err != nil {
+		t.Fatalf("want trusted host to be looked up, got %v", err)
+	}
+}
+
+func TestIsPrivate(t *testing.T) {
+	cases := map[string]bool{
+		"127.0.0.1":       true,
+		"10.1.2.3":        true,
+		"172.31.255.255":  true,
+		"192.168.1.1":     true,
+		"169.254.169.254": true,
+		"100.64.0.1":      true,
+		"0.0.0.0":         true,
+		"::1":             true,
+		"fd00::1":         true,
+		"fe80::1":         true,
+		"::ffff:10.0.0.1": true,
+		"140.82.112.3":    false,
+		"172.32.0.1":      false,
+		"2606:4700::1":    false,
+	}
+
+	for s, want := range cases {
+		if got := isPrivate(net.ParseIP(s)); got != want {
+			t.Errorf("%s: want private %t, got %t", s, want, got)
+		}
+	}
+}
+
+// testResolver sends the requests to a test server
+type testResolver struct {
+	url string
+}
+
+func (r *testResolver) Normalize(s string) (*model.Repo, error) {
+	return nil, model.ErrUnsupportedRepo
+}
+
+func (r *testResolver) LookupRequest(repo *model.Repo, token string) (*http.Request, error) {
+	return r.request("/repos/"+repo.Path, token)
+}
+
+func (r *testResolver) FileRequest(repo *model.Repo, path, token string) (*http.Request, error) {
+	return r.request("/repos/"+repo.Path+"/contents/"+path, token)
+}
+
+func (r *testResolver) BranchRequest(repo *model.Repo, branch, token string) (*http.Request, error) {
+	return r.request("/repos/"+repo.Path+"/branches/"+branch, token)
+}
+
+func (r *testResolver) request(path, token string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, r.url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "token "+token)
+	}
+
+	return req, nil
+}
+
+// newTestValidator returns a validator trusting the test server
+func newTestValidator(t *testing.T, h http.HandlerFunc, serviceTokens map[string]string) (*Validator, *testResolver) {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return New(time.Minute, serviceTokens, u.Hostname()), &testResolver{url: srv.URL}
+}
+
+func TestResolveRef(t *testing.T) {
+	branches := map[string]bool{"feature/x": true, "main": true}
+	v, resolver := newTestValidator(t, func(w http.ResponseWriter, r *http.Request) {
+		branch := strings.TrimPrefix(r.URL.Path, "/repos/owner/repo/branches/")
+		if branch == "rate/limited" {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if !branches[branch] {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}, nil)
+
+	cases := []struct {
+		candidates []string
+		ref        string
+		err        bool
+	}{
+		{[]string{"feature/x/src", "feature/x", "feature"}, "feature/x", false},
+		{[]string{"main/src/pkg", "main/src", "main"}, "main", false},
+		// the shortest is taken unless the host knows of a longer one
+		{[]string{"missing/src", "missing"}, "missing", false},
+		{[]string{"rate/limited", "rate"}, "", true},
+	}
+
+	for _, c := range cases {
+		repo := &model.Repo{Host: "example.com", Path: "owner/repo", Ref: c.candidates[len(c.candidates)-1], RefCandidates: c.candidates}
+		ref, err := v.ResolveRef(resolver, repo, "")
+		if c.err != (err != nil) {
+			t.Errorf("%v: want error %t, got %v", c.candidates, c.err, err)
+		}
+		if ref != c.ref {
+			t.Errorf("%v: want ref %q, got %q", c.candidates, c.ref, ref)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	// owner/private is only visible to the user
+	v, resolver := newTestValidator(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/owner/limited":
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", "4102444800")
+			w.WriteHeader(http.StatusForbidden)
+		case r.URL.Path == "/repos/owner/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.URL.Path == "/repos/owner/public",
+			r.URL.Path == "/repos/owner/private" && r.Header.Get("Authorization") == "token user-token":
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}, map[string]string{"service.example.com": "service-token"})
+
+	cases := []struct {
+		name  string
+		host  string
+		path  string
+		token string
+		kind  ErrorKind
+		ok    bool
+	}{
+		{name: "public", path: "owner/public", ok: true},
+		{name: "private with the token of the user", path: "owner/private", token: "user-token", ok: true},
+		{name: "private without a token", path: "owner/private", kind: Private},
+		{name: "private with the service token", host: "service.example.com", path: "owner/private", kind: Private},
+		{name: "missing with the token of the user", path: "owner/missing", token: "user-token", kind: NotFound},
+		{name: "rate limited", path: "owner/limited", kind: RateLimited},
+		{name: "broken host", path: "owner/broken", kind: Unreachable},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			host := c.host
+			if host == "" {
+				host = "example.com"
+			}
+
+			err := v.Validate(resolver, &model.Repo{Host: host, Path: c.path}, c.token)
+			if c.ok {
+				if err != nil {
+					t.Fatalf("want no error, got %v", err)
+				}
+				return
+			}
+
+			e, ok := err.(*Error)
+			if !ok || e.Kind != c.kind {
+				t.Fatalf("want error kind %d, got %v", c.kind, err)
+			}
+		})
+	}
+}
+
+func TestUnreachableError(t *testing.T) {
+	e := &Error{Kind: Unreachable, Host: "git.example.com", Err: errors.New("dial tcp 10.0.0.1:443: connection refused")}
+	if strings.Contains(e.Error(), "10.0.0.1") {
+		t.Errorf("want details of the network left out, got %s", e.Error())
+	}
+}
+
+func TestCache(t *testing.T) {
+	var requests, fetched int
+	v, resolver := newTestValidator(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		etag := `"` + r.URL.Path + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		fetched++
+		w.Header().Set("ETag", etag)
+		switch r.URL.Path {
+		case "/repos/owner/repo", "/repos/owner/repo/contents/.codeface.yml":
+			w.Write([]byte("dyno: standard-1x"))
+		case "/repos/owner/limited":
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}, nil)
+	repo := &model.Repo{Host: "example.com", Path: "owner/repo"}
+
+	for i := 0; i < 2; i++ {
+		if err := v.Validate(resolver, repo, ""); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := v.File(resolver, repo, ".codeface.yml", "")
+		if err != nil || string(b) != "dyno: standard-1x" {
+			t.Fatalf("want the file, got %q, %v", b, err)
+		}
+
+		b, err = v.File(resolver, repo, "missing.json", "")
+		if err != nil || b != nil {
+			t.Fatalf("want no file, got %q, %v", b, err)
+		}
+	}
+	if requests != 3 {
+		t.Errorf("want the results cached, got %d requests", requests)
+	}
+
+	// expired results are revalidated with their ETag
+	for k, e := range v.cache {
+		e.expires = time.Now().Add(-time.Second)
+		v.cache[k] = e
+	}
+	b, err := v.File(resolver, repo, ".codeface.yml", "")
+	if err != nil || string(b) != "dyno: standard-1x" {
+		t.Fatalf("want the file, got %q, %v", b, err)
+	}
+	if requests != 4 || fetched != 3 {
+		t.Errorf("want the file revalidated, got %d requests and %d fetched", requests, fetched)
+	}
+
+	// results of a user are only for the user
+	if _, err := v.File(resolver, repo, ".codeface.yml", "user-token"); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 5 {
+		t.Errorf("want the file looked up with the token, got %d requests", requests)
+	}
+
+	// rate limits aren't cached
+	limited := &model.Repo{Host: "example.com", Path: "owner/limited"}
+	for i := 0; i < 2; i++ {
+		if err := v.Validate(resolver, limited, ""); err == nil {
+			t.Fatal("want rate limited")
+		}
+	}
+	if requests != 7 {
+		t.Errorf("want rate limits looked up again, got %d requests", requests)
+	}
+}
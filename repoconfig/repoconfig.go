@@ -0,0 +1,134 @@
+// Package repoconfig reads the .codeface.yml at the root of a repository,
+// which declares how to set up an editor for it:
+//
+//	setup:
+//	  - name: Install Go
+//	    run: curl -sL https://dl.google.com/go/go1.14.2.linux-amd64.tar.gz | tar -xz -C $HOME/.heroku/lib
+//	env:
+//	  GOFLAGS: -mod=vendor
+//	extensions:
+//	  - golang.go
+//	open: README.md
+//	dyno: standard-2x
+package repoconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"gopkg.in/yaml.v2"
+)
+
+const Path = ".codeface.yml"
+
+var (
+	envNameRegexp   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	extensionRegexp = regexp.MustCompile(`^[\w-]+\.[\w-]+$`)
+)
+
+type Config struct {
+	// Setup runs once after the repository is cloned
+	Setup      []Step            `yaml:"setup"`
+	Env        map[string]string `yaml:"env"`
+	Extensions []string          `yaml:"extensions"`
+	// Open is a file to open, relative to the repository
+	Open string `yaml:"open"`
+	// Dyno is the dyno size the repository requires
+	Dyno string `yaml:"dyno"`
+}
+
+type Step struct {
+	Name string `yaml:"name" json:"name,omitempty"`
+	Run  string `yaml:"run" json:"run"`
+}
+
+// ValidationError lists all problems of a .codeface.yml
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s is invalid: %s", Path, strings.Join(e.Errors, "; "))
+}
+
+// Parse parses and validates a .codeface.yml. It returns a *ValidationError
+// if the file is invalid.
+func Parse(b []byte) (*Config, error) {
+	var c Config
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return nil, &ValidationError{Errors: []string{err.Error()}}
+	}
+
+	var errs []string
+	for i, s := range c.Setup {
+		if strings.TrimSpace(s.Run) == "" {
+			errs = append(errs, fmt.Sprintf("setup step %d has nothing to run", i+1))
+		}
+	}
+
+	var names []string
+	for k := range c.Env {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		if !envNameRegexp.MatchString(k) {
+			errs = append(errs, fmt.Sprintf("env %q is not a valid name", k))
+		} else if model.ReservedVar(k) {
+			errs = append(errs, fmt.Sprintf("env %s is reserved by Codeface", k))
+		}
+	}
+
+	for _, e := range c.Extensions {
+		if !extensionRegexp.MatchString(e) {
+			errs = append(errs, fmt.Sprintf("extension %q is not a publisher.name identifier", e))
+		}
+	}
+
+	if c.Open != "" {
+		if p := path.Clean(c.Open); path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+			errs = append(errs, fmt.Sprintf("open %q is not a file in the repository", c.Open))
+		}
+	}
+
+	if c.Dyno != "" && editor.DynoSizeRank(c.Dyno) < 0 {
+		errs = append(errs, fmt.Sprintf("dyno %q is not one of %s", c.Dyno, strings.Join(editor.DynoSizes, ", ")))
+	}
+
+	if len(errs) > 0 {
+		return nil, &ValidationError{Errors: errs}
+	}
+
+	return &c, nil
+}
+
+// Vars returns the config vars of an editor for the configuration, except
+// the dyno size which is set on the formation
+func (c *Config) Vars() map[string]string {
+	vars := make(map[string]string)
+	for k, v := range c.Env {
+		vars[k] = v
+	}
+
+	if len(c.Setup) > 0 {
+		b, _ := json.Marshal(c.Setup)
+		vars[model.SetupVar] = string(b)
+	}
+
+	if len(c.Extensions) > 0 {
+		vars[model.ExtensionsVar] = strings.Join(c.Extensions, ";")
+	}
+
+	if c.Open != "" {
+		vars[model.OpenFileVar] = path.Clean(c.Open)
+	}
+
+	return vars
+}
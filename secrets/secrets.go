@@ -0,0 +1,230 @@
+// Package secrets keeps the secrets of users encrypted at rest. Secrets are
+// set as config vars of the editors a user claims, either for every
+// repository or for a single one.
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+)
+
+// allRepos is the scope of secrets for every repository
+const allRepos = "all"
+
+var nameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var ErrNotFound = errors.New("error: secret is not found")
+
+// record is stored for each secret with its value sealed
+type record struct {
+	Name      string    `json:"name"`
+	Repo      string    `json:"repo,omitempty"`
+	Value     []byte    `json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// key the record is stored at, which the value is sealed with
+	key string
+}
+
+// New returns a store of secrets encrypted with AES-256-GCM. The key is
+// 32 random bytes encoded in base64, e.g. head -c 32 /dev/urandom | base64.
+// The kv must be durable, secrets in memory would be lost on a restart
+// while users think they're set.
+func New(kv store.Store, key string) (*Store, error) {
+	if _, ok := kv.(*store.Memory); ok {
+		return nil, fmt.Errorf("error: secrets require a durable store, set STORAGE_ENDPOINT")
+	}
+
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("error: invalid secrets key: %w", err)
+	}
+	if len(b) != 32 {
+		return nil, fmt.Errorf("error: invalid secrets key: %d bytes, want 32", len(b))
+	}
+
+	block, err := aes.NewCipher(b)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{kv: kv, aead: aead}, nil
+}
+
+type Store struct {
+	kv   store.Store
+	aead cipher.AEAD
+}
+
+// ValidateName checks that a secret can be set as a config var of an editor
+func ValidateName(name string) error {
+	if !nameRegexp.MatchString(name) {
+		return fmt.Errorf("error: invalid secret name %q", name)
+	}
+	if model.ReservedVar(name) {
+		return fmt.Errorf("error: secret name %s is reserved", name)
+	}
+
+	return nil
+}
+
+// Put sets a secret of the user for repo, or for every repository if repo is empty
+func (s *Store) Put(ctx context.Context, user, name, repo, value string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+
+	key := secretKey(user, name, repo)
+
+	// the key is authenticated along with the value so that a sealed value
+	// can't be moved to another user or name
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(record{
+		Name:      name,
+		Repo:      repo,
+		Value:     s.aead.Seal(nonce, nonce, []byte(value), []byte(key)),
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.kv.Put(ctx, key, b)
+}
+
+// Delete removes a secret of the user for repo
+func (s *Store) Delete(ctx context.Context, user, name, repo string) error {
+	key := secretKey(user, name, repo)
+	if _, err := s.kv.Get(ctx, key); err == store.ErrNotFound {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	return s.kv.Delete(ctx, key)
+}
+
+// List returns the secrets of the user without their values
+func (s *Store) List(ctx context.Context, user string) ([]model.Secret, error) {
+	records, err := s.records(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := []model.Secret{}
+	for _, r := range records {
+		secrets = append(secrets, model.Secret{
+			Name:      r.Name,
+			Repo:      r.Repo,
+			UpdatedAt: r.UpdatedAt,
+		})
+	}
+
+	return secrets, nil
+}
+
+// Vars decrypts the secrets of the user that apply to repo. A secret for the
+// repository takes precedence over one for every repository.
+func (s *Store) Vars(ctx context.Context, user, repo string) (map[string]string, error) {
+	records, err := s.records(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := make(map[string]string)
+	for _, r := range records {
+		if r.Repo != "" && r.Repo != repo {
+			continue
+		}
+		if _, ok := vars[r.Name]; ok && r.Repo == "" {
+			continue
+		}
+
+		v, err := s.open(r.key, r.Value)
+		if err != nil {
+			return nil, fmt.Errorf("error: fail to decrypt secret %s: %w", r.Name, err)
+		}
+		vars[r.Name] = v
+	}
+
+	return vars, nil
+}
+
+func (s *Store) records(ctx context.Context, user string) ([]record, error) {
+	keys, err := s.kv.List(ctx, userPrefix(user))
+	if err != nil {
+		return nil, err
+	}
+
+	var records []record
+	for _, k := range keys {
+		b, err := s.kv.Get(ctx, k)
+		if err == store.ErrNotFound {
+			continue // deleted since listed
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		r := record{key: k}
+		if err := json.Unmarshal(b, &r); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+
+	return records, nil
+}
+
+func (s *Store) open(key string, sealed []byte) (string, error) {
+	n := s.aead.NonceSize()
+	if len(sealed) < n {
+		return "", fmt.Errorf("error: sealed value is too short")
+	}
+
+	b, err := s.aead.Open(nil, sealed[:n], sealed[n:], []byte(key))
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func userPrefix(user string) string {
+	return fmt.Sprintf("secrets/%s/", user)
+}
+
+// secretKey scopes a secret by a hash of the repository URL, which isn't safe
+// to use in a key as is
+func secretKey(user, name, repo string) string {
+	scope := allRepos
+	if repo != "" {
+		sum := sha256.Sum256([]byte(repo))
+		scope = hex.EncodeToString(sum[:8])
+	}
+
+	return fmt.Sprintf("%s%s/%s", userPrefix(user), scope, name)
+}
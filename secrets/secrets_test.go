@@ -0,0 +1,163 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jingweno/codeface/store"
+)
+
+var testKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+
+func newTestStore(t *testing.T) (*Store, store.Store) {
+	dir, err := ioutil.TempDir("", "codeface-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	kv := store.NewDir(dir)
+	s, err := New(kv, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, kv
+}
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		name string
+		kv   store.Store
+		key  string
+	}{
+		{"memory", store.NewMemory(), testKey},
+		{"not base64", store.NewDir(os.TempDir()), "not base64!"},
+		{"short key", store.NewDir(os.TempDir()), base64.StdEncoding.EncodeToString([]byte("short"))},
+	}
+
+	for _, c := range cases {
+		if _, err := New(c.kv, c.key); err == nil {
+			t.Errorf("%s: want error", c.name)
+		}
+	}
+}
+
+func TestVars(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t)
+
+	const (
+		repo  = "https://github.com/jingweno/codeface"
+		other = "https://github.com/jingweno/upterm"
+	)
+	put := func(user, name, repo, value string) {
+		if err := s.Put(ctx, user, name, repo, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("user1", "TOKEN", "", "all-token")
+	put("user1", "TOKEN", repo, "repo-token")
+	put("user1", "DB_URL", "", "postgres://db")
+	put("user1", "ONLY_OTHER", other, "other")
+	put("user2", "TOKEN", "", "user2-token")
+
+	cases := []struct {
+		user string
+		repo string
+		want map[string]string
+	}{
+		// a secret of the repository takes precedence
+		{"user1", repo, map[string]string{"TOKEN": "repo-token", "DB_URL": "postgres://db"}},
+		{"user1", other, map[string]string{"TOKEN": "all-token", "DB_URL": "postgres://db", "ONLY_OTHER": "other"}},
+		{"user2", repo, map[string]string{"TOKEN": "user2-token"}},
+		{"user3", repo, map[string]string{}},
+	}
+
+	for _, c := range cases {
+		got, err := s.Vars(ctx, c.user, c.repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s %s: want %v, got %v", c.user, c.repo, c.want, got)
+		}
+	}
+
+	list, err := s.List(ctx, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 4 {
+		t.Errorf("want 4 secrets, got %v", list)
+	}
+
+	if err := s.Delete(ctx, "user1", "TOKEN", repo); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "user1", "TOKEN", repo); err != ErrNotFound {
+		t.Errorf("want ErrNotFound, got %v", err)
+	}
+	if got, _ := s.Vars(ctx, "user1", repo); got["TOKEN"] != "all-token" {
+		t.Errorf("want the secret for every repository, got %v", got)
+	}
+}
+
+func TestSealed(t *testing.T) {
+	ctx := context.Background()
+	s, kv := newTestStore(t)
+
+	if err := s.Put(ctx, "user1", "TOKEN", "", "secret-value"); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := kv.Get(ctx, secretKey("user1", "TOKEN", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "secret-value") || strings.Contains(string(b), base64.StdEncoding.EncodeToString([]byte("secret-value"))) {
+		t.Fatalf("want the value encrypted, got %s", b)
+	}
+
+	// a sealed value moved to another user or name can't be opened
+	for _, key := range []string{secretKey("user2", "TOKEN", ""), secretKey("user1", "OTHER", "")} {
+		if err := kv.Put(ctx, key, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Vars(ctx, "user2", ""); err == nil {
+		t.Error("want error for a value moved to another user")
+	}
+
+	// nor with another key
+	other, err := New(kv, base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Vars(ctx, "user1", ""); err == nil {
+		t.Error("want error for another key")
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"API_TOKEN":             true,
+		"_x":                    true,
+		"1TOKEN":                false,
+		"API-TOKEN":             false,
+		"":                      false,
+		"PORT":                  false,
+		"PASSWORD":              false,
+		"CODEFACE_EDITOR_TOKEN": false,
+	} {
+		if err := ValidateName(name); (err == nil) != valid {
+			t.Errorf("%q: want valid %t, got %v", name, valid, err)
+		}
+	}
+}
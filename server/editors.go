@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/securecookie"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/token"
+)
+
+// config vars identifying an editor to the server
+const (
+	editorIDVar    = "CODEFACE_EDITOR_ID"
+	editorTokenVar = "CODEFACE_EDITOR_TOKEN"
+	serverURLVar   = "CODEFACE_SERVER_URL"
+)
+
+// gitRefVar is checked out by the editor after cloning GIT_REPO
+const gitRefVar = "GIT_REF"
+
+// accessTokenTTL is how long the owner has to follow the redirect to the editor
+const accessTokenTTL = time.Minute
+
+// editorRecord is kept for each claimed editor. The ID is independent of the
+// app so that it survives upgrades.
+type editorRecord struct {
+	ID    string `json:"id"`
+	App   string `json:"app"`
+	Owner string `json:"owner"`
+	// OwnerID is the Heroku account ID of the owner, whose settings the editor syncs
+	OwnerID   string    `json:"owner_id,omitempty"`
+	GitRepo   string    `json:"git_repo"`
+	Ref       string    `json:"ref,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// Token authenticates the editor to the server and signs the access
+	// tokens of the owner. It's random so that it outlives key rotations.
+	Token string `json:"token"`
+	// GitHubRepo is set when the editor gets tokens of the GitHub App for
+	// its repository, e.g. jingweno/codeface. GitHubPush is whether the
+	// owner could push to it when the editor was claimed.
+	GitHubRepo string `json:"github_repo,omitempty"`
+	GitHubPush bool   `json:"github_push,omitempty"`
+}
+
+func editorKey(id string) string {
+	return fmt.Sprintf("editors/%s/editor.json", id)
+}
+
+// appEditorKey maps an app to its editor, e.g. to authorize a user to
+// upgrade a managed editor
+func appEditorKey(app string) string {
+	return fmt.Sprintf("apps/%s/editor", app)
+}
+
+func (h *handlers) saveEditor(ctx context.Context, e *editorRecord) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if err := h.kv.Put(ctx, editorKey(e.ID), b); err != nil {
+		return err
+	}
+
+	if err := h.kv.Put(ctx, ownerEditorKey(e.Owner, e.ID), []byte(e.ID)); err != nil {
+		return err
+	}
+
+	return h.kv.Put(ctx, appEditorKey(e.App), []byte(e.ID))
+}
+
+func (h *handlers) loadEditor(ctx context.Context, id string) (*editorRecord, error) {
+	b, err := h.kv.Get(ctx, editorKey(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var e editorRecord
+	return &e, json.Unmarshal(b, &e)
+}
+
+// newEditorToken returns a random token for an editor
+func newEditorToken() string {
+	return hex.EncodeToString(securecookie.GenerateRandomKey(32))
+}
+
+// editorVars are the config vars an editor needs to talk back to the server
+func (h *handlers) editorVars(r *http.Request, id, token string) map[string]string {
+	return map[string]string{
+		editorIDVar:    id,
+		editorTokenVar: token,
+		serverURLVar:   h.externalURL(r),
+	}
+}
+
+func (h *handlers) externalURL(r *http.Request) string {
+	if h.serverURL != "" {
+		return strings.TrimRight(h.serverURL, "/")
+	}
+
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host
+}
+
+// EditorAuthMiddleware authenticates the requests from an editor by its token
+func (h *handlers) EditorAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		e, err := h.loadEditor(r.Context(), id)
+		if err != nil && err != store.ErrNotFound {
+			jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+		if err == store.ErrNotFound || e.Token == "" || !hmac.Equal([]byte(token), []byte(e.Token)) {
+			jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "Unauthorized"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// editorOfApp loads the editor of an app
+func (h *handlers) editorOfApp(ctx context.Context, app string) (*editorRecord, error) {
+	id, err := h.kv.Get(ctx, appEditorKey(app))
+	if err != nil {
+		return nil, err
+	}
+
+	return h.loadEditor(ctx, string(id))
+}
+
+// ownedEditor loads the editor of the request and checks that it's owned by the account
+func (h *handlers) ownedEditor(w http.ResponseWriter, r *http.Request) (*editorRecord, bool) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+
+	e, err := h.loadEditor(r.Context(), mux.Vars(r)["id"])
+	if err == store.ErrNotFound || (err == nil && e.Owner != acct.Email) {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "Editor is not found"})
+		return nil, false
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return nil, false
+	}
+
+	return e, true
+}
+
+// HandleOpenEditor sends the owner to the editor with a short-lived access token
+func (h *handlers) HandleOpenEditor(w http.ResponseWriter, r *http.Request) {
+	e, ok := h.ownedEditor(w, r)
+	if !ok {
+		return
+	}
+
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	tok, err := token.Sign([]byte(e.Token), token.Claims{
+		Editor:  e.ID,
+		Email:   acct.Email,
+		Purpose: token.PurposeAccess,
+	}, accessTokenTTL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	q := url.Values{"token": {tok}}
+	if next := r.URL.Query().Get("next"); next != "" {
+		q.Set("next", next)
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("https://%s.herokuapp.com/_codeface/auth?%s", e.App, q.Encode()), http.StatusTemporaryRedirect)
+}
+
+func openEditorURL(id string) string {
+	return fmt.Sprintf("/editors/%s/open", id)
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/jingweno/codeface/githubapp"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"golang.org/x/oauth2"
+)
+
+// gitCredentialsVar tells the editor to ask the server for tokens of the
+// GitHub App when git needs credentials. The OAuth token of the owner never
+// leaves the server.
+const gitCredentialsVar = "CODEFACE_GIT_CREDENTIALS"
+
+// gitTokenMargin is how long before it expires a token of the GitHub App is
+// replaced, so that a clone or push doesn't outlive it
+const gitTokenMargin = 10 * time.Minute
+
+// newCookieStore returns a store that encrypts the sessions, which hold the
+// OAuth tokens of the user, with a key derived from the session key
+func newCookieStore(sessionKey string) *sessions.CookieStore {
+	encKey := sha256.Sum256([]byte("codeface session encryption:" + sessionKey))
+	return sessions.NewCookieStore([]byte(sessionKey), encKey[:])
+}
+
+// HandleGitHub tells whether the user has connected a GitHub account
+func (h *handlers) HandleGitHub(w http.ResponseWriter, r *http.Request) {
+	jsonResp(w, http.StatusOK, model.GitHubStatus{
+		Enabled:   h.githubOAuthConf != nil,
+		Connected: h.githubToken(r) != "",
+	})
+}
+
+func (h *handlers) HandleGitHubLogin(w http.ResponseWriter, r *http.Request) {
+	if h.githubOAuthConf == nil {
+		http.Error(w, "GitHub is not configured", http.StatusNotFound)
+		return
+	}
+
+	session, err := h.store.Get(r, "session")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	state := base64.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+	session.AddFlash(state, "github-state")
+	if err := session.Save(r, w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	url := h.githubOAuthConf.AuthCodeURL(state)
+	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+}
+
+func (h *handlers) HandleGitHubCallback(w http.ResponseWriter, r *http.Request) {
+	if h.githubOAuthConf == nil {
+		http.Error(w, "GitHub is not configured", http.StatusNotFound)
+		return
+	}
+
+	session, err := h.store.Get(r, "session")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sessionState := session.Flashes("github-state")
+	if len(sessionState) != 1 {
+		http.Error(w, "tampered oauth state", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	if state := query.Get("state"); state != sessionState[0].(string) {
+		http.Error(w, "error validating state", http.StatusUnauthorized)
+		return
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		http.Error(w, "error receiving oauth code", http.StatusUnauthorized)
+		return
+	}
+
+	tok, err := h.githubOAuthConf.Exchange(r.Context(), code)
+	if err != nil {
+		http.Error(w, "error exchanging oauth code", http.StatusUnauthorized)
+		return
+	}
+
+	session.Values["github-token"] = tok
+	if err := session.Save(r, w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+}
+
+// githubToken returns the GitHub token of the user or an empty string if
+// they haven't connected a GitHub account
+func (h *handlers) githubToken(r *http.Request) string {
+	session, err := h.store.Get(r, "session")
+	if err != nil {
+		return ""
+	}
+
+	tok, ok := session.Values["github-token"].(*oauth2.Token)
+	if !ok || !tok.Valid() {
+		return ""
+	}
+
+	return tok.AccessToken
+}
+
+// githubAccess tells which GitHub repository an editor gets tokens of the
+// GitHub App for and whether they can push to it. It's only when the owner
+// has access to the repository with their own GitHub account, so that the
+// app can't be used to read repositories the owner can't.
+func (h *handlers) githubAccess(ctx context.Context, repo *model.Repo, githubToken string) (string, bool) {
+	if h.githubApp == nil || repo.Host != "github.com" || githubToken == "" {
+		return "", false
+	}
+
+	push, err := h.githubApp.CanPush(ctx, githubToken, repo.Path)
+	if err != nil {
+		// the editor can still clone a public repository
+		h.logger.WithError(err).WithField("repo", repo.Path).Info("error: fail to look up GitHub permissions")
+		return "", false
+	}
+
+	return repo.Path, push
+}
+
+// HandleGitCredential issues a token of the GitHub App for the repository of
+// the editor. It's called by the git credential helper of the editor.
+func (h *handlers) HandleGitCredential(w http.ResponseWriter, r *http.Request) {
+	e, err := h.loadEditor(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		if err == store.ErrNotFound {
+			jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "Editor is not found"})
+			return
+		}
+
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if h.githubApp == nil || e.GitHubRepo == "" {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "The editor has no Git credentials"})
+		return
+	}
+
+	tok, err := h.gitToken(r.Context(), e)
+	if err != nil {
+		h.logger.WithError(err).WithField("editor", e.ID).Info("error: fail to issue a GitHub token")
+		jsonResp(w, http.StatusBadGateway, model.ErrorResponse{Error: "Fail to issue a GitHub token"})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, model.GitCredential{
+		Username:  "x-access-token",
+		Password:  tok.Token,
+		ExpiresAt: tok.ExpiresAt,
+	})
+}
+
+// gitToken returns the cached token of the editor unless it's about to expire
+func (h *handlers) gitToken(ctx context.Context, e *editorRecord) (*githubapp.Token, error) {
+	h.gitTokensMu.Lock()
+	defer h.gitTokensMu.Unlock()
+
+	if tok, ok := h.gitTokens[e.ID]; ok && time.Until(tok.ExpiresAt) > gitTokenMargin {
+		return tok, nil
+	}
+
+	tok, err := h.githubApp.Token(ctx, e.GitHubRepo, e.GitHubPush)
+	if err != nil {
+		return nil, err
+	}
+
+	// drop the expired tokens of other editors
+	for id, t := range h.gitTokens {
+		if time.Now().After(t.ExpiresAt) {
+			delete(h.gitTokens, id)
+		}
+	}
+	h.gitTokens[e.ID] = tok
+
+	return tok, nil
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jingweno/codeface/lock"
+)
+
+const (
+	// lockTimeout is how long a request waits for a lock held by another request
+	lockTimeout = 10 * time.Second
+	lockRetry   = 100 * time.Millisecond
+)
+
+// memoryLock is a lock of the requests of this dyno, dropped once no request
+// holds or waits for it
+type memoryLock struct {
+	lock.Locker
+	refs int
+}
+
+// locker returns the lock of a name, shared by the server dynos through
+// Postgres, or by the requests of this dyno without a DATABASE_URL. release
+// is called once the lock is no longer used.
+func (h *handlers) locker(name string) (l lock.Locker, release func()) {
+	if h.db != nil {
+		return lock.NewPostgres(h.db, "codeface-server:"+name), func() {}
+	}
+
+	h.locksMu.Lock()
+	defer h.locksMu.Unlock()
+
+	ml, ok := h.locks[name]
+	if !ok {
+		ml = &memoryLock{Locker: lock.NewMemory()}
+		h.locks[name] = ml
+	}
+	ml.refs++
+
+	return ml, func() {
+		h.locksMu.Lock()
+		defer h.locksMu.Unlock()
+
+		if ml.refs--; ml.refs == 0 {
+			delete(h.locks, name)
+		}
+	}
+}
+
+// withLock runs fn while holding the lock of name
+func (h *handlers) withLock(ctx context.Context, name string, fn func() error) error {
+	ctx, cancel := context.WithTimeout(ctx, lockTimeout)
+	defer cancel()
+
+	l, release := h.locker(name)
+	defer release()
+
+	for {
+		ok, err := l.TryLock(ctx)
+		if err != nil {
+			return err
+		}
+		if ok {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("error: fail to lock %s: %w", name, ctx.Err())
+		case <-time.After(lockRetry):
+		}
+	}
+	defer func() {
+		if err := l.Unlock(context.Background()); err != nil {
+			h.logger.WithError(err).WithField("lock", name).Info("error: fail to unlock")
+		}
+	}()
+
+	return fn()
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestWithLock(t *testing.T) {
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	h := &handlers{locks: make(map[string]*memoryLock), logger: logger}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		holders int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := h.withLock(context.Background(), "claims:u1", func() error {
+				mu.Lock()
+				holders++
+				if holders > 1 {
+					t.Error("want one holder of the lock at a time")
+				}
+				mu.Unlock()
+
+				time.Sleep(time.Millisecond)
+
+				mu.Lock()
+				holders--
+				mu.Unlock()
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// locks of users who are done aren't kept
+	if len(h.locks) != 0 {
+		t.Errorf("want no locks left, got %d", len(h.locks))
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+)
+
+func profileKey(user string) string {
+	return fmt.Sprintf("users/%s/profile.json", user)
+}
+
+// loadProfile returns an empty profile for a user who hasn't saved one
+func (h *handlers) loadProfile(ctx context.Context, user string) (*model.Profile, error) {
+	var p model.Profile
+
+	b, err := h.kv.Get(ctx, profileKey(user))
+	if err == store.ErrNotFound {
+		return &p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, json.Unmarshal(b, &p)
+}
+
+func (h *handlers) HandleProfile(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+
+	p, err := h.loadProfile(r.Context(), acct.ID)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, p)
+}
+
+func (h *handlers) HandleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+
+	var p model.Profile
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if p.DotfilesRepo != "" {
+		repo, resolver, err := model.NormalizeRepo(h.resolvers, p.DotfilesRepo)
+		if err != nil {
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		var githubToken string
+		if repo.Host == "github.com" {
+			githubToken = h.githubToken(r)
+		}
+
+		if err := h.repos.Validate(resolver, repo, githubToken); err != nil {
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		p.DotfilesRepo = repo.URL
+	}
+
+	if p.DotfilesInstall != "" {
+		clean := path.Clean(p.DotfilesInstall)
+		if p.DotfilesRepo == "" || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: fmt.Sprintf("invalid dotfiles install script %s", p.DotfilesInstall)})
+			return
+		}
+
+		p.DotfilesInstall = clean
+	}
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := h.kv.Put(r.Context(), profileKey(acct.ID), b); err != nil {
+		h.logger.WithError(err).Info("error: fail to save profile")
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, p)
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/quota"
+	"github.com/jingweno/codeface/store"
+)
+
+// quotaResponse is the quota of a user
+type quotaResponse struct {
+	User   string
+	Limits quota.Limits
+	// Override tells whether the limits are set by an admin for the user
+	Override bool
+	Usage    quota.Usage
+}
+
+// quotaKey keeps the limits an admin sets for a user
+func quotaKey(email string) string {
+	return fmt.Sprintf("quotas/%s/limits.json", email)
+}
+
+// ownerEditorKey indexes the editors of a user for counting them
+func ownerEditorKey(email, id string) string {
+	return fmt.Sprintf("quotas/%s/editors/%s", email, id)
+}
+
+// limits returns the limits set by an admin for the user, or the limits of
+// their group
+func (h *handlers) limits(ctx context.Context, email string) (quota.Limits, bool, error) {
+	b, err := h.kv.Get(ctx, quotaKey(email))
+	if err == store.ErrNotFound {
+		return h.quotaGroups.For(email, h.quotaDefault), false, nil
+	}
+	if err != nil {
+		return quota.Limits{}, false, err
+	}
+
+	var l quota.Limits
+	return l, true, json.Unmarshal(b, &l)
+}
+
+// usage counts the editors of the user. Running and total editors are looked
+// up with token, which has access to the apps of the user, or the service
+// account, unless countApps is false.
+func (h *handlers) usage(ctx context.Context, email, token string, countApps bool) (quota.Usage, error) {
+	var u quota.Usage
+
+	prefix := ownerEditorKey(email, "")
+	keys, err := h.kv.List(ctx, prefix)
+	if err != nil {
+		return u, err
+	}
+
+	// the service account has access to editors that aren't transferred
+	if h.ownership != editor.OwnershipTransfer {
+		token = h.herokuAPIKey
+	}
+	client := h.heroku(token)
+	service := h.heroku(h.herokuAPIKey)
+
+	for _, k := range keys {
+		e, err := h.loadEditor(ctx, strings.TrimPrefix(k, prefix))
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return u, err
+		}
+
+		claimedToday := time.Since(e.CreatedAt) < 24*time.Hour
+		if claimedToday {
+			u.ClaimsToday++
+		}
+
+		if !countApps {
+			continue
+		}
+
+		f, err := client.FormationInfo(ctx, e.App, "web")
+		if err != nil && token != h.herokuAPIKey {
+			// e.g. the token of the user only has the identity scope. The
+			// service account can still read editors it didn't transfer.
+			if sf, serr := service.FormationInfo(ctx, e.App, "web"); serr == nil {
+				f, err = sf, nil
+			}
+		}
+		var herr hkclient.Error
+		if errors.As(err, &herr) && herr.StatusCode == http.StatusNotFound {
+			// the editor is deleted, and no longer needed after a day
+			if !claimedToday {
+				h.kv.Delete(ctx, k)
+			}
+			continue
+		}
+
+		u.Total++
+		if err != nil {
+			// a stopped editor counted as running would block the user for good
+			h.logger.WithError(err).WithField("app", e.App).Info("error: fail to look up editor, not counting it as running")
+		} else if f.Quantity > 0 {
+			u.Running++
+		}
+	}
+
+	return u, nil
+}
+
+// readsApps tells whether a Heroku OAuth token of the scopes can look up apps
+func readsApps(scopes []string) bool {
+	for _, s := range scopes {
+		switch s {
+		case "global", "read", "write", "read-protected", "write-protected":
+			return true
+		}
+	}
+
+	return false
+}
+
+// countsRunning tells whether the running editors of users can be counted.
+// Transferred editors can only be looked up with the token of the owner.
+func countsRunning(ownership string, scopes []string) bool {
+	return ownership != editor.OwnershipTransfer || readsApps(scopes)
+}
+
+// limitsRunning tells whether the default or a group limits running editors
+func limitsRunning(defaults quota.Limits, groups quota.Groups) bool {
+	if defaults.MaxRunning > 0 {
+		return true
+	}
+	for _, g := range groups {
+		if g.Limits.MaxRunning > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkQuota returns a *quota.Error if the user can't claim an editor of size
+func (h *handlers) checkQuota(ctx context.Context, acct *hkclient.Account, token, size string) error {
+	l, _, err := h.limits(ctx, acct.Email)
+	if err != nil {
+		return err
+	}
+	if l == (quota.Limits{}) {
+		return nil
+	}
+
+	u, err := h.usage(ctx, acct.Email, token, l.MaxRunning > 0 || l.MaxTotal > 0)
+	if err != nil {
+		return err
+	}
+
+	return l.Check(u, size)
+}
+
+// quotaStatus is 403 for a claim that is never allowed and 429 for one that
+// is allowed after the user frees up an editor or waits
+func quotaStatus(e *quota.Error) int {
+	if e.Code == quota.MaxDynoSize {
+		return http.StatusForbidden
+	}
+
+	return http.StatusTooManyRequests
+}
+
+// HandleQuota returns the limits and usage of a user, to admins or the user
+func (h *handlers) HandleQuota(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	user := mux.Vars(r)["user"]
+	if user != acct.Email && !h.isAdmin(acct) {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "Only admins can see the quota of other users"})
+		return
+	}
+
+	l, override, err := h.limits(r.Context(), user)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	// transferred editors of other users aren't accessible to the service
+	// account, and would be taken as deleted
+	token := r.Context().Value(tokenKey).(string)
+	countApps := user == acct.Email || h.ownership != editor.OwnershipTransfer
+
+	u, err := h.usage(r.Context(), user, token, countApps)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, quotaResponse{User: user, Limits: l, Override: override, Usage: u})
+}
+
+// HandleUpdateQuota overrides the limits of a user. It's only allowed for admins.
+func (h *handlers) HandleUpdateQuota(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	if !h.isAdmin(acct) {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "Only admins can change quotas"})
+		return
+	}
+
+	var l quota.Limits
+	if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if l.MaxRunning < 0 || l.MaxTotal < 0 || l.MaxClaimsPerDay < 0 ||
+		(l.MaxDynoSize != "" && editor.DynoSizeRank(l.MaxDynoSize) < 0) {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "Invalid limits"})
+		return
+	}
+	if l.MaxRunning > 0 && !h.countsRunning {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "Running editors can't be limited without the read scope in HEROKU_OAUTH_SCOPES"})
+		return
+	}
+
+	b, err := json.Marshal(l)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	user := mux.Vars(r)["user"]
+	if err := h.kv.Put(r.Context(), quotaKey(user), b); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	h.logger.WithField("admin", acct.Email).WithField("user", user).Info("Overriding quota")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleDeleteQuota removes the limits an admin set for a user, so that the
+// limits of their group apply
+func (h *handlers) HandleDeleteQuota(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	if !h.isAdmin(acct) {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "Only admins can change quotas"})
+		return
+	}
+
+	if err := h.kv.Delete(r.Context(), quotaKey(mux.Vars(r)["user"])); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
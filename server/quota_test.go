@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/quota"
+)
+
+func TestQuotaStatus(t *testing.T) {
+	cases := []struct {
+		code string
+		want int
+	}{
+		// a larger size is never allowed
+		{code: quota.MaxDynoSize, want: http.StatusForbidden},
+		{code: quota.MaxRunning, want: http.StatusTooManyRequests},
+		{code: quota.MaxTotal, want: http.StatusTooManyRequests},
+		{code: quota.MaxClaimsPerDay, want: http.StatusTooManyRequests},
+	}
+
+	for _, c := range cases {
+		if got := quotaStatus(&quota.Error{Code: c.code}); got != c.want {
+			t.Errorf("%s: want status %d, got %d", c.code, c.want, got)
+		}
+	}
+}
+
+func TestCountsRunning(t *testing.T) {
+	cases := []struct {
+		ownership string
+		scopes    []string
+		want      bool
+	}{
+		{ownership: editor.OwnershipTransfer, scopes: []string{"identity"}, want: false},
+		{ownership: editor.OwnershipTransfer, scopes: []string{"identity", "read"}, want: true},
+		{ownership: editor.OwnershipTransfer, scopes: []string{"global"}, want: true},
+		{ownership: editor.OwnershipTeam, scopes: []string{"identity"}, want: true},
+		{ownership: editor.OwnershipManaged, scopes: []string{"identity"}, want: true},
+	}
+
+	for _, c := range cases {
+		if got := countsRunning(c.ownership, c.scopes); got != c.want {
+			t.Errorf("%s %v: want %t, got %t", c.ownership, c.scopes, c.want, got)
+		}
+	}
+}
+
+func TestLimitsRunning(t *testing.T) {
+	groups := quota.Groups{{Pattern: "@example.com", Limits: quota.Limits{MaxTotal: 3}}}
+	if limitsRunning(quota.Limits{MaxTotal: 5}, groups) {
+		t.Error("want no running limit")
+	}
+	if !limitsRunning(quota.Limits{MaxRunning: 1}, groups) {
+		t.Error("want the default to limit running editors")
+	}
+
+	groups = append(groups, quota.Group{Pattern: "contractor", Limits: quota.Limits{MaxRunning: 1}})
+	if !limitsRunning(quota.Limits{}, groups) {
+		t.Error("want a group to limit running editors")
+	}
+}
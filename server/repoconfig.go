@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/jingweno/codeface/devcontainer"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/repoconfig"
+)
+
+// repoSetup is how the repository asks its editor to be set up
+type repoSetup struct {
+	Vars map[string]string
+	// Size is the dyno size, the size of the pool app if empty
+	Size string
+	// Warnings tell the user what isn't applied
+	Warnings []string
+}
+
+// repoSetup reads the devcontainer.json and .codeface.yml of the repository.
+// .codeface.yml takes precedence. Only an invalid .codeface.yml prevents
+// claiming an editor, with a *repoconfig.ValidationError.
+func (h *handlers) repoSetup(resolver model.RepoResolver, repo *model.Repo, token string) (*repoSetup, error) {
+	vars, warnings := h.devcontainer(resolver, repo, token)
+	setup := &repoSetup{
+		Vars:     make(map[string]string),
+		Warnings: warnings,
+	}
+	for k, v := range vars {
+		setup.Vars[k] = v
+	}
+
+	b, err := h.repos.File(resolver, repo, repoconfig.Path, token)
+	if err != nil {
+		setup.Warnings = append(setup.Warnings, fmt.Sprintf("%s can't be read: %s", repoconfig.Path, err))
+		return setup, nil
+	}
+	if b == nil {
+		return setup, nil
+	}
+
+	cfg, err := repoconfig.Parse(b)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range cfg.Vars() {
+		// extensions of both are installed
+		if k == model.ExtensionsVar && setup.Vars[k] != "" {
+			v = setup.Vars[k] + ";" + v
+		}
+		setup.Vars[k] = v
+	}
+	setup.Size = cfg.Dyno
+
+	// the repository doesn't get to choose how much an editor costs
+	if setup.Size != "" && editor.DynoSizeRank(setup.Size) > editor.DynoSizeRank(h.maxDynoSize) {
+		setup.Warnings = append(setup.Warnings, fmt.Sprintf("dyno %s is larger than the largest size of %s, the editor runs on %s", setup.Size, h.maxDynoSize, h.maxDynoSize))
+		setup.Size = h.maxDynoSize
+	}
+
+	return setup, nil
+}
+
+// devcontainer returns the config vars from the devcontainer.json of the
+// repository and warnings about what isn't applied. A broken devcontainer.json
+// doesn't prevent claiming an editor.
+func (h *handlers) devcontainer(resolver model.RepoResolver, repo *model.Repo, token string) (map[string]string, []string) {
+	for _, path := range devcontainer.Paths {
+		b, err := h.repos.File(resolver, repo, path, token)
+		if err != nil {
+			return nil, []string{fmt.Sprintf("%s can't be read: %s", path, err)}
+		}
+		if b == nil {
+			continue
+		}
+
+		res, err := devcontainer.Apply(b)
+		if err != nil {
+			return nil, []string{fmt.Sprintf("%s is invalid: %s", path, err)}
+		}
+
+		warnings := make([]string, len(res.Warnings))
+		for i, w := range res.Warnings {
+			warnings[i] = fmt.Sprintf("%s: %s", path, w)
+		}
+
+		return res.Vars, warnings
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/secrets"
+)
+
+// HandleListSecrets returns the secrets of the user without their values
+func (h *handlers) HandleListSecrets(w http.ResponseWriter, r *http.Request) {
+	if !h.checkSecrets(w) {
+		return
+	}
+
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	list, err := h.secrets.List(r.Context(), acct.ID)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, list)
+}
+
+func (h *handlers) HandlePutSecret(w http.ResponseWriter, r *http.Request) {
+	if !h.checkSecrets(w) {
+		return
+	}
+
+	var req model.SecretRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	repo, err := h.secretRepo(req.Repo)
+	if err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	if err := secrets.ValidateName(name); err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	if err := h.secrets.Put(r.Context(), acct.ID, name, repo, req.Value); err != nil {
+		h.logger.WithError(err).Info("error: fail to put secret")
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handlers) HandleDeleteSecret(w http.ResponseWriter, r *http.Request) {
+	if !h.checkSecrets(w) {
+		return
+	}
+
+	repo, err := h.secretRepo(r.URL.Query().Get("repo"))
+	if err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	err = h.secrets.Delete(r.Context(), acct.ID, mux.Vars(r)["name"], repo)
+	if err == secrets.ErrNotFound {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "Secret is not found"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// secretRepo normalizes the repository of a secret the same way as a claim
+// so that they match
+func (h *handlers) secretRepo(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+
+	repo, _, err := model.NormalizeRepo(h.resolvers, s)
+	if err != nil {
+		return "", err
+	}
+
+	return repo.URL, nil
+}
+
+func (h *handlers) checkSecrets(w http.ResponseWriter) bool {
+	if h.secrets == nil {
+		jsonResp(w, http.StatusServiceUnavailable, model.ErrorResponse{Error: "Secrets aren't configured"})
+		return false
+	}
+
+	return true
+}
@@ -2,22 +2,35 @@ package server
 
 import (
 	"context"
+	"database/sql"
 	"encoding/base64"
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
 	"golang.org/x/oauth2/heroku"
 
 	hkclient "github.com/heroku/heroku-go/v5"
 	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/githubapp"
+	"github.com/jingweno/codeface/health"
+	"github.com/jingweno/codeface/metrics"
 	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/quota"
+	"github.com/jingweno/codeface/repocheck"
+	"github.com/jingweno/codeface/repoconfig"
+	"github.com/jingweno/codeface/secrets"
+	"github.com/jingweno/codeface/store"
+	"github.com/rs/xid"
 	"github.com/shurcooL/httpgzip"
 	log "github.com/sirupsen/logrus"
 )
@@ -26,19 +39,77 @@ type contextKey int
 
 const (
 	accountKey contextKey = iota
+	tokenKey
 )
 
+// publicPaths are served without logging in
+var publicPaths = map[string]bool{
+	"/login":    true,
+	"/callback": true,
+	"/metrics":  true,
+	"/health":   true,
+	"/healthz":  true,
+	"/readyz":   true,
+}
+
 func init() {
 	// for cookie store
 	gob.Register(&oauth2.Token{})
 }
 
 type Config struct {
-	Port               string   `env:"PORT,required"`
-	HerokuAPIKey       string   `env:"HEROKU_API_KEY,required"`
-	HerokuClientID     string   `env:"HEROKU_CLIENT_ID,required"`
-	HerokuClientSecret string   `env:"HEROKU_CLIENT_SECRET,required"`
-	WhitelistUsers     []string `env:"WHITELIST_USERS"`
+	Port               string `env:"PORT,required"`
+	HerokuAPIKey       string `env:"HEROKU_API_KEY,required"`
+	HerokuClientID     string `env:"HEROKU_CLIENT_ID,required"`
+	HerokuClientSecret string `env:"HEROKU_CLIENT_SECRET,required"`
+	// editors are claimed from the pool of the Heroku Team, the same team
+	// as the worker
+	HerokuTeam string `env:"HEROKU_TEAM"`
+	// transfer, team or managed, see editor.ParseOwnership
+	EditorOwnership string   `env:"EDITOR_OWNERSHIP"`
+	WhitelistUsers  []string `env:"WHITELIST_USERS"`
+	// admins can upgrade all editors accessible to HEROKU_API_KEY
+	AdminUsers        []string `env:"ADMIN_USERS"`
+	HerokuOAuthScopes []string `env:"HEROKU_OAUTH_SCOPES,default=identity"`
+	// the max number of apps a user can own to claim a transferred editor, 0
+	// drops the check. Apps are only counted with the read scope in
+	// HEROKU_OAUTH_SCOPES, the identity scope can't list them.
+	RecipientAppLimit int    `env:"RECIPIENT_APP_LIMIT,default=100"`
+	TemplateDir       string `env:"TEMPLATE_DIR,default=./template"`
+	// the URL editors use to reach the server, defaults to the host of the request
+	ServerURL string `env:"SERVER_URL"`
+	// editor records, secrets, settings and workspaces are kept in the
+	// bucket, or all but workspaces in DATABASE_URL without it, or in memory
+	// without both
+	Storage store.S3Config
+	// private GitHub repositories are supported with a GitHub OAuth app
+	GitHubClientID     string `env:"GITHUB_CLIENT_ID"`
+	GitHubClientSecret string `env:"GITHUB_CLIENT_SECRET"`
+	// repositories on these hosts are looked up with the GitHub API
+	GitHubEnterpriseHosts []string `env:"GITHUB_ENTERPRISE_HOSTS"`
+	// repositories are only looked up on hosts with public addresses
+	// unless they're GitHub Enterprise hosts or in the list
+	PrivateGitHosts []string `env:"PRIVATE_GIT_HOSTS"`
+	// looks up github.com repositories for users who haven't connected a
+	// GitHub account, with a higher rate limit than anonymous lookups
+	GitHubServiceToken string        `env:"GITHUB_SERVICE_TOKEN"`
+	RepoCacheTTL       time.Duration `env:"REPO_CACHE_TTL,default=10m"`
+	// secrets of users are encrypted with the key, head -c 32 /dev/urandom | base64
+	SecretsKey string `env:"SECRETS_KEY"`
+	// the largest dyno size a .codeface.yml can ask for, larger sizes are
+	// scaled down to it
+	MaxDynoSize string `env:"MAX_DYNO_SIZE,default=standard-2x"`
+	// limits of each user, e.g. "running=2 total=5 daily=10 size=standard-1x",
+	// unless the user is in a group or an admin overrides them
+	QuotaDefault quota.Limits `env:"QUOTA_DEFAULT"`
+	// limits of users by email, e.g. "@example.com running=3; contractor running=1"
+	QuotaGroups quota.Groups `env:"QUOTA_GROUPS"`
+	// editors clone and push GitHub repositories with tokens of the app
+	GitHubApp githubapp.Config
+	// settings syncs and claims of a user are serialized across web dynos
+	// with Postgres advisory locks, or in memory of a single dyno without it.
+	// It also keeps the records of editors without STORAGE_ENDPOINT.
+	DatabaseURL string `env:"DATABASE_URL"`
 	// cat /dev/urandom | base64 | head -c 64
 	SessionKey string `env:"SESSION_KEY,required"`
 }
@@ -56,18 +127,130 @@ type Server struct {
 }
 
 func (s *Server) Serve() error {
+	var (
+		kv      store.Store
+		objects *store.S3
+		checks  []health.Check
+		db      *sql.DB
+		err     error
+	)
+	if s.cfg.DatabaseURL != "" {
+		if db, err = sql.Open("postgres", s.cfg.DatabaseURL); err != nil {
+			return err
+		}
+	} else {
+		s.logger.Warn("Requests of a user are serialized in memory, only run one web dyno or set DATABASE_URL")
+	}
+
+	// editors are only accessible through their records, which only
+	// survive a restart in the bucket or the database
+	switch {
+	case s.cfg.Storage.Endpoint != "":
+		s3, err := store.NewS3(s.cfg.Storage)
+		if err != nil {
+			return err
+		}
+
+		kv, objects = s3, s3
+		checks = append(checks, health.Cached(storageCheck(s3), health.CacheTTL))
+	case db != nil:
+		pg, err := store.NewPostgres(context.Background(), db)
+		if err != nil {
+			return err
+		}
+
+		kv = pg
+		s.logger.Warn("Workspaces aren't snapshotted, set STORAGE_ENDPOINT")
+	default:
+		kv = store.NewMemory()
+		s.logger.Warn("Records of editors are lost on restart and workspaces aren't snapshotted, set STORAGE_ENDPOINT or DATABASE_URL")
+	}
+
+	serviceTokens := map[string]string{}
+	if s.cfg.GitHubServiceToken != "" {
+		serviceTokens["github.com"] = s.cfg.GitHubServiceToken
+	}
+
+	ownership, err := editor.ParseOwnership(s.cfg.EditorOwnership, s.cfg.HerokuTeam)
+	if err != nil {
+		return err
+	}
+
+	// a running limit that is never enforced would be mistaken for one that is
+	running := countsRunning(ownership, s.cfg.HerokuOAuthScopes)
+	if !running && limitsRunning(s.cfg.QuotaDefault, s.cfg.QuotaGroups) {
+		return fmt.Errorf("error: running limits in QUOTA_DEFAULT or QUOTA_GROUPS can't be enforced on transferred editors, add the read scope to HEROKU_OAUTH_SCOPES")
+	}
+
+	// the apps of a user are listed with their login token
+	appLimit := s.cfg.RecipientAppLimit
+	if !readsApps(s.cfg.HerokuOAuthScopes) {
+		appLimit = 0
+	}
+
+	if editor.DynoSizeRank(s.cfg.MaxDynoSize) < 0 {
+		return fmt.Errorf("error: MAX_DYNO_SIZE %q is not one of %s", s.cfg.MaxDynoSize, strings.Join(editor.DynoSizes, ", "))
+	}
+
+	var secretStore *secrets.Store
+	if s.cfg.SecretsKey != "" {
+		ss, err := secrets.New(kv, s.cfg.SecretsKey)
+		if err != nil {
+			return err
+		}
+
+		secretStore = ss
+	}
+
 	h := handlers{
 		herokuAPIKey:   s.cfg.HerokuAPIKey,
+		herokuTeam:     s.cfg.HerokuTeam,
+		ownership:      ownership,
 		whitelistUsers: s.cfg.WhitelistUsers,
-		store:          sessions.NewCookieStore([]byte(s.cfg.SessionKey)),
+		adminUsers:     s.cfg.AdminUsers,
+		templateDir:    s.cfg.TemplateDir,
+		maxDynoSize:    s.cfg.MaxDynoSize,
+		serverURL:      s.cfg.ServerURL,
+		store:          newCookieStore(s.cfg.SessionKey),
+		kv:             kv,
+		objects:        objects,
+		resolvers:      model.DefaultRepoResolvers(s.cfg.GitHubEnterpriseHosts...),
+		repos:          repocheck.New(s.cfg.RepoCacheTTL, serviceTokens, append(s.cfg.GitHubEnterpriseHosts, s.cfg.PrivateGitHosts...)...),
+		secrets:        secretStore,
+		quotaDefault:   s.cfg.QuotaDefault,
+		quotaGroups:    s.cfg.QuotaGroups,
+		appLimit:       appLimit,
+		countsRunning:  running,
+		gitTokens:      make(map[string]*githubapp.Token),
+		db:             db,
+		locks:          make(map[string]*memoryLock),
 		oauthConf: &oauth2.Config{
 			ClientID:     s.cfg.HerokuClientID,
 			ClientSecret: s.cfg.HerokuClientSecret,
-			Scopes:       []string{"identity"},
+			Scopes:       s.cfg.HerokuOAuthScopes,
 			Endpoint:     heroku.Endpoint,
 		},
 		logger: s.logger,
 	}
+	if s.cfg.GitHubClientID != "" {
+		h.githubOAuthConf = &oauth2.Config{
+			ClientID:     s.cfg.GitHubClientID,
+			ClientSecret: s.cfg.GitHubClientSecret,
+			// repo is required to look up private repositories, the
+			// editors get tokens of the GitHub App instead
+			Scopes:   []string{"repo"},
+			Endpoint: github.Endpoint,
+		}
+	}
+
+	if s.cfg.GitHubApp.AppID != "" {
+		app, err := githubapp.New(s.cfg.GitHubApp)
+		if err != nil {
+			return err
+		}
+
+		h.githubApp = app
+	}
 
 	r := mux.NewRouter()
 
@@ -81,12 +264,45 @@ func (s *Server) Serve() error {
 	r.Path("/").Handler(http.FileServer(AssetFile())) // for index.html
 
 	r.Methods("POST").Path("/editor").HandlerFunc(h.HandleEditor)
+	r.Methods("POST").Path("/editors/upgrade").HandlerFunc(h.HandleUpgradeEditors)
+	r.Methods("POST").Path("/editors/{app}/upgrade").HandlerFunc(h.HandleUpgradeEditor)
+	r.Methods("GET").Path("/editors/{id}/open").HandlerFunc(h.HandleOpenEditor)
+	r.Methods("GET").Path("/editors/{id}/workspace").HandlerFunc(h.HandleWorkspaceStatus)
 	r.Methods("GET").Path("/login").HandlerFunc(h.HandleLogin)
 	r.Methods("GET").Path("/callback").HandlerFunc(h.HandleCallback)
-	r.Methods("GET").Path("/health").HandlerFunc(h.HandleHealth)
+	r.Methods("GET").Path("/github").HandlerFunc(h.HandleGitHub)
+	r.Methods("GET").Path("/github/login").HandlerFunc(h.HandleGitHubLogin)
+	r.Methods("GET").Path("/github/callback").HandlerFunc(h.HandleGitHubCallback)
+	r.Methods("GET").Path("/profile").HandlerFunc(h.HandleProfile)
+	r.Methods("PUT").Path("/profile").HandlerFunc(h.HandleUpdateProfile)
+	r.Methods("GET").Path("/secrets").HandlerFunc(h.HandleListSecrets)
+	r.Methods("PUT").Path("/secrets/{name}").HandlerFunc(h.HandlePutSecret)
+	r.Methods("DELETE").Path("/secrets/{name}").HandlerFunc(h.HandleDeleteSecret)
+	r.Methods("GET").Path("/quotas/{user}").HandlerFunc(h.HandleQuota)
+	r.Methods("PUT").Path("/quotas/{user}").HandlerFunc(h.HandleUpdateQuota)
+	r.Methods("DELETE").Path("/quotas/{user}").HandlerFunc(h.HandleDeleteQuota)
+	r.Methods("GET").Path("/metrics").Handler(metrics.Handler())
+	r.Methods("GET").Path("/healthz").Handler(health.LivenessHandler())
+	r.Methods("GET").Path("/health").Handler(health.LivenessHandler()) // kept for existing monitors
+	r.Methods("GET").Path("/readyz").Handler(health.ReadinessHandler(append([]health.Check{
+		health.Cached(health.HerokuCheck(h.heroku(h.herokuAPIKey)), health.CacheTTL),
+		health.Cached(health.PoolCheck(h.heroku(h.herokuAPIKey), h.herokuTeam), health.CacheTTL),
+	}, checks...)...))
+
+	// editors authenticate with their token instead of a session
+	api := r.PathPrefix("/api/editors/{id}").Subrouter()
+	api.Use(h.EditorAuthMiddleware)
+	api.Methods("POST").Path("/workspace/snapshot").HandlerFunc(h.HandleWorkspaceSnapshot)
+	api.Methods("POST").Path("/workspace/restore").HandlerFunc(h.HandleWorkspaceRestore)
+	api.Methods("PUT").Path("/workspace/status").HandlerFunc(h.HandleReportWorkspaceStatus)
+	api.Methods("GET").Path("/settings").HandlerFunc(h.HandleEditorSettings)
+	api.Methods("PUT").Path("/settings").HandlerFunc(h.HandleSyncEditorSettings)
+	api.Methods("POST").Path("/git/credentials").HandlerFunc(h.HandleGitCredential)
 
 	http.Handle("/", r)
 
+	go h.resumeUpgrades(context.Background())
+
 	s.logger.Infof("Starting server on %s", s.cfg.Port)
 
 	return http.ListenAndServe(":"+s.cfg.Port, nil)
@@ -94,10 +310,38 @@ func (s *Server) Serve() error {
 
 type handlers struct {
 	herokuAPIKey   string
+	herokuTeam     string
+	ownership      string
 	whitelistUsers []string
+	adminUsers     []string
+	templateDir    string
+	maxDynoSize    string
+	serverURL      string
 	store          sessions.Store
-	oauthConf      *oauth2.Config
-	logger         log.FieldLogger
+	kv             store.Store
+	objects        *store.S3
+	resolvers      []model.RepoResolver
+	repos          *repocheck.Validator
+	secrets        *secrets.Store
+	quotaDefault   quota.Limits
+	quotaGroups    quota.Groups
+	// see editor.Claimer.AppLimit
+	appLimit int
+	// see countsRunning
+	countsRunning bool
+	// serializes the upgrades run by the server
+	upgradeMu sync.Mutex
+	// serializes the requests of a user, see withLock
+	db              *sql.DB
+	locksMu         sync.Mutex
+	locks           map[string]*memoryLock
+	oauthConf       *oauth2.Config
+	githubOAuthConf *oauth2.Config
+	githubApp       *githubapp.App
+	// tokens of the GitHub App by editor until they're about to expire
+	gitTokensMu sync.Mutex
+	gitTokens   map[string]*githubapp.Token
+	logger      log.FieldLogger
 }
 
 func (h *handlers) HandleHome(w http.ResponseWriter, r *http.Request) {
@@ -111,46 +355,195 @@ func (h *handlers) HandleEditor(w http.ResponseWriter, r *http.Request) {
 	var opt model.EditorRequest
 	dec := json.NewDecoder(r.Body)
 	if err := dec.Decode(&opt); err != nil {
-		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{err.Error()})
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
 		return
 	}
 
 	fmt.Println(opt.GitRepo)
-	url, err := model.ParseGitHubRepoURL(opt.GitRepo)
+	repo, resolver, err := model.NormalizeRepo(h.resolvers, opt.GitRepo)
+	if err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	// the GitHub account of the user is only for github.com
+	var githubToken string
+	if repo.Host == "github.com" {
+		githubToken = h.githubToken(r)
+	}
+
+	if err := h.repos.Validate(resolver, repo, githubToken); err != nil {
+		status := http.StatusUnprocessableEntity
+		if e, ok := err.(*repocheck.Error); ok {
+			switch e.Kind {
+			case repocheck.Private:
+				if repo.Host == "github.com" && githubToken == "" && h.githubOAuthConf != nil {
+					err = fmt.Errorf("%s, connect your GitHub account to use a private repository", err)
+				}
+			case repocheck.RateLimited:
+				status = http.StatusTooManyRequests
+			case repocheck.Unreachable:
+				status = http.StatusBadGateway
+			}
+		}
+
+		jsonResp(w, status, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	var refWarning string
+	if opt.Ref != "" {
+		if err := model.ValidateRef(opt.Ref); err != nil {
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+		repo.Ref = opt.Ref
+	} else if len(repo.RefCandidates) > 1 {
+		// the branch of a tree or blob page may contain slashes
+		ref, err := h.repos.ResolveRef(resolver, repo, githubToken)
+		if err != nil {
+			h.logger.WithError(err).WithField("repo", repo.URL).Info("error: fail to resolve ref")
+			refWarning = fmt.Sprintf("The branch is taken to be %s, set the ref for a branch with slashes", repo.Ref)
+		} else {
+			repo.Ref = ref
+		}
+	}
+	ref := repo.Ref
+
+	// the configuration files are read at the ref
+	setup, err := h.repoSetup(resolver, repo, githubToken)
 	if err != nil {
-		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{err.Error()})
+		resp := model.ErrorResponse{Error: err.Error()}
+		if e, ok := err.(*repoconfig.ValidationError); ok {
+			resp.Errors = e.Errors
+		}
+
+		jsonResp(w, http.StatusUnprocessableEntity, resp)
 		return
 	}
+	if refWarning != "" {
+		setup.Warnings = append(setup.Warnings, refWarning)
+	}
+
+	// secrets of the user override the repository, and vars of Codeface
+	// take precedence over both
+	vars := setup.Vars
+	if h.secrets != nil {
+		secretVars, err := h.secrets.Vars(r.Context(), acct.ID, repo.URL)
+		if err != nil {
+			h.logger.WithError(err).Info("error: fail to load secrets")
+			jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		for k, v := range secretVars {
+			vars[k] = v
+		}
+	}
+
+	id := xid.New().String()
+	editorToken := newEditorToken()
+	for k, v := range h.editorVars(r, id, editorToken) {
+		vars[k] = v
+	}
+	githubRepo, githubPush := h.githubAccess(r.Context(), repo, githubToken)
+	if githubRepo != "" {
+		vars[gitCredentialsVar] = "true"
+	}
+	if ref != "" {
+		vars[gitRefVar] = ref
+	}
+	if len(setup.Warnings) > 0 {
+		vars[model.WarningsVar] = strings.Join(setup.Warnings, "\n")
+	}
 
-	c := editor.NewClaimer(h.herokuAPIKey)
-	app, err := c.Claim(r.Context(), "", acct.Email, url)
+	// the editor is still usable without the dotfiles
+	profile, err := h.loadProfile(r.Context(), acct.ID)
 	if err != nil {
-		h.logger.WithError(err).Info("error: fail to claim an app")
-		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{err.Error()})
+		h.logger.WithError(err).Info("error: fail to load profile")
+		profile = &model.Profile{}
+	}
+
+	// claims of a user are serialized, so that parallel claims can't all
+	// pass the quota before any of them is recorded
+	claimed := false
+	err = h.withLock(r.Context(), "claims:"+acct.ID, func() error {
+		if err := h.checkQuota(r.Context(), acct, r.Context().Value(tokenKey).(string), setup.Size); err != nil {
+			if e, ok := err.(*quota.Error); ok {
+				jsonResp(w, quotaStatus(e), model.ErrorResponse{Error: e.Message, Code: e.Code})
+				return nil
+			}
+
+			h.logger.WithError(err).Info("error: fail to check quota")
+			jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+			return nil
+		}
+
+		c := editor.NewClaimer(h.herokuAPIKey, h.ownership, h.herokuTeam)
+		c.AppLimit = h.appLimit
+		app, err := c.Claim(r.Context(), "", acct.Email, editor.ClaimOptions{
+			GitRepo:         repo.URL,
+			Vars:            vars,
+			Size:            setup.Size,
+			DotfilesRepo:    profile.DotfilesRepo,
+			DotfilesInstall: profile.DotfilesInstall,
+			RecipientToken:  r.Context().Value(tokenKey).(string),
+		})
+		if err != nil {
+			h.logger.WithError(err).Info("error: fail to claim an app")
+			resp := model.ErrorResponse{Error: err.Error()}
+			if e, ok := err.(*editor.RecipientError); ok {
+				resp.Code = e.Code
+			}
+
+			jsonResp(w, http.StatusUnprocessableEntity, resp)
+			return nil
+		}
+
+		if err := h.saveEditor(r.Context(), &editorRecord{
+			ID:         id,
+			App:        app.Name,
+			Owner:      acct.Email,
+			OwnerID:    acct.ID,
+			GitRepo:    repo.URL,
+			Ref:        ref,
+			CreatedAt:  time.Now(),
+			Token:      editorToken,
+			GitHubRepo: githubRepo,
+			GitHubPush: githubPush,
+		}); err != nil {
+			// the owner can't be sent to the editor without a record
+			h.logger.WithError(err).WithField("app", app.Name).Info("error: fail to record editor")
+			jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+			return nil
+		}
+
+		claimed = true
+		return nil
+	})
+	if err != nil {
+		h.logger.WithError(err).Info("error: fail to lock claims")
+		jsonResp(w, http.StatusServiceUnavailable, model.ErrorResponse{Error: "Another editor is being claimed, please try again"})
+		return
+	}
+	if !claimed {
 		return
 	}
 
 	jsonResp(w, http.StatusCreated, model.EditorResponse{
-		URL: editor.EditorAppURL(app),
+		ID:       id,
+		URL:      openEditorURL(id),
+		Warnings: setup.Warnings,
 	})
 }
 
 func (h *handlers) heroku(token string) *hkclient.Service {
-	client := &http.Client{
-		Transport: &hkclient.Transport{
-			BearerToken: token,
-		},
-	}
-
-	return hkclient.NewService(client)
+	return editor.NewHerokuService(token)
 }
 
 func (h *handlers) HandleLogin(w http.ResponseWriter, r *http.Request) {
-	session, err := h.store.Get(r, "session")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	// a new session replaces a cookie that can't be decoded
+	session, _ := h.store.Get(r, "session")
 
 	state := base64.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
 	session.AddFlash(state, "state")
@@ -209,24 +602,39 @@ func (h *handlers) HandleCallback(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, redirect, http.StatusTemporaryRedirect)
 }
 
-func (h *handlers) HandleHealth(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprint(w, "hello owen")
+func storageCheck(s3 *store.S3) health.Check {
+	return health.Check{
+		Name: "storage",
+		Check: func(ctx context.Context) (interface{}, error) {
+			return nil, s3.CheckBucket(ctx)
+		},
+	}
 }
 
 func (h *handlers) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		path := r.URL.Path
-		if path == "/login" || path == "/callback" {
+		if publicPaths[r.URL.Path] || strings.HasPrefix(r.URL.Path, "/api/editors/") {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		session, err := h.store.Get(r, "session")
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		// the cf CLI authenticates with a Heroku API token instead of a session
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			accessToken := strings.TrimPrefix(auth, "Bearer ")
+			acct, err := editor.Account(r.Context(), h.heroku(accessToken))
+			if err != nil {
+				jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "Unauthorized"})
+				return
+			}
+
+			h.serveAccount(w, r, next, acct, accessToken)
 			return
 		}
 
+		// a cookie that can't be decoded, e.g. signed before sessions were
+		// encrypted, is replaced by a new session and the user logs in again
+		session, _ := h.store.Get(r, "session")
+
 		tok, ok := session.Values["token"].(*oauth2.Token)
 		// Redirect to login when no token in cookies or token expires
 		if !ok || !tok.Valid() {
@@ -255,22 +663,28 @@ func (h *handlers) AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		allowed := len(h.whitelistUsers) == 0
-		for _, u := range h.whitelistUsers {
-			if strings.Contains(acct.Email, u) {
-				allowed = true
-				break
-			}
-		}
+		h.serveAccount(w, r, next, acct, tok.AccessToken)
+	})
+}
 
-		if allowed {
-			ctx := context.WithValue(r.Context(), accountKey, acct)
-			r = r.WithContext(ctx)
-			next.ServeHTTP(w, r)
-		} else {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+// serveAccount serves the request for a whitelisted account
+func (h *handlers) serveAccount(w http.ResponseWriter, r *http.Request, next http.Handler, acct *hkclient.Account, accessToken string) {
+	allowed := len(h.whitelistUsers) == 0
+	for _, u := range h.whitelistUsers {
+		if strings.Contains(acct.Email, u) {
+			allowed = true
+			break
 		}
-	})
+	}
+
+	if allowed {
+		ctx := context.WithValue(r.Context(), accountKey, acct)
+		ctx = context.WithValue(ctx, tokenKey, accessToken)
+		r = r.WithContext(ctx)
+		next.ServeHTTP(w, r)
+	} else {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}
 }
 
 func jsonResp(w http.ResponseWriter, status int, i interface{}) {
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+)
+
+// maxSettingsSize is the max size of a settings file
+const maxSettingsSize = 1 << 20
+
+var settingsFiles = []string{
+	model.SettingsJSON,
+	model.KeybindingsJSON,
+	model.ExtensionsJSON,
+}
+
+func settingsKey(user, name string) string {
+	return fmt.Sprintf("users/%s/settings/%s", user, name)
+}
+
+// maxSettingsBackups is how many backups of a file are kept
+const maxSettingsBackups = 10
+
+func settingsBackupPrefix(user, name string) string {
+	return fmt.Sprintf("users/%s/settings/backups/%s/", user, name)
+}
+
+// settingsBackupKey keeps the older version of a file in a conflict. Keys
+// sort by the time of the conflict.
+func settingsBackupKey(user, name string, at time.Time) string {
+	return settingsBackupPrefix(user, name) + at.UTC().Format("20060102T150405.000000000Z")
+}
+
+// HandleEditorSettings returns the settings of the owner of the editor
+func (h *handlers) HandleEditorSettings(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.editorOwner(w, r)
+	if !ok {
+		return
+	}
+
+	files, err := h.loadSettings(r.Context(), owner)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, model.SettingsResponse{Files: files})
+}
+
+// HandleSyncEditorSettings saves the settings changed in an editor. A file
+// changed elsewhere since the editor got it is in conflict, and the newest
+// version is kept with the older one backed up. Syncs of the owner are
+// serialized across dynos, e.g. of editors stopping at the same time.
+func (h *handlers) HandleSyncEditorSettings(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.editorOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req model.SettingsRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4*maxSettingsSize)).Decode(&req); err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	for _, f := range req.Files {
+		if err := validateSettingsFile(f); err != nil {
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+
+	var (
+		conflicts []string
+		files     []model.SettingsFile
+	)
+	err := h.withLock(r.Context(), "settings:"+owner, func() error {
+		for _, f := range req.Files {
+			conflict, err := h.syncSettingsFile(r.Context(), owner, f)
+			if err != nil {
+				return fmt.Errorf("error: fail to sync %s: %w", f.Name, err)
+			}
+			if conflict {
+				conflicts = append(conflicts, f.Name)
+			}
+		}
+
+		var err error
+		files, err = h.loadSettings(r.Context(), owner)
+		return err
+	})
+	if err != nil {
+		h.logger.WithError(err).WithField("owner", owner).Info("error: fail to sync settings")
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, model.SettingsResponse{Files: files, Conflicts: conflicts})
+}
+
+// syncSettingsFile saves f and reports whether it's in conflict
+func (h *handlers) syncSettingsFile(ctx context.Context, owner string, f model.SettingsFile) (bool, error) {
+	stored, err := h.loadSettingsFile(ctx, owner, f.Name)
+	if err != nil && err != store.ErrNotFound {
+		return false, err
+	}
+
+	// the base is only meaningful to the editor
+	base := f.Base
+	f.Base = time.Time{}
+
+	if stored == nil || stored.UpdatedAt.Equal(base) {
+		return false, h.saveSettingsFile(ctx, settingsKey(owner, f.Name), f)
+	}
+	if stored.Content == f.Content {
+		return false, nil
+	}
+
+	newer, older := f, *stored
+	if stored.UpdatedAt.After(f.UpdatedAt) {
+		newer, older = *stored, f
+	}
+
+	if err := h.backupSettingsFile(ctx, owner, older); err != nil {
+		return true, err
+	}
+
+	return true, h.saveSettingsFile(ctx, settingsKey(owner, f.Name), newer)
+}
+
+// backupSettingsFile keeps a version of a file and drops the oldest backups
+func (h *handlers) backupSettingsFile(ctx context.Context, owner string, f model.SettingsFile) error {
+	if err := h.saveSettingsFile(ctx, settingsBackupKey(owner, f.Name, time.Now()), f); err != nil {
+		return err
+	}
+
+	keys, err := h.kv.List(ctx, settingsBackupPrefix(owner, f.Name))
+	if err != nil {
+		return err
+	}
+
+	for len(keys) > maxSettingsBackups {
+		if err := h.kv.Delete(ctx, keys[0]); err != nil {
+			return err
+		}
+		keys = keys[1:]
+	}
+
+	return nil
+}
+
+func (h *handlers) loadSettings(ctx context.Context, owner string) ([]model.SettingsFile, error) {
+	files := []model.SettingsFile{}
+	for _, name := range settingsFiles {
+		f, err := h.loadSettingsFile(ctx, owner, name)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, *f)
+	}
+
+	return files, nil
+}
+
+func (h *handlers) loadSettingsFile(ctx context.Context, owner, name string) (*model.SettingsFile, error) {
+	b, err := h.kv.Get(ctx, settingsKey(owner, name))
+	if err != nil {
+		return nil, err
+	}
+
+	var f model.SettingsFile
+	return &f, json.Unmarshal(b, &f)
+}
+
+func (h *handlers) saveSettingsFile(ctx context.Context, key string, f model.SettingsFile) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	return h.kv.Put(ctx, key, b)
+}
+
+// editorOwner returns the account ID of the owner of the editor of the request
+func (h *handlers) editorOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	e, err := h.loadEditor(r.Context(), mux.Vars(r)["id"])
+	if err == store.ErrNotFound || (err == nil && e.OwnerID == "") {
+		// editors claimed before settings sync aren't recorded with an owner ID
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "Editor owner is not found"})
+		return "", false
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return "", false
+	}
+
+	return e.OwnerID, true
+}
+
+func validateSettingsFile(f model.SettingsFile) error {
+	known := false
+	for _, name := range settingsFiles {
+		if f.Name == name {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return fmt.Errorf("unknown settings file %q", f.Name)
+	}
+
+	if len(f.Content) > maxSettingsSize {
+		return fmt.Errorf("settings file %s is larger than %d bytes", f.Name, maxSettingsSize)
+	}
+
+	if f.Name == model.ExtensionsJSON {
+		var exts []string
+		if err := json.Unmarshal([]byte(f.Content), &exts); err != nil {
+			return fmt.Errorf("invalid %s: %w", f.Name, err)
+		}
+	}
+
+	return nil
+}
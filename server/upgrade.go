@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+)
+
+// upgradeLease is how long a server has to upgrade an editor before another
+// one takes over, e.g. after a restart
+const upgradeLease = 30 * time.Minute
+
+// upgradeJob is kept until an editor is upgraded, so that the upgrade is
+// resumed if the server restarts
+type upgradeJob struct {
+	App         string    `json:"app"`
+	RequestedBy string    `json:"requested_by"`
+	LeasedUntil time.Time `json:"leased_until,omitempty"`
+}
+
+func upgradeKey(app string) string {
+	return fmt.Sprintf("upgrades/%s.json", app)
+}
+
+// HandleUpgradeEditor rebuilds an editor of the user from the current
+// template. The build outlives the request, so it responds once the upgrade is started.
+func (h *handlers) HandleUpgradeEditor(w http.ResponseWriter, r *http.Request) {
+	opt, ok := decodeUpgradeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// the service account doesn't have access to editors transferred to
+	// users, and upgrading them with the token of the user would require
+	// write access to their whole account
+	if h.ownership == editor.OwnershipTransfer {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{
+			Error: "The editor is owned by your Heroku account, upgrade it with cf upgrade --token $(heroku auth:token)",
+		})
+		return
+	}
+
+	if !h.ownedApp(w, r) {
+		return
+	}
+
+	d := editor.NewDeployer(h.herokuAPIKey, h.templateDir, h.herokuTeam)
+	app, err := d.CheckUpgrade(r.Context(), mux.Vars(r)["app"])
+	if err != nil {
+		status := http.StatusUnprocessableEntity
+		if err == editor.ErrUpToDate {
+			status = http.StatusConflict
+		}
+
+		jsonResp(w, status, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if !opt.Confirm {
+		jsonResp(w, http.StatusPreconditionRequired, model.ErrorResponse{Error: editor.UpgradeWarning})
+		return
+	}
+
+	if err := h.upgrade(r.Context(), r.Context().Value(accountKey).(*hkclient.Account), []hkclient.App{*app}); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusAccepted, model.UpgradeResponse{
+		Apps:    []string{app.Name},
+		Message: fmt.Sprintf("Upgrading %s, it restarts when the build finishes", app.Name),
+	})
+}
+
+// HandleUpgradeEditors rebuilds all outdated editors accessible to the
+// service account. It's only allowed for admins.
+func (h *handlers) HandleUpgradeEditors(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	if !h.isAdmin(acct) {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "Only admins can upgrade all editors"})
+		return
+	}
+
+	opt, ok := decodeUpgradeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	d := editor.NewDeployer(h.herokuAPIKey, h.templateDir, h.herokuTeam)
+	apps, err := d.OutdatedEditors(r.Context())
+	if err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	names := make([]string, 0, len(apps))
+	for _, app := range apps {
+		names = append(names, app.Name)
+	}
+
+	if !opt.Confirm {
+		jsonResp(w, http.StatusPreconditionRequired, model.UpgradeResponse{
+			Apps:    names,
+			Message: editor.UpgradeWarning,
+		})
+		return
+	}
+
+	if err := h.upgrade(r.Context(), acct, apps); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusAccepted, model.UpgradeResponse{
+		Apps:    names,
+		Message: fmt.Sprintf("Upgrading %d editor(s)", len(names)),
+	})
+}
+
+// upgrade queues the apps to be rebuilt in the background
+func (h *handlers) upgrade(ctx context.Context, acct *hkclient.Account, apps []hkclient.App) error {
+	for _, app := range apps {
+		b, err := json.Marshal(upgradeJob{App: app.Name, RequestedBy: acct.Email})
+		if err != nil {
+			return err
+		}
+
+		if err := h.kv.Put(ctx, upgradeKey(app.Name), b); err != nil {
+			return err
+		}
+	}
+
+	// use a new ctx since the request is done
+	go h.runUpgrades(context.Background())
+
+	return nil
+}
+
+// resumeUpgrades runs the queued upgrades that aren't leased, e.g. the ones
+// left by a server that restarted, until ctx is done
+func (h *handlers) resumeUpgrades(ctx context.Context) {
+	ticker := time.NewTicker(upgradeLease / 6)
+	defer ticker.Stop()
+
+	for {
+		h.runUpgrades(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runUpgrades rebuilds the queued apps one by one. A job is leased while its
+// app is built so that servers don't build an app at the same time.
+func (h *handlers) runUpgrades(ctx context.Context) {
+	h.upgradeMu.Lock()
+	defer h.upgradeMu.Unlock()
+
+	keys, err := h.kv.List(ctx, "upgrades/")
+	if err != nil {
+		h.logger.WithError(err).Info("error: fail to list upgrades")
+		return
+	}
+
+	d := editor.NewDeployer(h.herokuAPIKey, h.templateDir, h.herokuTeam)
+	for _, k := range keys {
+		b, err := h.kv.Get(ctx, k)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			h.logger.WithError(err).Info("error: fail to load upgrade")
+			continue
+		}
+
+		var job upgradeJob
+		if err := json.Unmarshal(b, &job); err != nil {
+			h.logger.WithError(err).WithField("key", k).Info("error: fail to decode upgrade")
+			h.kv.Delete(ctx, k)
+			continue
+		}
+		if time.Now().Before(job.LeasedUntil) {
+			continue
+		}
+
+		logger := h.logger.WithField("app", job.App).WithField("requested_by", job.RequestedBy)
+
+		job.LeasedUntil = time.Now().Add(upgradeLease)
+		if b, err = json.Marshal(job); err == nil {
+			err = h.kv.Put(ctx, k, b)
+		}
+		if err != nil {
+			logger.WithError(err).Info("error: fail to lease upgrade")
+			continue
+		}
+
+		buildCtx, cancel := context.WithTimeout(ctx, upgradeLease)
+		_, err = d.Upgrade(buildCtx, job.App)
+		cancel()
+
+		// an editor upgraded before a restart is up to date
+		if err != nil && err != editor.ErrUpToDate {
+			logger.WithError(err).Info("Fail to upgrade editor")
+		}
+
+		if err := h.kv.Delete(ctx, k); err != nil {
+			logger.WithError(err).Info("error: fail to remove upgrade")
+		}
+	}
+}
+
+// ownedApp checks that the app of the request is the editor of the account
+func (h *handlers) ownedApp(w http.ResponseWriter, r *http.Request) bool {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+
+	e, err := h.editorOfApp(r.Context(), mux.Vars(r)["app"])
+	if err == store.ErrNotFound || (err == nil && e.Owner != acct.Email) {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "Editor is not found"})
+		return false
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return false
+	}
+
+	return true
+}
+
+func (h *handlers) isAdmin(acct *hkclient.Account) bool {
+	for _, u := range h.adminUsers {
+		if acct.Email == u {
+			return true
+		}
+	}
+
+	return false
+}
+
+func decodeUpgradeRequest(w http.ResponseWriter, r *http.Request) (model.UpgradeRequest, bool) {
+	var opt model.UpgradeRequest
+	if r.ContentLength == 0 {
+		return opt, true
+	}
+
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&opt); err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return opt, false
+	}
+
+	return opt, true
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+)
+
+const workspaceURLExpiry = 15 * time.Minute
+
+func workspaceKey(id string) string {
+	return fmt.Sprintf("workspaces/%s.tar.gz", id)
+}
+
+func workspaceStatusKey(id string) string {
+	return fmt.Sprintf("editors/%s/workspace-status.json", id)
+}
+
+// HandleWorkspaceSnapshot returns a URL for the editor to upload a snapshot of its workspace
+func (h *handlers) HandleWorkspaceSnapshot(w http.ResponseWriter, r *http.Request) {
+	if !h.checkObjects(w) {
+		return
+	}
+
+	u, err := h.objects.PresignedPutURL(r.Context(), workspaceKey(mux.Vars(r)["id"]), workspaceURLExpiry)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, model.WorkspaceURLResponse{URL: u.String()})
+}
+
+// HandleWorkspaceRestore returns a URL for the editor to download the last
+// snapshot of its workspace. It responds with 404 if there is no snapshot.
+func (h *handlers) HandleWorkspaceRestore(w http.ResponseWriter, r *http.Request) {
+	if !h.checkObjects(w) {
+		return
+	}
+
+	key := workspaceKey(mux.Vars(r)["id"])
+	ok, err := h.objects.Exists(r.Context(), key)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if !ok {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "Workspace snapshot is not found"})
+		return
+	}
+
+	u, err := h.objects.PresignedGetURL(r.Context(), key, workspaceURLExpiry)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, model.WorkspaceURLResponse{URL: u.String()})
+}
+
+// HandleReportWorkspaceStatus records the outcome of the last snapshot or restore of an editor
+func (h *handlers) HandleReportWorkspaceStatus(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var status model.WorkspaceStatus
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&status); err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	logger := h.logger.WithFields(map[string]interface{}{"editor": id, "operation": status.Operation, "status": status.Status})
+	if status.Status == model.WorkspaceFailed {
+		logger.WithField("error", status.Error).Info("Editor fails to persist workspace")
+	} else {
+		logger.Info("Editor persists workspace")
+	}
+
+	b, err := json.Marshal(status)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := h.kv.Put(r.Context(), workspaceStatusKey(id), b); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleWorkspaceStatus shows the owner the outcome of the last snapshot or restore of an editor
+func (h *handlers) HandleWorkspaceStatus(w http.ResponseWriter, r *http.Request) {
+	e, ok := h.ownedEditor(w, r)
+	if !ok {
+		return
+	}
+
+	b, err := h.kv.Get(r.Context(), workspaceStatusKey(e.ID))
+	if err == store.ErrNotFound {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "Workspace has not been persisted yet"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	var status model.WorkspaceStatus
+	if err := json.Unmarshal(b, &status); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, status)
+}
+
+func (h *handlers) checkObjects(w http.ResponseWriter) bool {
+	if h.objects == nil {
+		jsonResp(w, http.StatusServiceUnavailable, model.ErrorResponse{Error: "Workspace persistence is not configured"})
+		return false
+	}
+
+	return true
+}
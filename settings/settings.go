@@ -0,0 +1,213 @@
+// Package settings syncs the VS Code user settings, keybindings and extension
+// list of the owner of an editor with the server.
+package settings
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// Config is set on an editor by the server when it's claimed
+type Config struct {
+	ServerURL   string `env:"CODEFACE_SERVER_URL,required"`
+	EditorID    string `env:"CODEFACE_EDITOR_ID,required"`
+	EditorToken string `env:"CODEFACE_EDITOR_TOKEN,required"`
+	// UserDir is the user data dir of code-server
+	UserDir string `env:"CODE_SERVER_USER_DIR,default=/home/dyno/.local/share/code-server/User"`
+}
+
+func New(cfg Config) *Settings {
+	return &Settings{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 30 * time.Second},
+		logger: log.New().WithField("com", "settings").WithField("editor", cfg.EditorID),
+		pulled: make(map[string]model.SettingsFile),
+	}
+}
+
+// Settings pulls the settings of the owner when the editor starts and pushes
+// what's changed when it stops
+type Settings struct {
+	cfg    Config
+	client *http.Client
+	logger log.FieldLogger
+
+	// pulled is the version of each file when the editor starts, to tell
+	// what's changed and which version a change is made on
+	pulled map[string]model.SettingsFile
+	// nothing is pushed unless it's pulled, otherwise the files of the
+	// image would override the settings of the owner
+	ready bool
+}
+
+// Pull writes the settings and keybindings of the owner into the user dir
+// and returns the extensions of the owner. The files of the image are kept
+// if the owner has none.
+func (s *Settings) Pull(ctx context.Context) ([]string, error) {
+	var resp model.SettingsResponse
+	if err := s.do(ctx, http.MethodGet, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	for _, name := range []string{model.SettingsJSON, model.KeybindingsJSON} {
+		b, err := ioutil.ReadFile(filepath.Join(s.cfg.UserDir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		s.pulled[name] = model.SettingsFile{Name: name, Content: string(b)}
+	}
+
+	var exts []string
+	for _, f := range resp.Files {
+		s.pulled[f.Name] = f
+
+		if f.Name == model.ExtensionsJSON {
+			if err := json.Unmarshal([]byte(f.Content), &exts); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if err := s.write(f); err != nil {
+			return nil, err
+		}
+	}
+	s.ready = true
+
+	return exts, nil
+}
+
+// Push sends the files changed since Pull along with the extensions of the
+// owner, and returns the files in conflict
+func (s *Settings) Push(ctx context.Context, exts []string) ([]string, error) {
+	if !s.ready {
+		return nil, nil
+	}
+
+	var req model.SettingsRequest
+
+	for _, name := range []string{model.SettingsJSON, model.KeybindingsJSON} {
+		path := filepath.Join(s.cfg.UserDir, name)
+		fi, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if f, ok := s.changed(name, string(b), fi.ModTime()); ok {
+			req.Files = append(req.Files, f)
+		}
+	}
+
+	if exts == nil {
+		exts = []string{}
+	}
+	sort.Strings(exts)
+	b, err := json.Marshal(exts)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := s.changed(model.ExtensionsJSON, string(b), time.Now()); ok {
+		req.Files = append(req.Files, f)
+	}
+
+	if len(req.Files) == 0 {
+		return nil, nil
+	}
+
+	var resp model.SettingsResponse
+	if err := s.do(ctx, http.MethodPut, req, &resp); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Conflicts) > 0 {
+		s.logger.WithField("files", resp.Conflicts).Info("Settings are changed in another editor, the older version is backed up")
+	}
+
+	return resp.Conflicts, nil
+}
+
+// changed returns the file to push if content differs from the pulled version
+func (s *Settings) changed(name, content string, updatedAt time.Time) (model.SettingsFile, bool) {
+	pulled, ok := s.pulled[name]
+	if content == pulled.Content || (!ok && content == "[]") {
+		return model.SettingsFile{}, false
+	}
+
+	return model.SettingsFile{
+		Name:      name,
+		Content:   content,
+		UpdatedAt: updatedAt,
+		Base:      pulled.UpdatedAt,
+	}, true
+}
+
+func (s *Settings) write(f model.SettingsFile) error {
+	if err := os.MkdirAll(s.cfg.UserDir, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.cfg.UserDir, f.Name)
+	if err := ioutil.WriteFile(path, []byte(f.Content), 0644); err != nil {
+		return err
+	}
+
+	// an unchanged file isn't newer than the version on the server
+	return os.Chtimes(path, f.UpdatedAt, f.UpdatedAt)
+}
+
+func (s *Settings) do(ctx context.Context, method string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = b
+	}
+
+	u := fmt.Sprintf("%s/api/editors/%s/settings", s.cfg.ServerURL, s.cfg.EditorID)
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+s.cfg.EditorToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e model.ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("error: unexpected response from server status=%d", resp.StatusCode)
+		}
+
+		return fmt.Errorf("error: %s", e.Error)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// NewDir returns a store that keeps each key as a file under dir
+func NewDir(dir string) *Dir {
+	return &Dir{dir: dir}
+}
+
+type Dir struct {
+	dir string
+}
+
+func (d *Dir) path(key string) string {
+	return filepath.Join(d.dir, filepath.FromSlash(filepath.Clean("/"+key)))
+}
+
+func (d *Dir) Get(ctx context.Context, key string) ([]byte, error) {
+	b, err := ioutil.ReadFile(d.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	return b, err
+}
+
+func (d *Dir) Put(ctx context.Context, key string, value []byte) error {
+	path := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// write to a temp file and rename so that readers never see a partial value
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(value); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+func (d *Dir) Delete(ctx context.Context, key string) error {
+	err := os.Remove(d.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (d *Dir) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.Walk(d.dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".tmp") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(d.dir, path)
+		if err != nil {
+			return err
+		}
+
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+
+		return nil
+	})
+	sort.Strings(keys)
+
+	return keys, err
+}
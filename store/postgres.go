@@ -0,0 +1,74 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	// register the postgres driver
+	_ "github.com/lib/pq"
+)
+
+const createTableSQL = `CREATE TABLE IF NOT EXISTS codeface_kv (
+	key text PRIMARY KEY,
+	value bytea NOT NULL,
+	updated_at timestamptz NOT NULL DEFAULT now()
+)`
+
+// NewPostgres returns a store backed by the codeface_kv table, which is
+// created if it does not exist
+func NewPostgres(ctx context.Context, db *sql.DB) (*Postgres, error) {
+	if _, err := db.ExecContext(ctx, createTableSQL); err != nil {
+		return nil, err
+	}
+
+	return &Postgres{db: db}, nil
+}
+
+type Postgres struct {
+	db *sql.DB
+}
+
+func (p *Postgres) Get(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := p.db.QueryRowContext(ctx, "SELECT value FROM codeface_kv WHERE key = $1", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+
+	return value, err
+}
+
+func (p *Postgres) Put(ctx context.Context, key string, value []byte) error {
+	_, err := p.db.ExecContext(ctx, `INSERT INTO codeface_kv (key, value) VALUES ($1, $2)
+ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = now()`, key, value)
+	return err
+}
+
+func (p *Postgres) Delete(ctx context.Context, key string) error {
+	_, err := p.db.ExecContext(ctx, "DELETE FROM codeface_kv WHERE key = $1", key)
+	return err
+}
+
+func (p *Postgres) List(ctx context.Context, prefix string) ([]string, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT key FROM codeface_kv WHERE key LIKE $1 ESCAPE '\' ORDER BY key`, escapeLike(prefix)+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type S3Config struct {
+	Endpoint  string `env:"STORAGE_ENDPOINT"`
+	Bucket    string `env:"STORAGE_BUCKET"`
+	AccessKey string `env:"STORAGE_ACCESS_KEY"`
+	SecretKey string `env:"STORAGE_SECRET_KEY"`
+	Region    string `env:"STORAGE_REGION"`
+	// Insecure uses http, e.g. for a local MinIO
+	Insecure bool `env:"STORAGE_INSECURE,default=false"`
+}
+
+// NewS3 returns a store backed by an S3 compatible bucket
+func NewS3(cfg S3Config) (*S3, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: !cfg.Insecure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3{
+		client: client,
+		bucket: cfg.Bucket,
+	}, nil
+}
+
+type S3 struct {
+	client *minio.Client
+	bucket string
+}
+
+func (s *S3) Get(ctx context.Context, key string) ([]byte, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s.error(err)
+	}
+	defer obj.Close()
+
+	b, err := ioutil.ReadAll(obj)
+	if err != nil {
+		return nil, s.error(err)
+	}
+
+	return b, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, value []byte) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(value), int64(len(value)), minio.PutObjectOptions{})
+	return err
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		keys = append(keys, obj.Key)
+	}
+
+	return keys, nil
+}
+
+// Exists reports whether the key exists without reading it
+func (s *S3) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err := s.error(err); err != nil {
+		if err == ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// PresignedGetURL returns a URL to download the key without credentials until it expires
+func (s *S3) PresignedGetURL(ctx context.Context, key string, expires time.Duration) (*url.URL, error) {
+	return s.client.PresignedGetObject(ctx, s.bucket, key, expires, nil)
+}
+
+// PresignedPutURL returns a URL to upload the key without credentials until it expires
+func (s *S3) PresignedPutURL(ctx context.Context, key string, expires time.Duration) (*url.URL, error) {
+	return s.client.PresignedPutObject(ctx, s.bucket, key, expires)
+}
+
+// CheckBucket checks that the bucket exists and is accessible
+func (s *S3) CheckBucket(ctx context.Context) error {
+	ok, err := s.client.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("error: bucket %s does not exist", s.bucket)
+	}
+
+	return nil
+}
+
+func (s *S3) error(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ErrNotFound
+	}
+
+	return err
+}
@@ -0,0 +1,78 @@
+// Package store provides key value stores for state shared between processes.
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var ErrNotFound = errors.New("error: key is not found")
+
+// Store is a key value store. Keys are slash separated paths.
+type Store interface {
+	// Get returns ErrNotFound if the key does not exist
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, value []byte) error
+	// Delete is a no-op if the key does not exist
+	Delete(ctx context.Context, key string) error
+	// List returns the keys with prefix in lexical order
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// NewMemory returns a store that lives as long as the process
+func NewMemory() *Memory {
+	return &Memory{
+		values: make(map[string][]byte),
+	}
+}
+
+type Memory struct {
+	mu     sync.RWMutex
+	values map[string][]byte
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	v, ok := m.values[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return append([]byte(nil), v...), nil
+}
+
+func (m *Memory) Put(ctx context.Context, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.values[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.values, key)
+	return nil
+}
+
+func (m *Memory) List(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var keys []string
+	for k := range m.values {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
@@ -0,0 +1,85 @@
+// Package token signs and verifies the tokens that grant access to an editor.
+// Tokens are signed with a key shared by the server and the editor.
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	// PurposeAccess is a short-lived token handed out by the server
+	PurposeAccess = "access"
+	// PurposeSession is kept by the editor in a cookie
+	PurposeSession = "session"
+)
+
+var (
+	ErrInvalid = errors.New("token is invalid")
+	ErrExpired = errors.New("token is expired")
+)
+
+type Claims struct {
+	Editor  string
+	Email   string
+	Purpose string
+	Expires int64
+}
+
+// Sign returns a token for the claims that expires after ttl
+func Sign(key []byte, c Claims, ttl time.Duration) (string, error) {
+	c.Expires = time.Now().Add(ttl).Unix()
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(b)
+
+	return payload + "." + sign(key, payload), nil
+}
+
+// Verify checks the signature and expiry of a token and that it's issued
+// for the editor and purpose
+func Verify(key []byte, s, editor, purpose string) (*Claims, error) {
+	split := strings.Split(s, ".")
+	if len(split) != 2 {
+		return nil, ErrInvalid
+	}
+
+	if !hmac.Equal([]byte(split[1]), []byte(sign(key, split[0]))) {
+		return nil, ErrInvalid
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(split[0])
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	var c Claims
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, ErrInvalid
+	}
+
+	if c.Editor != editor || c.Purpose != purpose {
+		return nil, ErrInvalid
+	}
+
+	if time.Now().Unix() > c.Expires {
+		return nil, ErrExpired
+	}
+
+	return &c, nil
+}
+
+func sign(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,89 @@
+package token
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	key := []byte("editor-token")
+	claims := Claims{Editor: "editor1", Email: "jane@example.com", Purpose: PurposeAccess}
+
+	valid, err := Sign(key, claims, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := Sign(key, claims, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a payload re-signed with another key or tampered with
+	otherKey, err := Sign([]byte("other-key"), claims, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := tamper(t, valid, func(c *Claims) { c.Email = "john@example.com" })
+	extended := tamper(t, expired, func(c *Claims) { c.Expires = time.Now().Add(time.Hour).Unix() })
+
+	cases := []struct {
+		name    string
+		token   string
+		editor  string
+		purpose string
+		err     error
+	}{
+		{"valid", valid, "editor1", PurposeAccess, nil},
+		{"expired", expired, "editor1", PurposeAccess, ErrExpired},
+		{"another editor", valid, "editor2", PurposeAccess, ErrInvalid},
+		{"another purpose", valid, "editor1", PurposeSession, ErrInvalid},
+		{"another key", otherKey, "editor1", PurposeAccess, ErrInvalid},
+		{"tampered claims", tampered, "editor1", PurposeAccess, ErrInvalid},
+		{"tampered expiry", extended, "editor1", PurposeAccess, ErrInvalid},
+		{"empty", "", "editor1", PurposeAccess, ErrInvalid},
+		{"no signature", strings.Split(valid, ".")[0], "editor1", PurposeAccess, ErrInvalid},
+		{"extra part", valid + ".x", "editor1", PurposeAccess, ErrInvalid},
+		{"signed garbage", "bm90IGpzb24." + sign(key, "bm90IGpzb24"), "editor1", PurposeAccess, ErrInvalid},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := Verify(key, c.token, c.editor, c.purpose)
+			if err != c.err {
+				t.Fatalf("want error %v, got %v", c.err, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if got.Editor != claims.Editor || got.Email != claims.Email || got.Purpose != claims.Purpose {
+				t.Errorf("want claims %+v, got %+v", claims, got)
+			}
+		})
+	}
+}
+
+// tamper changes the claims of a token without signing it again
+func tamper(t *testing.T, token string, fn func(c *Claims)) string {
+	split := strings.Split(token, ".")
+
+	b, err := base64.RawURLEncoding.DecodeString(split[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c Claims
+	if err := json.Unmarshal(b, &c); err != nil {
+		t.Fatal(err)
+	}
+	fn(&c)
+
+	if b, err = json.Marshal(c); err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b) + "." + split[1]
+}
@@ -2,14 +2,43 @@ import * as vscode from 'vscode';
 import * as path from 'path';
 import * as os from 'os';
 import * as fs from 'fs';
+import * as http from 'http';
+
+// The status API of the agent, only reachable from inside the editor
+const agentAddr = process.env.CODEFACE_AGENT_ADDR || '127.0.0.1:7070';
 
 export function activate(context: vscode.ExtensionContext) {
 	let disposable = vscode.commands.registerCommand('codeface.setup', async (gitUrl?: string, parentDir?: string) => {
 		vscode.commands.executeCommand("git.clone", gitUrl, parentDir);
-		// TODO: download Go
+		// toolchains are installed by the setup steps of .codeface.yml
 	});
 	context.subscriptions.push(disposable);
 
+	context.subscriptions.push(vscode.commands.registerCommand('codeface.runSetup', async () => {
+		let status = await agentStatus();
+		if (!status || status.Setup.State !== 'pending') {
+			vscode.window.showInformationMessage('No setup commands of the repository are waiting to run.');
+			return;
+		}
+		confirmSetup(status.Setup.Commands || []);
+	}));
+
+	// What of the repository configuration isn't applied
+	let warnings = process.env.CODEFACE_WARNINGS;
+	if (warnings) {
+		vscode.window.showWarningMessage('Not all of the repository configuration is applied: ' + warnings.split('\n').join('; '));
+	}
+
+	// The setup commands come from the repository, the owner confirms them
+	// once the repository is cloned
+	waitForPendingSetup(60);
+
+	// Open the file of .codeface.yml, the agent resolves it in the repository
+	let openFile = process.env.CODEFACE_OPEN_FILE;
+	if (openFile && path.isAbsolute(openFile) && fs.existsSync(openFile)) {
+		vscode.window.showTextDocument(vscode.Uri.file(openFile), { preview: false });
+	}
+
 	// Trigger a setup after activate
 	let gitUrl = process.env.GIT_REPO;
 	if (gitUrl) {
@@ -22,5 +51,74 @@ export function activate(context: vscode.ExtensionContext) {
 	}
 }
 
+interface AgentStatus {
+	Repo: { State: string }
+	Setup: { State: string, Commands?: string[] }
+}
+
+function agentStatus(): Promise<AgentStatus | undefined> {
+	return new Promise((resolve) => {
+		http.get(`http://${agentAddr}/status`, (res) => {
+			let body = '';
+			res.on('data', (chunk) => body += chunk);
+			res.on('end', () => {
+				try {
+					resolve(JSON.parse(body));
+				} catch (e) {
+					resolve(undefined);
+				}
+			});
+		}).on('error', () => resolve(undefined));
+	});
+}
+
+async function waitForPendingSetup(attempts: number) {
+	let cloned = false;
+	for (let i = 0; i < attempts; i++) {
+		let status = await agentStatus();
+		if (!status) {
+			return;
+		}
+
+		switch (status.Setup.State) {
+			case 'pending':
+				confirmSetup(status.Setup.Commands || []);
+				return;
+			case 'none':
+				// the setup is proposed right after the repository is cloned
+				if (cloned || status.Repo.State === 'failed') {
+					return;
+				}
+				cloned = status.Repo.State === 'cloned';
+				await new Promise((resolve) => setTimeout(resolve, 5000));
+				break;
+			default:
+				return;
+		}
+	}
+}
+
+async function confirmSetup(commands: string[]) {
+	let choice = await vscode.window.showWarningMessage(
+		'The repository asks to run setup commands. They run as you, with access to your editor, secrets and Git credentials.',
+		{ modal: true, detail: commands.join('\n') },
+		'Run',
+	);
+	if (choice !== 'Run') {
+		vscode.window.showInformationMessage('Run "Codeface: Run setup commands" to run them later.');
+		return;
+	}
+
+	let [host, port] = agentAddr.split(':');
+	let req = http.request({ host, port, path: '/setup', method: 'POST', headers: { 'Codeface-Confirm': 'true' } }, (res) => {
+		if (res.statusCode !== 202) {
+			vscode.window.showErrorMessage(`Fail to run the setup commands: status ${res.statusCode}`);
+		}
+		res.resume();
+	});
+	req.on('error', (err) => vscode.window.showErrorMessage(`Fail to run the setup commands: ${err.message}`));
+	req.end();
+}
+
 // this method is called when your extension is deactivated
 export function deactivate() { }
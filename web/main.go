@@ -17,7 +17,7 @@ import (
 )
 
 const (
-	bookmarkletTmpl = `javascript:(function(){window.location.href='%s?repo=' + window.location.href})();`
+	bookmarkletTmpl = `javascript:(function(){window.location.href='%s?repo=' + encodeURIComponent(window.location.href)})();`
 )
 
 func main() {
@@ -29,24 +29,26 @@ func main() {
 	vecty.SetTitle("Codeface")
 	vecty.AddStylesheet("/assets/style.css")
 	pv := &PageView{
-		GitHubRepoURL: u.Query().Get("repo"),
-		Bookmarklet:   fmt.Sprintf(bookmarkletTmpl, u.Scheme+"://"+u.Host),
+		RepoURL:     u.Query().Get("repo"),
+		Bookmarklet: fmt.Sprintf(bookmarkletTmpl, u.Scheme+"://"+u.Host),
 	}
 	// if repo exists from url param ?repo=...
-	if pv.GitHubRepoURL != "" {
-		go pv.claimEditor(pv.GitHubRepoURL)
+	if pv.RepoURL != "" {
+		go pv.claimEditor(pv.RepoURL)
 	}
+	go pv.loadGitHubStatus()
 
 	vecty.RenderBody(pv)
 }
 
 type PageView struct {
 	vecty.Core
-	GitHubRepoURL   string
+	RepoURL         string
 	Bookmarklet     string
 	ValidFeedback   string
 	InvalidFeedback string
 	IsWorking       bool
+	GitHub          model.GitHubStatus
 
 	input *vecty.HTML
 }
@@ -57,10 +59,10 @@ func (p *PageView) Render() vecty.ComponentOrHTML {
 			prop.Type(prop.TypeURL),
 			prop.ID("inputRepo"),
 			vecty.Class("form-control"),
-			prop.Placeholder("GitHub repository URL"),
+			prop.Placeholder("Git repository URL"),
 			prop.Autofocus(true),
 			vecty.Property("required", true),
-			prop.Value(p.GitHubRepoURL),
+			prop.Value(p.RepoURL),
 			event.Input(p.onInput),
 			vecty.MarkupIf(
 				p.ValidFeedback != "",
@@ -109,7 +111,18 @@ func (p *PageView) Render() vecty.ComponentOrHTML {
 							vecty.Class("form-text"),
 							vecty.Class("text-muted"),
 						),
-						vecty.Text("The GitHub repository URL must be a valid public repository URL, e.g., https://github.com/jingweno/upterm."),
+						vecty.Text("A repository URL on GitHub, GitLab, Bitbucket or any Git host, e.g., https://github.com/jingweno/upterm or git@example.com:team/repo.git."),
+						vecty.If(
+							p.GitHub.Enabled && !p.GitHub.Connected,
+							vecty.Text(" To use a private repository, "),
+							elem.Anchor(
+								vecty.Markup(
+									prop.Href("/github/login"),
+								),
+								vecty.Text("connect your GitHub account"),
+							),
+							vecty.Text("."),
+						),
 					),
 					vecty.If(
 						p.ValidFeedback != "",
@@ -133,7 +146,7 @@ func (p *PageView) Render() vecty.ComponentOrHTML {
 						vecty.Markup(
 							prop.For("inputRepo"),
 						),
-						vecty.Text("GitHub repository URL"),
+						vecty.Text("Git repository URL"),
 					),
 				),
 				elem.Button(
@@ -163,7 +176,7 @@ func (p *PageView) Render() vecty.ComponentOrHTML {
 						vecty.Class("text-muted"),
 						vecty.Class("text-center"),
 					),
-					vecty.Text("Drag the following bookmarklet to your bookmark bar, and press it to clone the Git repository to Codeface while you are navigating on github.com"),
+					vecty.Text("Drag the following bookmarklet to your bookmark bar, and press it to clone the Git repository to Codeface while you are browsing a repository on GitHub, GitLab or Bitbucket"),
 				),
 				elem.Paragraph(
 					vecty.Markup(
@@ -184,8 +197,8 @@ func (p *PageView) Render() vecty.ComponentOrHTML {
 }
 
 func (p *PageView) onInput(event *vecty.Event) {
-	p.GitHubRepoURL = event.Target.Get("value").String()
-	if p.GitHubRepoURL == "" {
+	p.RepoURL = event.Target.Get("value").String()
+	if p.RepoURL == "" {
 		p.resetFields()
 	}
 	vecty.Rerender(p)
@@ -200,19 +213,20 @@ func (p *PageView) resetFields() {
 func (p *PageView) onEnter(event *vecty.Event) {
 	p.resetFields()
 	vecty.Rerender(p)
-	go p.claimEditor(p.GitHubRepoURL)
+	go p.claimEditor(p.RepoURL)
 }
 
 func (p *PageView) claimEditor(repo string) {
 	p.IsWorking = true // mark as working
 	vecty.Rerender(p)
 
-	url, err := claimEditor(repo)
+	resp, err := claimEditor(repo)
 	if err == nil {
-		p.ValidFeedback = fmt.Sprintf("Please wait, redirecting to %s", url)
+		// the warnings are shown in the editor
+		p.ValidFeedback = fmt.Sprintf("Please wait, redirecting to %s", resp.URL)
 		p.IsWorking = true
 		vecty.Rerender(p)
-		redirectTo(url)
+		redirectTo(resp.URL)
 	} else {
 		p.InvalidFeedback = err.Error()
 		p.IsWorking = false
@@ -223,24 +237,40 @@ func (p *PageView) claimEditor(repo string) {
 	}
 }
 
-func claimEditor(url string) (string, error) {
-	u, err := model.ParseGitHubRepoURL(url)
+func (p *PageView) loadGitHubStatus() {
+	resp, err := http.Get("/github")
 	if err != nil {
-		return "", err
+		return
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	if err := dec.Decode(&p.GitHub); err != nil {
+		return
+	}
+
+	vecty.Rerender(p)
+}
+
+func claimEditor(url string) (*model.EditorResponse, error) {
+	// only fail fast on malformed URLs, the server knows more hosts, checks
+	// that the repository is accessible and resolves the ref of the URL
+	if _, _, err := model.NormalizeRepo(model.DefaultRepoResolvers(), url); err != nil {
+		return nil, err
 	}
 
 	req := model.EditorRequest{
-		GitRepo: u,
+		GitRepo: url,
 	}
 
 	b, err := json.Marshal(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	resp, err := http.Post("/editor", "application/json", bytes.NewBuffer(b))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
@@ -248,19 +278,19 @@ func claimEditor(url string) (string, error) {
 		var errResp model.ErrorResponse
 		dec := json.NewDecoder(resp.Body)
 		if err := dec.Decode(&errResp); err != nil {
-			return "", err
+			return nil, err
 		}
 
-		return "", fmt.Errorf(errResp.Error)
+		return nil, fmt.Errorf(errResp.Error)
 	}
 
 	var editorResp model.EditorResponse
 	dec := json.NewDecoder(resp.Body)
 	if err := dec.Decode(&editorResp); err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return editorResp.URL, nil
+	return &editorResp, nil
 }
 
 func redirectTo(url string) {
@@ -0,0 +1,47 @@
+package worker
+
+import (
+	"context"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	log "github.com/sirupsen/logrus"
+)
+
+// Backend provisions and removes the apps of the pool
+type Backend interface {
+	// Apps returns all Codeface apps regardless of their state
+	Apps(ctx context.Context) ([]heroku.App, error)
+	// Deploy builds an idle app from the current template
+	Deploy(ctx context.Context) (*heroku.App, error)
+	// FinishBuild marks a building app as idle if its build succeeded, see
+	// editor.BuildOutcome
+	FinishBuild(ctx context.Context, app *heroku.App) (*heroku.App, editor.BuildOutcome, error)
+	Delete(app *heroku.App) error
+}
+
+type herokuBackend struct {
+	heroku      *heroku.Service
+	apiKey      string
+	team        string
+	templateDir string
+	logger      log.FieldLogger
+}
+
+func (b *herokuBackend) Apps(ctx context.Context) ([]heroku.App, error) {
+	return editor.AllApps(ctx, b.heroku, b.team)
+}
+
+func (b *herokuBackend) Deploy(ctx context.Context) (*heroku.App, error) {
+	d := editor.NewDeployer(b.apiKey, b.templateDir, b.team)
+	return d.DeployEditorAndScaleDown(ctx)
+}
+
+func (b *herokuBackend) FinishBuild(ctx context.Context, app *heroku.App) (*heroku.App, editor.BuildOutcome, error) {
+	d := editor.NewDeployer(b.apiKey, b.templateDir, b.team)
+	return d.FinishBuild(ctx, app)
+}
+
+func (b *herokuBackend) Delete(app *heroku.App) error {
+	return editor.DeleteApp(b.heroku, app, b.logger)
+}
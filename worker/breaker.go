@@ -0,0 +1,152 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jingweno/codeface/store"
+)
+
+const breakerKey = "worker/breaker.json"
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// BreakerState is shared by the workers through the store
+type BreakerState struct {
+	State               string        `json:"state"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	Cooldown            time.Duration `json:"cooldown"`
+	OpenUntil           time.Time     `json:"open_until,omitempty"`
+	LastError           string        `json:"last_error,omitempty"`
+}
+
+// breaker pauses pool fills after threshold consecutive build failures. The
+// cool-down doubles each time a trial build fails after a pause, up to maxCooldown.
+type breaker struct {
+	threshold   int
+	cooldown    time.Duration
+	maxCooldown time.Duration
+	store       store.Store
+	// shared is false if the state is only known to the worker process
+	shared bool
+
+	mu sync.Mutex
+}
+
+type errBreakerOpen struct {
+	until time.Time
+}
+
+func (e errBreakerOpen) Error() string {
+	return fmt.Sprintf("error: pool fills are paused after consecutive build failures until %s", e.until.Format(time.RFC3339))
+}
+
+// State returns the current state of the breaker
+func (b *breaker) State(ctx context.Context) (BreakerState, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.load(ctx)
+}
+
+// Allow returns the max number of builds allowed at now. It returns
+// errBreakerOpen if fills are paused.
+func (b *breaker) Allow(ctx context.Context, now time.Time, limit int) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, err := b.load(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	switch s.State {
+	case BreakerOpen:
+		if now.Before(s.OpenUntil) {
+			return 0, errBreakerOpen{until: s.OpenUntil}
+		}
+
+		s.State = BreakerHalfOpen
+		if err := b.save(ctx, s); err != nil {
+			return 0, err
+		}
+
+		return 1, nil
+	case BreakerHalfOpen:
+		// only one trial build at a time
+		return 1, nil
+	default:
+		return limit, nil
+	}
+}
+
+// Record records the outcome of a build finished at now. A build canceled
+// by the worker, e.g. on shutdown, isn't an outcome.
+func (b *breaker) Record(ctx context.Context, now time.Time, buildErr error) (BreakerState, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, err := b.load(ctx)
+	if err != nil {
+		return s, err
+	}
+
+	if errors.Is(buildErr, context.Canceled) {
+		return s, nil
+	}
+
+	if buildErr == nil {
+		s = BreakerState{State: BreakerClosed}
+		return s, b.save(ctx, s)
+	}
+
+	s.ConsecutiveFailures++
+	s.LastError = buildErr.Error()
+
+	switch {
+	case s.State == BreakerHalfOpen:
+		s.Cooldown *= 2
+		if s.Cooldown > b.maxCooldown {
+			s.Cooldown = b.maxCooldown
+		}
+		s.State = BreakerOpen
+		s.OpenUntil = now.Add(s.Cooldown)
+	case s.State == BreakerClosed && s.ConsecutiveFailures >= b.threshold:
+		s.Cooldown = b.cooldown
+		s.State = BreakerOpen
+		s.OpenUntil = now.Add(s.Cooldown)
+	}
+
+	return s, b.save(ctx, s)
+}
+
+func (b *breaker) load(ctx context.Context) (BreakerState, error) {
+	s := BreakerState{State: BreakerClosed}
+
+	v, err := b.store.Get(ctx, breakerKey)
+	if err == store.ErrNotFound {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+
+	return s, json.Unmarshal(v, &s)
+}
+
+func (b *breaker) save(ctx context.Context, s BreakerState) error {
+	v, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return b.store.Put(ctx, breakerKey, v)
+}
@@ -0,0 +1,145 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jingweno/codeface/store"
+)
+
+func newTestBreaker() *breaker {
+	return &breaker{
+		threshold:   3,
+		cooldown:    5 * time.Minute,
+		maxCooldown: 15 * time.Minute,
+		store:       store.NewMemory(),
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		now      = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+		buildErr = errors.New("error: build failed")
+	)
+
+	type step struct {
+		// at is the time since now
+		at time.Duration
+		// record is the outcome to record, or allow the builds if nil
+		record *error
+		// state is the state after the step
+		state string
+		// allowed is the number of builds allowed out of 2, -1 if paused
+		allowed int
+	}
+
+	fail := &buildErr
+	succeed := new(error)
+	canceled := new(error)
+	*canceled = fmt.Errorf("error: deploy: %w", context.Canceled)
+
+	cases := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "opens after consecutive failures",
+			steps: []step{
+				{record: fail, state: BreakerClosed},
+				{record: fail, state: BreakerClosed},
+				{allowed: 2, state: BreakerClosed},
+				{record: fail, state: BreakerOpen},
+				{at: 4 * time.Minute, allowed: -1, state: BreakerOpen},
+			},
+		},
+		{
+			name: "a success resets the failures",
+			steps: []step{
+				{record: fail, state: BreakerClosed},
+				{record: fail, state: BreakerClosed},
+				{record: succeed, state: BreakerClosed},
+				{record: fail, state: BreakerClosed},
+				{record: fail, state: BreakerClosed},
+				{allowed: 2, state: BreakerClosed},
+			},
+		},
+		{
+			name: "canceled builds aren't failures",
+			steps: []step{
+				{record: fail, state: BreakerClosed},
+				{record: fail, state: BreakerClosed},
+				{record: canceled, state: BreakerClosed},
+				{record: canceled, state: BreakerClosed},
+				{allowed: 2, state: BreakerClosed},
+			},
+		},
+		{
+			name: "a trial build after the cool-down closes it",
+			steps: []step{
+				{record: fail},
+				{record: fail},
+				{record: fail, state: BreakerOpen},
+				{at: 5 * time.Minute, allowed: 1, state: BreakerHalfOpen},
+				{at: 5 * time.Minute, allowed: 1, state: BreakerHalfOpen},
+				{at: 6 * time.Minute, record: succeed, state: BreakerClosed},
+				{at: 6 * time.Minute, allowed: 2, state: BreakerClosed},
+			},
+		},
+		{
+			name: "a failed trial build doubles the cool-down up to the max",
+			steps: []step{
+				{record: fail},
+				{record: fail},
+				{record: fail, state: BreakerOpen},
+				{at: 5 * time.Minute, allowed: 1, state: BreakerHalfOpen},
+				{at: 5 * time.Minute, record: fail, state: BreakerOpen},
+				{at: 14 * time.Minute, allowed: -1, state: BreakerOpen},
+				{at: 15 * time.Minute, allowed: 1, state: BreakerHalfOpen},
+				{at: 15 * time.Minute, record: fail, state: BreakerOpen},
+				{at: 29 * time.Minute, allowed: -1, state: BreakerOpen},
+				{at: 30 * time.Minute, allowed: 1, state: BreakerHalfOpen},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			b := newTestBreaker()
+
+			for i, s := range c.steps {
+				at := now.Add(s.at)
+
+				if s.record != nil {
+					if _, err := b.Record(ctx, at, *s.record); err != nil {
+						t.Fatalf("step %d: %s", i, err)
+					}
+				} else {
+					n, err := b.Allow(ctx, at, 2)
+					if s.allowed < 0 {
+						if _, ok := err.(errBreakerOpen); !ok {
+							t.Errorf("step %d: want errBreakerOpen, got %d, %v", i, n, err)
+						}
+					} else if err != nil || n != s.allowed {
+						t.Errorf("step %d: want %d builds allowed, got %d, %v", i, s.allowed, n, err)
+					}
+				}
+
+				if s.state == "" {
+					continue
+				}
+
+				state, err := b.State(ctx)
+				if err != nil {
+					t.Fatalf("step %d: %s", i, err)
+				}
+				if state.State != s.state {
+					t.Errorf("step %d: want state %s, got %s", i, s.state, state.State)
+				}
+			}
+		})
+	}
+}
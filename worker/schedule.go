@@ -0,0 +1,255 @@
+package worker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxScheduleWindow bounds how far back the start of a window is looked up
+const maxScheduleWindow = 7 * 24 * time.Hour
+
+var weekdays = map[string]int{
+	"sun": 0,
+	"mon": 1,
+	"tue": 2,
+	"wed": 3,
+	"thu": 4,
+	"fri": 5,
+	"sat": 6,
+}
+
+var months = map[string]int{
+	"jan": 1,
+	"feb": 2,
+	"mar": 3,
+	"apr": 4,
+	"may": 5,
+	"jun": 6,
+	"jul": 7,
+	"aug": 8,
+	"sep": 9,
+	"oct": 10,
+	"nov": 11,
+	"dec": 12,
+}
+
+// Schedule is a list of pool sizes by time separated by semicolons. Each
+// entry is in the format of "CRON DURATION SIZE": the pool size is SIZE for
+// DURATION after each time matching the cron expression, e.g.
+// "0 8 * * mon-fri 10h 10; 0 10 * * sat,sun 4h 3" keeps 10 apps on weekdays
+// from 08:00 to 18:00. CRON has the five fields of crontab(5): minute, hour,
+// day of month, month and day of week. The first active entry wins, and the
+// pool size falls back to POOL_SIZE or the adaptive size otherwise.
+type Schedule []ScheduleEntry
+
+type ScheduleEntry struct {
+	Raw string
+	// fields of the cron expression as bit sets
+	minute, hour, dom, month, dow uint64
+	// with a restricted day of month and day of week, a day matching
+	// either of them matches, as in cron
+	domAny, dowAny bool
+	Duration       time.Duration
+	Size           int
+}
+
+// Decode implements envdecode.Decoder
+func (s *Schedule) Decode(v string) error {
+	sch, err := ParseSchedule(v)
+	if err != nil {
+		return err
+	}
+
+	*s = sch
+	return nil
+}
+
+func ParseSchedule(v string) (Schedule, error) {
+	var sch Schedule
+	for _, raw := range strings.Split(v, ";") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		e, err := parseScheduleEntry(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule entry %q: %w", raw, err)
+		}
+
+		sch = append(sch, e)
+	}
+
+	return sch, nil
+}
+
+func parseScheduleEntry(raw string) (ScheduleEntry, error) {
+	e := ScheduleEntry{Raw: raw}
+
+	fields := strings.Fields(raw)
+	if len(fields) != 7 {
+		return e, fmt.Errorf("expect MINUTE HOUR DAY-OF-MONTH MONTH DAY-OF-WEEK DURATION SIZE")
+	}
+
+	var err error
+	if e.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return e, err
+	}
+	if e.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return e, err
+	}
+	if e.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return e, err
+	}
+	if e.month, err = parseCronField(fields[3], 1, 12, months); err != nil {
+		return e, err
+	}
+	// both 0 and 7 are Sunday
+	if e.dow, err = parseCronField(fields[4], 0, 7, weekdays); err != nil {
+		return e, err
+	}
+	if e.dow&(1<<7) != 0 {
+		e.dow |= 1
+	}
+	e.domAny = strings.HasPrefix(fields[2], "*")
+	e.dowAny = strings.HasPrefix(fields[4], "*")
+
+	if e.Duration, err = time.ParseDuration(fields[5]); err != nil {
+		return e, fmt.Errorf("invalid duration %q", fields[5])
+	}
+	if e.Duration < time.Minute || e.Duration > maxScheduleWindow {
+		return e, fmt.Errorf("duration %s is not between 1m and %s", e.Duration, maxScheduleWindow)
+	}
+
+	if e.Size, err = strconv.Atoi(fields[6]); err != nil || e.Size < 0 {
+		return e, fmt.Errorf("invalid size %q", fields[6])
+	}
+
+	return e, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges like
+// "1-5", "*" and steps like "*/15" or "10-50/20" into a bit set
+func parseCronField(v string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(strings.ToLower(v), ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rng, step = part[:i], n
+		}
+
+		from, to := min, max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+
+			var err error
+			if from, err = parseCronValue(bounds[0], min, max, names); err != nil {
+				return 0, err
+			}
+
+			to = from
+			if len(bounds) == 2 {
+				if to, err = parseCronValue(bounds[1], min, max, names); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// "10/20" is from 10 to the max
+				to = max
+			}
+
+			if to < from {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		}
+
+		for i := from; i <= to; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+
+	return bits, nil
+}
+
+func parseCronValue(v string, min, max int, names map[string]int) (int, error) {
+	if n, ok := names[v]; ok {
+		return n, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("invalid value %q, expect %d-%d", v, min, max)
+	}
+
+	return n, nil
+}
+
+// matches reports whether the minute of t matches the cron expression
+func (e ScheduleEntry) matches(t time.Time) bool {
+	if e.minute&(1<<uint(t.Minute())) == 0 ||
+		e.hour&(1<<uint(t.Hour())) == 0 ||
+		e.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	domMatch := e.dom&(1<<uint(t.Day())) != 0
+	dowMatch := e.dow&(1<<uint(t.Weekday())) != 0
+	if e.domAny || e.dowAny {
+		return domMatch && dowMatch
+	}
+
+	return domMatch || dowMatch
+}
+
+// Active reports whether t is within Duration of a time matching the cron
+// expression
+func (e ScheduleEntry) Active(t time.Time) bool {
+	t = t.Truncate(time.Minute)
+	for d := time.Duration(0); d < e.Duration; d += time.Minute {
+		if e.matches(t.Add(-d)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Location is a time zone decoded from its IANA name
+type Location struct {
+	*time.Location
+}
+
+// Decode implements envdecode.Decoder
+func (l *Location) Decode(v string) error {
+	loc, err := time.LoadLocation(v)
+	if err != nil {
+		return err
+	}
+
+	l.Location = loc
+	return nil
+}
+
+// scheduleSizer uses the size of the active schedule entry and falls back otherwise
+type scheduleSizer struct {
+	schedule Schedule
+	location *time.Location
+	fallback Sizer
+}
+
+func (s *scheduleSizer) Target(now time.Time) (int, string) {
+	local := now.In(s.location)
+	for _, e := range s.schedule {
+		if e.Active(local) {
+			return e.Size, fmt.Sprintf("schedule %q in %s", e.Raw, s.location)
+		}
+	}
+
+	size, reason := s.fallback.Target(now)
+	return size, "outside schedule, " + reason
+}
@@ -0,0 +1,121 @@
+package worker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule_Invalid(t *testing.T) {
+	cases := []string{
+		"0 8 * * 1-5 10",          // missing duration
+		"0 8 * * 1-5 10h 10 x",    // extra field
+		"60 8 * * * 1h 1",         // minute out of range
+		"0 24 * * * 1h 1",         // hour out of range
+		"0 8 0 * * 1h 1",          // day of month out of range
+		"0 8 * 13 * 1h 1",         // month out of range
+		"0 8 * * 8 1h 1",          // day of week out of range
+		"0 8 * * fri-mon 1h 1",    // reversed range
+		"*/0 8 * * * 1h 1",        // zero step
+		"0 8 * * foo 1h 1",        // unknown name
+		"0 8 * * * 30s 1",         // duration too short
+		"0 8 * * * 200h 1",        // duration too long
+		"0 8 * * * 1h -1",         // negative size
+		"0 8 * * * 1h ten",        // invalid size
+		"0 8 * * * 1h 1; 0 8 * *", // invalid second entry
+	}
+
+	for _, c := range cases {
+		if _, err := ParseSchedule(c); err == nil {
+			t.Errorf("ParseSchedule(%q) = nil error, want error", c)
+		}
+	}
+}
+
+func TestSchedule_Active(t *testing.T) {
+	// 2024-01-01 is a Monday
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2024, time.January, day, hour, min, 30, 0, time.UTC)
+	}
+
+	cases := []struct {
+		entry  string
+		t      time.Time
+		active bool
+	}{
+		// weekdays from 08:00 to 18:00
+		{"0 8 * * mon-fri 10h 10", at(1, 7, 59), false},
+		{"0 8 * * mon-fri 10h 10", at(1, 8, 0), true},
+		{"0 8 * * mon-fri 10h 10", at(1, 17, 59), true},
+		{"0 8 * * mon-fri 10h 10", at(1, 18, 0), false},
+		{"0 8 * * 1-5 10h 10", at(5, 12, 0), true},
+		{"0 8 * * 1-5 10h 10", at(6, 12, 0), false},
+		// a window spanning midnight belongs to the day it starts
+		{"0 22 * * fri 4h 3", at(5, 23, 0), true},
+		{"0 22 * * fri 4h 3", at(6, 1, 59), true},
+		{"0 22 * * fri 4h 3", at(6, 2, 0), false},
+		{"0 22 * * fri 4h 3", at(7, 1, 0), false},
+		// both 0 and 7 are Sunday
+		{"0 10 * * 7 1h 3", at(7, 10, 30), true},
+		{"0 10 * * 0 1h 3", at(7, 10, 30), true},
+		// lists and steps
+		{"0,30 * * * * 10m 1", at(1, 9, 35), true},
+		{"0,30 * * * * 10m 1", at(1, 9, 45), false},
+		{"*/20 9 * * * 5m 1", at(1, 9, 42), true},
+		{"*/20 9 * * * 5m 1", at(1, 9, 46), false},
+		{"10/20 9 * * * 5m 1", at(1, 9, 52), true},
+		// a restricted day of month or day of week matches either, as in cron
+		{"0 9 15 * mon 1h 1", at(15, 9, 0), true},
+		{"0 9 15 * mon 1h 1", at(8, 9, 0), true},
+		{"0 9 15 * mon 1h 1", at(9, 9, 0), false},
+		{"0 9 15 * * 1h 1", at(8, 9, 0), false},
+		{"0 9 * jan,feb * 1h 1", at(8, 9, 0), true},
+		{"0 9 * mar * 1h 1", at(8, 9, 0), false},
+	}
+
+	for _, c := range cases {
+		sch, err := ParseSchedule(c.entry)
+		if err != nil {
+			t.Fatalf("ParseSchedule(%q): %s", c.entry, err)
+		}
+
+		if got := sch[0].Active(c.t); got != c.active {
+			t.Errorf("%q active at %s = %t, want %t", c.entry, c.t.Format("Mon 15:04"), got, c.active)
+		}
+	}
+}
+
+func TestScheduleSizer_Target(t *testing.T) {
+	sch, err := ParseSchedule("0 8 * * mon-fri 10h 10; 0 6 * * * 4h 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	s := &scheduleSizer{
+		schedule: sch,
+		location: loc,
+		fallback: staticSizer{size: 2},
+	}
+
+	cases := []struct {
+		t    time.Time
+		size int
+	}{
+		// 13:00 UTC is 08:00 in New York on a Monday
+		{time.Date(2024, time.January, 1, 13, 0, 0, 0, time.UTC), 10},
+		// the first active entry wins
+		{time.Date(2024, time.January, 1, 14, 0, 0, 0, time.UTC), 10},
+		{time.Date(2024, time.January, 6, 14, 0, 0, 0, time.UTC), 4},
+		{time.Date(2024, time.January, 6, 20, 0, 0, 0, time.UTC), 2},
+	}
+
+	for _, c := range cases {
+		if size, reason := s.Target(c.t); size != c.size {
+			t.Errorf("Target(%s) = %d (%s), want %d", c.t, size, reason, c.size)
+		}
+	}
+}
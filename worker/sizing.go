@@ -0,0 +1,173 @@
+package worker
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Sizer decides how many idle apps of the current version the pool should hold
+type Sizer interface {
+	// Target returns the pool size at now and a human readable reason for it
+	Target(now time.Time) (size int, reason string)
+}
+
+type staticSizer struct {
+	size int
+}
+
+func (s staticSizer) Target(now time.Time) (int, string) {
+	return s.size, "static pool size"
+}
+
+// adaptiveSizer sizes the pool to cover the claims expected while the
+// claimed apps are being replaced, i.e. claim rate * replenish time
+type adaptiveSizer struct {
+	min, max int
+	// fallback is used as a floor until demand has been observed for a full window
+	fallback int
+	window   time.Duration
+	interval time.Duration
+	demand   *demand
+	started  time.Time
+}
+
+func (a *adaptiveSizer) Target(now time.Time) (int, string) {
+	claims := a.demand.Claims(now, a.window)
+	buildTime := a.demand.BuildTime()
+	// an app claimed right after a tick is replaced by the next tick at the latest
+	replenish := buildTime + a.interval
+
+	rate := float64(claims) / a.window.Seconds()
+	size := int(math.Ceil(rate * replenish.Seconds()))
+	reason := fmt.Sprintf("%d claim(s) in the last %s, replenish time %s", claims, a.window, replenish.Round(time.Second))
+
+	if now.Sub(a.started) < a.window && size < a.fallback {
+		size = a.fallback
+		reason += fmt.Sprintf(", warming up with pool size %d", a.fallback)
+	}
+
+	if size < a.min {
+		size = a.min
+		reason += fmt.Sprintf(", raised to min %d", a.min)
+	}
+	if size > a.max {
+		size = a.max
+		reason += fmt.Sprintf(", capped to max %d", a.max)
+	}
+
+	return size, reason
+}
+
+// demand records claims observed between reconciliations and the average build time
+type demand struct {
+	mu sync.Mutex
+
+	claims    []claimObservation
+	buildTime time.Duration
+	builds    int
+
+	// idle apps at the last observation and the changes the worker made since
+	lastIdle int
+	observed bool
+	added    int
+	removed  int
+}
+
+type claimObservation struct {
+	at time.Time
+	n  int
+}
+
+const buildTimeSmoothing = 0.3
+
+func newDemand(buildTimeEstimate time.Duration) *demand {
+	return &demand{buildTime: buildTimeEstimate}
+}
+
+// ObserveIdle infers the number of claims since the last observation from the
+// number of idle apps the worker expects and the number it actually sees
+func (d *demand) ObserveIdle(now time.Time, idle int) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	claimed := 0
+	if d.observed {
+		claimed = d.lastIdle + d.added - d.removed - idle
+	}
+	// more idle apps than expected are added by others, not negative claims
+	if claimed < 0 {
+		claimed = 0
+	}
+	if claimed > 0 {
+		d.claims = append(d.claims, claimObservation{at: now, n: claimed})
+	}
+
+	d.lastIdle = idle
+	d.observed = true
+	d.added = 0
+	d.removed = 0
+
+	return claimed
+}
+
+// Reset forgets the last observation so that the next one doesn't infer claims
+func (d *demand) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.observed = false
+	d.added = 0
+	d.removed = 0
+}
+
+func (d *demand) ObserveAdded(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.added += n
+}
+
+func (d *demand) ObserveRemoved(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.removed += n
+}
+
+// ObserveBuild updates the moving average of build durations
+func (d *demand) ObserveBuild(dur time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.builds == 0 {
+		d.buildTime = dur
+	} else {
+		d.buildTime = time.Duration(buildTimeSmoothing*float64(dur) + (1-buildTimeSmoothing)*float64(d.buildTime))
+	}
+	d.builds++
+}
+
+func (d *demand) BuildTime() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.buildTime
+}
+
+// Claims returns the number of claims within window before now and forgets older ones
+func (d *demand) Claims(now time.Time, window time.Duration) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i := 0
+	for i < len(d.claims) && now.Sub(d.claims[i].at) > window {
+		i++
+	}
+	d.claims = d.claims[i:]
+
+	n := 0
+	for _, c := range d.claims {
+		n += c.n
+	}
+
+	return n
+}
@@ -0,0 +1,304 @@
+package worker
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/lock"
+	"github.com/jingweno/codeface/store"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestAdaptiveSizer(t *testing.T) {
+	now := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name string
+		// uptime is how long the worker has been running
+		uptime time.Duration
+		// claims are observed at the time before now
+		claims    map[time.Duration]int
+		buildTime time.Duration
+		want      int
+	}{
+		{
+			name:   "warming up without demand",
+			uptime: 10 * time.Minute,
+			want:   5,
+		},
+		{
+			name:   "warming up with more demand than the fallback",
+			uptime: 10 * time.Minute,
+			// 120 claims an hour * 6m to replenish
+			claims: map[time.Duration]int{time.Minute: 120},
+			want:   12,
+		},
+		{
+			name:   "no demand after warming up",
+			uptime: 2 * time.Hour,
+			want:   1,
+		},
+		{
+			name:   "claim rate * replenish time",
+			uptime: 2 * time.Hour,
+			claims: map[time.Duration]int{time.Minute: 30, 30 * time.Minute: 30},
+			want:   6,
+		},
+		{
+			name:   "rounds up a fraction of an app",
+			uptime: 2 * time.Hour,
+			claims: map[time.Duration]int{time.Minute: 11},
+			want:   2,
+		},
+		{
+			name:      "slower builds need a larger pool",
+			uptime:    2 * time.Hour,
+			claims:    map[time.Duration]int{time.Minute: 60},
+			buildTime: 11 * time.Minute,
+			want:      12,
+		},
+		{
+			name:   "claims out of the window are forgotten",
+			uptime: 2 * time.Hour,
+			claims: map[time.Duration]int{time.Minute: 10, 61 * time.Minute: 100},
+			want:   1,
+		},
+		{
+			name:   "capped to max",
+			uptime: 2 * time.Hour,
+			claims: map[time.Duration]int{time.Minute: 600},
+			want:   20,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			buildTime := c.buildTime
+			if buildTime == 0 {
+				buildTime = 5 * time.Minute
+			}
+
+			d := newDemand(buildTime)
+			// claims are kept in the order they are observed
+			for _, ago := range []time.Duration{61 * time.Minute, 30 * time.Minute, time.Minute} {
+				if n, ok := c.claims[ago]; ok {
+					d.claims = append(d.claims, claimObservation{at: now.Add(-ago), n: n})
+				}
+			}
+
+			s := &adaptiveSizer{
+				min:      1,
+				max:      20,
+				fallback: 5,
+				window:   time.Hour,
+				interval: time.Minute,
+				demand:   d,
+				started:  now.Add(-c.uptime),
+			}
+
+			got, reason := s.Target(now)
+			if got != c.want {
+				t.Errorf("want pool size %d, got %d (%s)", c.want, got, reason)
+			}
+		})
+	}
+}
+
+func TestAdaptiveSizer_Min(t *testing.T) {
+	now := time.Now()
+	s := &adaptiveSizer{
+		min:      3,
+		max:      20,
+		window:   time.Hour,
+		interval: time.Minute,
+		demand:   newDemand(5 * time.Minute),
+		started:  now.Add(-2 * time.Hour),
+	}
+
+	if got, reason := s.Target(now); got != 3 {
+		t.Errorf("want pool size raised to 3, got %d (%s)", got, reason)
+	}
+}
+
+func TestDemand(t *testing.T) {
+	type step struct {
+		added, removed int
+		reset          bool
+		// idle is the number of idle apps observed after the changes
+		idle int
+		// claimed is the number of claims inferred from the observation
+		claimed int
+	}
+
+	cases := []struct {
+		name  string
+		steps []step
+		// claims is the number of claims in the window at the end
+		claims int
+	}{
+		{
+			name:  "the first observation infers nothing",
+			steps: []step{{idle: 5}},
+		},
+		{
+			name: "fewer idle apps are claims",
+			steps: []step{
+				{idle: 5},
+				{idle: 3, claimed: 2},
+				{idle: 2, claimed: 1},
+			},
+			claims: 3,
+		},
+		{
+			name: "apps added by the worker aren't claims",
+			steps: []step{
+				{idle: 3},
+				{added: 2, idle: 5},
+				{added: 2, idle: 6, claimed: 1},
+			},
+			claims: 1,
+		},
+		{
+			name: "apps removed by the worker aren't claims",
+			steps: []step{
+				{idle: 5},
+				// a prune of outdated or surplus apps
+				{removed: 2, idle: 3},
+				{removed: 1, idle: 1, claimed: 1},
+			},
+			claims: 1,
+		},
+		{
+			name: "apps added by others aren't negative claims",
+			steps: []step{
+				{idle: 3},
+				{idle: 5},
+				{idle: 4, claimed: 1},
+			},
+			claims: 1,
+		},
+		{
+			name: "a reset forgets the changes of others",
+			steps: []step{
+				{idle: 5},
+				{removed: 1, reset: true, idle: 1},
+				{idle: 0, claimed: 1},
+			},
+			claims: 1,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d := newDemand(5 * time.Minute)
+			now := time.Now()
+
+			for i, s := range c.steps {
+				d.ObserveAdded(s.added)
+				d.ObserveRemoved(s.removed)
+				if s.reset {
+					d.Reset()
+				}
+
+				if got := d.ObserveIdle(now, s.idle); got != s.claimed {
+					t.Errorf("step %d: want %d claim(s), got %d", i, s.claimed, got)
+				}
+			}
+
+			if got := d.Claims(now, time.Hour); got != c.claims {
+				t.Errorf("want %d claim(s) in the window, got %d", c.claims, got)
+			}
+		})
+	}
+}
+
+func TestDemand_ObserveBuild(t *testing.T) {
+	d := newDemand(5 * time.Minute)
+
+	// the first build replaces the estimate
+	d.ObserveBuild(10 * time.Minute)
+	if got := d.BuildTime(); got != 10*time.Minute {
+		t.Errorf("want build time 10m, got %s", got)
+	}
+
+	d.ObserveBuild(20 * time.Minute)
+	if got := d.BuildTime(); got != 13*time.Minute {
+		t.Errorf("want build time 13m, got %s", got)
+	}
+}
+
+// TestWorker_DemandOfPoolChanges checks that the apps the worker adds, prunes
+// or removes aren't taken as claims
+func TestWorker_DemandOfPoolChanges(t *testing.T) {
+	logger := log.New()
+	logger.Out = ioutil.Discard
+
+	old := time.Now().Add(-time.Hour)
+	backend := &fakeBackend{}
+	for _, id := range []string{"a", "b", "c", "d"} {
+		backend.apps = append(backend.apps, heroku.App{Name: "cf-" + id + "-00000000i", CreatedAt: old})
+	}
+
+	w := newWorker(Config{
+		BatchSize:        2,
+		PoolSize:         3,
+		PoolAdaptive:     true,
+		PoolMinSize:      1,
+		PoolMaxSize:      3,
+		DemandWindow:     time.Hour,
+		BreakerThreshold: 3,
+		TemplateDir:      os.TempDir(),
+	}, backend, lock.NewMemory(), store.NewMemory(), logger)
+
+	ctx := context.Background()
+	claims := func() int {
+		return w.demand.Claims(time.Now(), time.Hour)
+	}
+
+	// 2 apps are added and 2 outdated apps removed, and the other 2 are pruned
+	if err := w.RunOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.PruneOutdated(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.RunOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := claims(); got != 0 {
+		t.Errorf("want no claims, got %d", got)
+	}
+
+	// a claim renames an idle app
+	currentVersion, _ := editor.SplitIdledApps(backend.apps)
+	if len(currentVersion) != 3 {
+		t.Fatalf("want 3 idle apps, got %d", len(currentVersion))
+	}
+	backend.Delete(&currentVersion[0])
+
+	if err := w.RunOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := claims(); got != 1 {
+		t.Errorf("want 1 claim, got %d", got)
+	}
+
+	// after warming up, the pool shrinks toward the adaptive size
+	w.sizer.(*adaptiveSizer).started = time.Now().Add(-2 * time.Hour)
+	for i := 0; i < 2; i++ {
+		if err := w.RunOnce(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if currentVersion, _ := editor.SplitIdledApps(backend.apps); len(currentVersion) != 1 {
+		t.Errorf("want the pool to shrink to 1 app, got %d", len(currentVersion))
+	}
+	if got := claims(); got != 1 {
+		t.Errorf("want 1 claim, got %d", got)
+	}
+}
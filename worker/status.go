@@ -0,0 +1,103 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/jingweno/codeface/editor"
+)
+
+type PoolStatus struct {
+	CurrentVersion string      `json:"current_version"`
+	PoolSize       int         `json:"pool_size"`
+	PoolSizeReason string      `json:"pool_size_reason"`
+	Counts         []PoolCount `json:"counts"`
+	OldestIdle     *PoolApp    `json:"oldest_idle,omitempty"`
+	// Breaker is nil if the breaker is kept in the memory of the worker
+	// process, see Config.LockBackend
+	Breaker *BreakerState `json:"breaker,omitempty"`
+}
+
+type PoolCount struct {
+	State   editor.AppState `json:"state"`
+	Version string          `json:"version"`
+	Count   int             `json:"count"`
+}
+
+type PoolApp struct {
+	Name      string        `json:"name"`
+	Version   string        `json:"version"`
+	CreatedAt time.Time     `json:"created_at"`
+	Age       time.Duration `json:"age"`
+}
+
+// MarshalJSON writes the age as a duration string, e.g. "1h2m3s", instead
+// of nanoseconds
+func (a PoolApp) MarshalJSON() ([]byte, error) {
+	type poolApp PoolApp
+	return json.Marshal(struct {
+		poolApp
+		Age string `json:"age"`
+	}{poolApp(a), a.Age.String()})
+}
+
+// Status counts the Codeface apps by state and template version
+func (w *Worker) Status(ctx context.Context) (*PoolStatus, error) {
+	apps, err := w.backend.Apps(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	size, reason := w.sizer.Target(time.Now())
+	status := &PoolStatus{
+		CurrentVersion: editor.CurrentVersion(),
+		PoolSize:       size,
+		PoolSizeReason: reason,
+	}
+
+	if w.breaker.shared {
+		breaker, err := w.breaker.State(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		status.Breaker = &breaker
+	}
+
+	type key struct {
+		state   editor.AppState
+		version string
+	}
+	counts := make(map[key]int)
+	for _, app := range apps {
+		state, version, _ := editor.ParseAppName(app.Name)
+		counts[key{state, version}]++
+
+		if state != editor.AppStateIdle {
+			continue
+		}
+
+		if status.OldestIdle == nil || app.CreatedAt.Before(status.OldestIdle.CreatedAt) {
+			status.OldestIdle = &PoolApp{
+				Name:      app.Name,
+				Version:   version,
+				CreatedAt: app.CreatedAt,
+				Age:       time.Since(app.CreatedAt).Round(time.Second),
+			}
+		}
+	}
+
+	for k, n := range counts {
+		status.Counts = append(status.Counts, PoolCount{State: k.state, Version: k.version, Count: n})
+	}
+	sort.Slice(status.Counts, func(i, j int) bool {
+		if status.Counts[i].State != status.Counts[j].State {
+			return status.Counts[i].State < status.Counts[j].State
+		}
+		return status.Counts[i].Version < status.Counts[j].Version
+	})
+
+	return status, nil
+}
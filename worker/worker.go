@@ -2,66 +2,264 @@ package worker
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
 	"time"
 
 	heroku "github.com/heroku/heroku-go/v5"
 	"github.com/jingweno/codeface/editor"
-	"github.com/oklog/run"
+	"github.com/jingweno/codeface/health"
+	"github.com/jingweno/codeface/lock"
+	"github.com/jingweno/codeface/metrics"
+	"github.com/jingweno/codeface/store"
 	log "github.com/sirupsen/logrus"
 )
 
 type Config struct {
-	HerokuAPIKey  string        `env:"HEROKU_API_KEY,required"`
+	HerokuAPIKey string `env:"HEROKU_API_KEY,required"`
+	// pool apps are created in the Heroku Team instead of the account of HEROKU_API_KEY
+	HerokuTeam    string        `env:"HEROKU_TEAM"`
 	BatchSize     int           `env:"BATCH_SIZE,default=2"`
 	PoolSize      int           `env:"POOL_SIZE,default=5"`
 	CheckInterval time.Duration `env:"CHECK_INTERVAL,default=1m"`
-	TemplateDir   string
+	MetricsPort   string        `env:"METRICS_PORT"`
+	// adaptive sizing computes the pool size from the recent claim rate and build time
+	PoolAdaptive      bool          `env:"POOL_ADAPTIVE,default=false"`
+	PoolMinSize       int           `env:"POOL_MIN_SIZE,default=1"`
+	PoolMaxSize       int           `env:"POOL_MAX_SIZE,default=20"`
+	DemandWindow      time.Duration `env:"DEMAND_WINDOW,default=1h"`
+	BuildTimeEstimate time.Duration `env:"BUILD_TIME_ESTIMATE,default=5m"`
+	// scheduled pool sizes take precedence over the static or adaptive size, see Schedule
+	PoolSchedule         Schedule `env:"POOL_SCHEDULE"`
+	PoolScheduleLocation Location `env:"POOL_SCHEDULE_TZ,default=UTC"`
+	// idle apps above a lowered pool size are removed gradually. It's always
+	// on with adaptive sizing or a schedule, otherwise surplus apps are left
+	// to be claimed.
+	PoolShrink bool `env:"POOL_SHRINK,default=false"`
+	// the lock makes sure only one worker changes the pool at a time when
+	// running several worker processes: memory, file or postgres. The state
+	// shared by workers is kept in the same backend. It defaults to postgres
+	// with a DATABASE_URL, otherwise to memory, which only works with a
+	// single worker dyno.
+	LockBackend string `env:"LOCK_BACKEND"`
+	LockFile    string `env:"LOCK_FILE"`
+	StateDir    string `env:"STATE_DIR"`
+	DatabaseURL string `env:"DATABASE_URL"`
+	// the breaker pauses pool fills after consecutive build failures
+	BreakerThreshold   int           `env:"BREAKER_THRESHOLD,default=3"`
+	BreakerCooldown    time.Duration `env:"BREAKER_COOLDOWN,default=5m"`
+	BreakerMaxCooldown time.Duration `env:"BREAKER_MAX_COOLDOWN,default=1h"`
+	// building apps older than this are left behind by a crashed worker.
+	// Those still building are only removed once older than the pending age,
+	// e.g. with a slow build or a backlog of Heroku builds.
+	OrphanBuildAge        time.Duration `env:"ORPHAN_BUILD_AGE,default=30m"`
+	OrphanPendingBuildAge time.Duration `env:"ORPHAN_PENDING_BUILD_AGE,default=3h"`
+	TemplateDir           string
 }
 
-func New(cfg Config) *Worker {
-	client := &http.Client{
-		Transport: &heroku.Transport{
-			BearerToken: cfg.HerokuAPIKey,
-		},
+func New(cfg Config) (*Worker, error) {
+	locker, st, err := newCoordination(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := log.New().WithField("com", "worker")
+	client := editor.NewHerokuService(cfg.HerokuAPIKey)
+	backend := &herokuBackend{
+		heroku:      client,
+		apiKey:      cfg.HerokuAPIKey,
+		team:        cfg.HerokuTeam,
+		templateDir: cfg.TemplateDir,
+		logger:      logger,
+	}
+
+	w := newWorker(cfg, backend, locker, st, logger)
+	w.heroku = client
+
+	return w, nil
+}
+
+// lockBackend returns the configured lock backend or the default one
+func lockBackend(cfg Config) string {
+	if cfg.LockBackend != "" {
+		return cfg.LockBackend
+	}
+
+	// worker dynos can't share a lock without a database
+	if cfg.DatabaseURL != "" {
+		return "postgres"
+	}
+
+	return "memory"
+}
+
+func newCoordination(cfg Config) (lock.Locker, store.Store, error) {
+	switch backend := lockBackend(cfg); backend {
+	case "memory":
+		if cfg.LockBackend == "" {
+			log.New().WithField("com", "worker").Warn("Workers are coordinated in memory, only run one worker process or set DATABASE_URL")
+		}
+		return lock.NewMemory(), store.NewMemory(), nil
+	case "file":
+		path := cfg.LockFile
+		if path == "" {
+			path = filepath.Join(os.TempDir(), "codeface-worker.lock")
+		}
+		dir := cfg.StateDir
+		if dir == "" {
+			dir = filepath.Join(os.TempDir(), "codeface-worker")
+		}
+		return lock.NewFile(path), store.NewDir(dir), nil
+	case "postgres":
+		if cfg.DatabaseURL == "" {
+			return nil, nil, fmt.Errorf("DATABASE_URL is required for the postgres lock")
+		}
+		db, err := sql.Open("postgres", cfg.DatabaseURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		st, err := store.NewPostgres(context.Background(), db)
+		if err != nil {
+			return nil, nil, err
+		}
+		return lock.NewPostgres(db, "codeface-worker"), st, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported lock backend %q", backend)
+	}
+}
+
+func newWorker(cfg Config, backend Backend, locker lock.Locker, st store.Store, logger log.FieldLogger) *Worker {
+	d := newDemand(cfg.BuildTimeEstimate)
+
+	var sizer Sizer = staticSizer{size: cfg.PoolSize}
+	if cfg.PoolAdaptive {
+		sizer = &adaptiveSizer{
+			min:      cfg.PoolMinSize,
+			max:      cfg.PoolMaxSize,
+			fallback: cfg.PoolSize,
+			window:   cfg.DemandWindow,
+			interval: cfg.CheckInterval,
+			demand:   d,
+			started:  time.Now(),
+		}
+	}
+
+	if len(cfg.PoolSchedule) > 0 {
+		loc := cfg.PoolScheduleLocation.Location
+		if loc == nil {
+			loc = time.UTC
+		}
+
+		sizer = &scheduleSizer{
+			schedule: cfg.PoolSchedule,
+			location: loc,
+			fallback: sizer,
+		}
 	}
 
 	return &Worker{
-		cfg:    cfg,
-		heroku: heroku.NewService(client),
-		logger: log.New().WithField("com", "worker"),
+		cfg:     cfg,
+		backend: backend,
+		locker:  locker,
+		sizer:   sizer,
+		demand:  d,
+		breaker: &breaker{
+			threshold:   cfg.BreakerThreshold,
+			cooldown:    cfg.BreakerCooldown,
+			maxCooldown: cfg.BreakerMaxCooldown,
+			store:       st,
+			shared:      lockBackend(cfg) != "memory",
+		},
+		logger: logger,
 	}
 }
 
 type Worker struct {
-	cfg    Config
-	heroku *heroku.Service
-	logger log.FieldLogger
+	cfg     Config
+	heroku  *heroku.Service
+	backend Backend
+	locker  lock.Locker
+	sizer   Sizer
+	demand  *demand
+	breaker *breaker
+	logger  log.FieldLogger
+}
+
+var errLocked = errors.New("error: another worker is changing the pool")
+
+// withLock runs fn if no other worker holds the lock and returns errLocked otherwise
+func (w *Worker) withLock(ctx context.Context, fn func() error) error {
+	ok, err := w.locker.TryLock(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		// the pool is changed by others meanwhile so the claims can't be inferred
+		w.demand.Reset()
+		return errLocked
+	}
+
+	defer func() {
+		if err := w.locker.Unlock(context.Background()); err != nil {
+			w.logger.WithError(err).Info("Fail to release lock")
+		}
+	}()
+
+	return fn()
+}
+
+func (w *Worker) idledApps(ctx context.Context) (currentVersion []heroku.App, otherVersion []heroku.App, err error) {
+	apps, err := w.backend.Apps(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	currentVersion, otherVersion = editor.SplitIdledApps(apps)
+	return currentVersion, otherVersion, nil
 }
 
 func (w *Worker) Start(ctx context.Context) error {
 	w.logger.Info("Starting worker")
 
-	if _, err := os.Stat(w.cfg.TemplateDir); os.IsNotExist(err) {
-		return fmt.Errorf("template directory %s does not exist", w.cfg.TemplateDir)
+	if err := w.checkTemplateDir(); err != nil {
+		return err
+	}
+
+	if w.cfg.MetricsPort != "" {
+		srv := w.metricsServer()
+		go func() {
+			w.logger.Infof("Serving metrics and health checks on %s", w.cfg.MetricsPort)
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				w.logger.WithError(err).Info("Fail to serve metrics")
+			}
+		}()
+		defer srv.Close()
 	}
 
+	t := time.NewTicker(w.cfg.CheckInterval)
+	defer t.Stop()
+
 	work := func() {
-		if err := w.addAppsToPool(ctx); err != nil {
-			w.logger.WithError(err).Info("Fail to add apps to pool")
-			return
+		err := w.withLock(ctx, func() error {
+			return w.reconcile(ctx)
+		})
+		if err == errLocked {
+			w.logger.Info("Skipping reconciliation, another worker is changing the pool")
+		} else if err != nil {
+			w.logger.WithError(err).Info("Fail to reconcile pool")
 		}
 
-		if err := w.removeOutdatedApps(ctx); err != nil {
-			w.logger.WithError(err).Info("Fail to remove outdated apps from pool")
+		if err := w.observePool(ctx); err != nil {
+			w.logger.WithError(err).Info("Fail to observe pool")
 		}
 	}
 
-	t := time.NewTicker(w.cfg.CheckInterval)
-	defer t.Stop()
-
 	work() // immediate first tick
 	for {
 		select {
@@ -73,54 +271,363 @@ func (w *Worker) Start(ctx context.Context) error {
 	}
 }
 
-func (w *Worker) removeOutdatedApps(ctx context.Context) error {
-	_, otherVersion, err := editor.AllIdledApps(ctx, w.heroku)
+// RunOnce runs a single reconciliation pass of the pool. It is a no-op if
+// another worker is changing the pool.
+func (w *Worker) RunOnce(ctx context.Context) error {
+	if err := w.checkTemplateDir(); err != nil {
+		return err
+	}
+
+	err := w.withLock(ctx, func() error {
+		return w.reconcile(ctx)
+	})
+	if err == errLocked {
+		w.logger.Info("Skipping reconciliation, another worker is changing the pool")
+		return nil
+	}
+
+	return err
+}
+
+// Fill deploys apps in batches until the pool reaches its size
+func (w *Worker) Fill(ctx context.Context) ([]heroku.App, error) {
+	if err := w.checkTemplateDir(); err != nil {
+		return nil, err
+	}
+
+	size, _ := w.sizer.Target(time.Now())
+
+	var added []heroku.App
+	err := w.withLock(ctx, func() error {
+		for {
+			apps, err := w.addAppsToPool(ctx, size, w.cfg.BatchSize)
+			added = append(added, apps...)
+			if err != nil {
+				return err
+			}
+
+			if len(apps) == 0 {
+				return nil
+			}
+		}
+	})
+
+	return added, err
+}
+
+// Drain removes all idle apps from the pool regardless of their version
+func (w *Worker) Drain(ctx context.Context) ([]heroku.App, error) {
+	var removed []heroku.App
+	err := w.withLock(ctx, func() error {
+		currentVersion, otherVersion, err := w.idledApps(ctx)
+		if err != nil {
+			return err
+		}
+
+		apps := append(currentVersion, otherVersion...)
+		w.logger.WithField("num", len(apps)).Info("Draining pool")
+
+		removed, err = w.deleteApps(apps)
+		return err
+	})
+
+	return removed, err
+}
+
+// PruneOutdated removes all idle apps that are not built from the current template version
+func (w *Worker) PruneOutdated(ctx context.Context) ([]heroku.App, error) {
+	var removed []heroku.App
+	err := w.withLock(ctx, func() error {
+		_, otherVersion, err := w.idledApps(ctx)
+		if err != nil {
+			return err
+		}
+
+		removed, err = w.removeOutdatedApps(ctx, len(otherVersion))
+		return err
+	})
+
+	return removed, err
+}
+
+func (w *Worker) reconcile(ctx context.Context) error {
+	if err := w.sweepOrphanedBuilds(ctx); err != nil {
+		w.logger.WithError(err).Info("Fail to sweep orphaned building apps")
+	}
+
+	currentVersion, otherVersion, err := w.idledApps(ctx)
 	if err != nil {
 		return err
 	}
 
+	now := time.Now()
+	if n := w.demand.ObserveIdle(now, len(currentVersion)+len(otherVersion)); n > 0 {
+		w.logger.WithField("num", n).Info("Observed claims since last check")
+	}
+
+	size, reason := w.sizer.Target(now)
+	w.logger.WithFields(log.Fields{"size": size, "reason": reason}).Info("Computed pool size")
+	metrics.PoolTargetSize.Set(float64(size))
+
+	if _, err := w.addAppsToPool(ctx, size, w.cfg.BatchSize); err != nil {
+		return fmt.Errorf("fail to add apps to pool: %w", err)
+	}
+
+	if _, err := w.removeOutdatedApps(ctx, w.cfg.BatchSize); err != nil {
+		return fmt.Errorf("fail to remove outdated apps from pool: %w", err)
+	}
+
+	if w.cfg.PoolShrink || w.cfg.PoolAdaptive || len(w.cfg.PoolSchedule) > 0 {
+		if _, err := w.removeSurplusApps(ctx, size, w.cfg.BatchSize); err != nil {
+			return fmt.Errorf("fail to remove surplus apps from pool: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (w *Worker) metricsServer() *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", health.LivenessHandler())
+	mux.Handle("/readyz", health.ReadinessHandler(
+		health.Cached(health.HerokuCheck(w.heroku), health.CacheTTL),
+		health.Cached(health.PoolCheck(w.heroku, w.cfg.HerokuTeam), health.CacheTTL),
+	))
+
+	return &http.Server{
+		Addr:    ":" + w.cfg.MetricsPort,
+		Handler: mux,
+	}
+}
+
+func (w *Worker) observePool(ctx context.Context) error {
+	status, err := w.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	// states without apps of the current version are reported as 0, so that
+	// an empty pool can be alerted on
+	counts := make([]metrics.PoolCount, 0, len(status.Counts))
+	current := map[editor.AppState]bool{}
+	for _, c := range status.Counts {
+		counts = append(counts, metrics.PoolCount{
+			State:   string(c.State),
+			Version: c.Version,
+			Count:   c.Count,
+		})
+		if c.Version == status.CurrentVersion {
+			current[c.State] = true
+		}
+	}
+	for _, state := range []editor.AppState{editor.AppStateBuilding, editor.AppStateIdle, editor.AppStateClaimed} {
+		if !current[state] {
+			counts = append(counts, metrics.PoolCount{State: string(state), Version: status.CurrentVersion})
+		}
+	}
+	metrics.SetPoolApps(counts)
+
+	// the worker knows the state of its breaker even if it isn't shared
+	breaker, err := w.breaker.State(ctx)
+	if err != nil {
+		return err
+	}
+	open := 0.0
+	if breaker.State != BreakerClosed {
+		open = 1
+	}
+	metrics.BreakerOpen.Set(open)
+
+	return nil
+}
+
+func (w *Worker) checkTemplateDir() error {
+	if _, err := os.Stat(w.cfg.TemplateDir); os.IsNotExist(err) {
+		return fmt.Errorf("template directory %s does not exist", w.cfg.TemplateDir)
+	}
+
+	return nil
+}
+
+func (w *Worker) removeOutdatedApps(ctx context.Context, limit int) ([]heroku.App, error) {
+	_, otherVersion, err := w.idledApps(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	i := len(otherVersion)
-	n := w.cfg.BatchSize
+	n := limit
 	if n > i {
 		n = i
 	}
 
 	w.logger.WithField("num", n).Info("Removing outdated apps from pool")
-	for _, app := range otherVersion[0:n] {
-		editor.DeleteApp(w.heroku, &app, w.logger)
+
+	return w.deleteApps(otherVersion[0:n])
+}
+
+// removeSurplusApps removes at most limit of the oldest idle apps above size
+// so that a shrinking pool is scaled down gradually
+func (w *Worker) removeSurplusApps(ctx context.Context, size, limit int) ([]heroku.App, error) {
+	currentVersion, _, err := w.idledApps(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	n := len(currentVersion) - size
+	if n <= 0 {
+		return nil, nil
+	}
+	if n > limit {
+		n = limit
+	}
+
+	sort.Slice(currentVersion, func(i, j int) bool {
+		return currentVersion[i].CreatedAt.Before(currentVersion[j].CreatedAt)
+	})
+
+	w.logger.WithField("num", n).Info("Removing surplus apps from pool")
+
+	return w.deleteApps(currentVersion[0:n])
 }
 
-func (w *Worker) addAppsToPool(ctx context.Context) error {
-	currentVersion, _, err := editor.AllIdledApps(ctx, w.heroku)
+// sweepOrphanedBuilds finishes or removes the building apps that are older
+// than OrphanBuildAge. Their deployer crashed before marking them as idle or
+// cleaning them up.
+func (w *Worker) sweepOrphanedBuilds(ctx context.Context) error {
+	apps, err := w.backend.Apps(ctx)
 	if err != nil {
 		return err
 	}
 
-	i := w.cfg.PoolSize - len(currentVersion)
-	n := w.cfg.BatchSize
+	for _, app := range apps {
+		app := app
+		state, _, _ := editor.ParseAppName(app.Name)
+		if state != editor.AppStateBuilding || time.Since(app.CreatedAt) < w.cfg.OrphanBuildAge {
+			continue
+		}
+
+		logger := w.logger.WithField("app", app.Name)
+		logger.Info("Sweeping orphaned building app")
+
+		idle, outcome, err := w.backend.FinishBuild(ctx, &app)
+		if err != nil {
+			// retry on the next check
+			logger.WithError(err).Info("Fail to finish orphaned build")
+			continue
+		}
+
+		switch outcome {
+		case editor.BuildPromoted:
+			logger.WithField("idle", idle.Name).Info("Promoted orphaned building app to idle")
+			w.demand.ObserveAdded(1)
+			continue
+		case editor.BuildPending:
+			if time.Since(app.CreatedAt) < w.cfg.OrphanPendingBuildAge {
+				logger.Info("Orphaned building app is still building, checking again later")
+				continue
+			}
+		}
+
+		logger.WithField("outcome", outcome).Info("Removing orphaned building app")
+		if err := w.backend.Delete(&app); err != nil {
+			logger.WithError(err).Info("Fail to remove orphaned building app")
+		}
+	}
+
+	return nil
+}
+
+func (w *Worker) deleteApps(apps []heroku.App) ([]heroku.App, error) {
+	var (
+		removed []heroku.App
+		lastErr error
+	)
+	for _, app := range apps {
+		app := app
+		if err := w.backend.Delete(&app); err != nil {
+			lastErr = err
+			continue
+		}
+
+		removed = append(removed, app)
+	}
+	w.demand.ObserveRemoved(len(removed))
+
+	return removed, lastErr
+}
+
+type buildResult struct {
+	app *heroku.App
+	err error
+}
+
+// addAppsToPool builds at most limit apps concurrently until the pool reaches
+// size. Each build succeeds or fails on its own and every outcome is recorded
+// by the breaker.
+func (w *Worker) addAppsToPool(ctx context.Context, size, limit int) ([]heroku.App, error) {
+	currentVersion, _, err := w.idledApps(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	i := size - len(currentVersion)
+	n := limit
 	if n > i {
 		n = i
 	}
+	if n <= 0 {
+		return nil, nil
+	}
+
+	if n, err = w.breaker.Allow(ctx, time.Now(), n); err != nil {
+		return nil, err
+	}
 	w.logger.WithField("num", n).Info("Adding apps to pool")
 
-	ctx, cancel := context.WithCancel(ctx)
-	var g run.Group
+	results := make(chan buildResult, n)
 	for j := 0; j < n; j++ {
-		g.Add(func() error {
-			d := editor.NewDeployer(w.cfg.HerokuAPIKey, w.cfg.TemplateDir)
-			_, err := d.DeployEditorAndScaleDown(ctx)
-			return err
-		}, func(err error) {
-			cancel()
-		})
+		go func() {
+			start := time.Now()
+			app, err := w.backend.Deploy(ctx)
+			if err == nil {
+				w.demand.ObserveBuild(time.Since(start))
+				w.demand.ObserveAdded(1)
+			}
+
+			results <- buildResult{app: app, err: err}
+		}()
 	}
 
-	if err := g.Run(); err != nil {
-		return err
+	var (
+		added  []heroku.App
+		failed int
+		last   error
+	)
+	for j := 0; j < n; j++ {
+		r := <-results
+
+		// the state is saved even if the builds are canceled
+		state, err := w.breaker.Record(context.Background(), time.Now(), r.err)
+		if err != nil {
+			w.logger.WithError(err).Info("Fail to record build outcome")
+		}
+
+		if r.err != nil {
+			failed++
+			last = r.err
+			w.logger.WithError(r.err).WithField("breaker", state.State).Info("Fail to build app")
+			continue
+		}
+
+		w.logger.WithField("app", r.app.Name).Info("Built app")
+		added = append(added, *r.app)
 	}
 
-	return nil
+	if failed > 0 {
+		return added, fmt.Errorf("%d of %d build(s) failed: %w", failed, n, last)
+	}
+
+	return added, nil
 }
@@ -0,0 +1,277 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/lock"
+	"github.com/jingweno/codeface/store"
+	log "github.com/sirupsen/logrus"
+)
+
+type fakeBackend struct {
+	mu     sync.Mutex
+	apps   []heroku.App
+	nextID int
+	// maxApps is the largest number of apps the pool ever had
+	maxApps int
+	// builds are the outcomes of building apps, failed if missing
+	builds map[string]editor.BuildOutcome
+}
+
+func (b *fakeBackend) Apps(ctx context.Context) ([]heroku.App, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]heroku.App(nil), b.apps...), nil
+}
+
+func (b *fakeBackend) Deploy(ctx context.Context) (*heroku.App, error) {
+	// give other workers a chance to count the pool while building
+	select {
+	case <-time.After(10 * time.Millisecond):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	app := heroku.App{
+		Name:      fmt.Sprintf("cf-%d-%si", b.nextID, editor.CurrentVersion()),
+		CreatedAt: time.Now(),
+	}
+	b.apps = append(b.apps, app)
+	if len(b.apps) > b.maxApps {
+		b.maxApps = len(b.apps)
+	}
+
+	return &app, nil
+}
+
+func (b *fakeBackend) FinishBuild(ctx context.Context, app *heroku.App) (*heroku.App, editor.BuildOutcome, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	outcome, ok := b.builds[app.Name]
+	if !ok {
+		return app, editor.BuildFailed, nil
+	}
+	if outcome != editor.BuildPromoted {
+		return app, outcome, nil
+	}
+
+	for i, a := range b.apps {
+		if a.Name == app.Name {
+			b.apps[i].Name = strings.TrimSuffix(a.Name, "b") + "i"
+			return &b.apps[i], outcome, nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("app %s not found", app.Name)
+}
+
+func (b *fakeBackend) Delete(app *heroku.App) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, a := range b.apps {
+		if a.Name == app.Name {
+			b.apps = append(b.apps[:i], b.apps[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("app %s not found", app.Name)
+}
+
+func TestWorkers_ConvergeToPoolSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codeface-worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	memLocker, memStore := lock.NewMemory(), store.NewMemory()
+	cases := []struct {
+		name string
+		// coordinate returns the lock and store of a worker
+		coordinate func() (lock.Locker, store.Store, error)
+	}{
+		{
+			name: "memory",
+			// workers of a process share the lock
+			coordinate: func() (lock.Locker, store.Store, error) {
+				return memLocker, memStore, nil
+			},
+		},
+		{
+			name: "file",
+			// each worker opens the lock file like a separate process
+			coordinate: func() (lock.Locker, store.Store, error) {
+				return newCoordination(Config{
+					LockBackend: "file",
+					LockFile:    filepath.Join(dir, "worker.lock"),
+					StateDir:    filepath.Join(dir, "state"),
+				})
+			},
+		},
+		{
+			name: "postgres",
+			coordinate: func() (lock.Locker, store.Store, error) {
+				return newCoordination(Config{DatabaseURL: os.Getenv("TEST_DATABASE_URL")})
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.name == "file" && runtime.GOOS == "windows" {
+				t.Skip("file lock is not supported on windows")
+			}
+			if c.name == "postgres" && os.Getenv("TEST_DATABASE_URL") == "" {
+				t.Skip("TEST_DATABASE_URL is not set")
+			}
+
+			testWorkersConverge(t, c.coordinate)
+		})
+	}
+}
+
+func testWorkersConverge(t *testing.T, coordinate func() (lock.Locker, store.Store, error)) {
+	const (
+		poolSize   = 5
+		numWorkers = 4
+		numRounds  = 10
+	)
+
+	cfg := Config{
+		BatchSize:        2,
+		PoolSize:         poolSize,
+		BreakerThreshold: 3,
+		TemplateDir:      os.TempDir(),
+	}
+
+	logger := log.New()
+	logger.Out = ioutil.Discard
+
+	backend := &fakeBackend{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		locker, st, err := coordinate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := newWorker(cfg, backend, locker, st, logger)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < numRounds; j++ {
+				if err := w.RunOnce(context.Background()); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if backend.maxApps > poolSize {
+		t.Errorf("want the pool to never exceed %d apps, got %d", poolSize, backend.maxApps)
+	}
+
+	currentVersion, otherVersion := editor.SplitIdledApps(backend.apps)
+	if len(currentVersion) != poolSize {
+		t.Errorf("want %d idle apps of the current version, got %d", poolSize, len(currentVersion))
+	}
+	if len(otherVersion) != 0 {
+		t.Errorf("want no idle apps of other versions, got %d", len(otherVersion))
+	}
+}
+
+func TestLockBackend(t *testing.T) {
+	cases := []struct {
+		cfg  Config
+		want string
+	}{
+		{Config{}, "memory"},
+		{Config{DatabaseURL: "postgres://localhost/codeface"}, "postgres"},
+		{Config{LockBackend: "file", DatabaseURL: "postgres://localhost/codeface"}, "file"},
+		{Config{LockBackend: "memory"}, "memory"},
+	}
+
+	for _, c := range cases {
+		if got := lockBackend(c.cfg); got != c.want {
+			t.Errorf("lockBackend(%+v) = %s, want %s", c.cfg, got, c.want)
+		}
+	}
+}
+
+func TestSweepOrphanedBuilds(t *testing.T) {
+	logger := log.New()
+	logger.Out = ioutil.Discard
+
+	var (
+		now     = time.Now()
+		version = editor.CurrentVersion()
+	)
+	backend := &fakeBackend{
+		apps: []heroku.App{
+			{Name: "cf-succeeded-" + version + "b", CreatedAt: now.Add(-time.Hour)},
+			{Name: "cf-failed-" + version + "b", CreatedAt: now.Add(-time.Hour)},
+			{Name: "cf-nobuild-" + version + "b", CreatedAt: now.Add(-time.Hour)},
+			{Name: "cf-pending-" + version + "b", CreatedAt: now.Add(-time.Hour)},
+			{Name: "cf-stuck-" + version + "b", CreatedAt: now.Add(-4 * time.Hour)},
+			// its deployer may still be running
+			{Name: "cf-recent-" + version + "b", CreatedAt: now.Add(-time.Minute)},
+			{Name: "cf-idle-" + version + "i", CreatedAt: now.Add(-time.Hour)},
+		},
+		builds: map[string]editor.BuildOutcome{
+			"cf-succeeded-" + version + "b": editor.BuildPromoted,
+			"cf-failed-" + version + "b":    editor.BuildFailed,
+			"cf-pending-" + version + "b":   editor.BuildPending,
+			"cf-stuck-" + version + "b":     editor.BuildPending,
+			"cf-recent-" + version + "b":    editor.BuildFailed,
+		},
+	}
+
+	w := newWorker(Config{
+		PoolSize:              5,
+		OrphanBuildAge:        30 * time.Minute,
+		OrphanPendingBuildAge: 3 * time.Hour,
+	}, backend, lock.NewMemory(), store.NewMemory(), logger)
+
+	if err := w.sweepOrphanedBuilds(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, app := range backend.apps {
+		got = append(got, app.Name)
+	}
+	sort.Strings(got)
+
+	want := []string{
+		"cf-idle-" + version + "i",
+		"cf-pending-" + version + "b",
+		"cf-recent-" + version + "b",
+		"cf-succeeded-" + version + "i",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("want apps %v, got %v", want, got)
+	}
+}
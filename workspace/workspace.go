@@ -0,0 +1,338 @@
+package workspace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrNoSnapshot is returned by Restore when the editor has never been snapshotted
+var ErrNoSnapshot = errors.New("workspace snapshot is not found")
+
+// ErrNotRestored is returned by Snapshot unless Restore succeeded or found no
+// snapshot, so that a workspace that failed to restore doesn't overwrite the
+// last snapshot
+var ErrNotRestored = errors.New("workspace snapshots are disabled because the last snapshot isn't restored")
+
+// restoreAttempts is how many times a failed restore is tried, e.g. on a
+// timeout of the download
+const restoreAttempts = 3
+
+// Config is set on an editor by the server when it's claimed
+type Config struct {
+	ServerURL   string `env:"CODEFACE_SERVER_URL,required"`
+	EditorID    string `env:"CODEFACE_EDITOR_ID,required"`
+	EditorToken string `env:"CODEFACE_EDITOR_TOKEN,required"`
+	Dir         string `env:"CODEFACE_WORKSPACE_DIR,default=/home/dyno/project"`
+	// Exclude are the names of files and directories left out of snapshots,
+	// e.g. "node_modules;*.log", in addition to the paths ignored by git
+	Exclude []string `env:"CODEFACE_WORKSPACE_EXCLUDE,default=node_modules"`
+}
+
+func New(cfg Config) *Workspace {
+	return &Workspace{
+		cfg:        cfg,
+		client:     &http.Client{Timeout: 10 * time.Minute},
+		retryDelay: 5 * time.Second,
+		logger:     log.New().WithField("com", "workspace").WithField("editor", cfg.EditorID),
+	}
+}
+
+// Workspace snapshots and restores the project directory of an editor
+type Workspace struct {
+	cfg    Config
+	client *http.Client
+	// retryDelay grows with each failed restore
+	retryDelay time.Duration
+	logger     log.FieldLogger
+
+	mu       sync.Mutex
+	restored bool
+}
+
+// Snapshot uploads the workspace and reports the outcome to the server. It
+// returns ErrNotRestored unless the workspace is restored.
+func (w *Workspace) Snapshot(ctx context.Context) error {
+	if !w.isRestored() {
+		return ErrNotRestored
+	}
+
+	size, err := w.snapshot(ctx)
+	w.report(ctx, model.WorkspaceSnapshot, size, err)
+
+	return err
+}
+
+func (w *Workspace) snapshot(ctx context.Context) (int64, error) {
+	// spool to disk so that the upload has a known length
+	f, err := ioutil.TempFile("", "workspace-*.tar.gz")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := archive(w.cfg.Dir, f, w.excluder(ctx)); err != nil {
+		return 0, err
+	}
+
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	u, err := w.presignedURL(ctx, model.WorkspaceSnapshot)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, f)
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = size
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return 0, fmt.Errorf("error: fail to upload workspace status=%d body=%s", resp.StatusCode, b)
+	}
+
+	w.logger.WithField("size", size).Info("Snapshotted workspace")
+
+	return size, nil
+}
+
+// excluder returns whether a path of the workspace is left out of snapshots.
+// Dependencies and build output are usually ignored by git and can be
+// installed or built again.
+func (w *Workspace) excluder(ctx context.Context) func(rel string, fi os.FileInfo) bool {
+	ignored := make(map[string]bool)
+
+	// repositories are cloned into the workspace
+	entries, err := ioutil.ReadDir(w.cfg.Dir)
+	if err != nil {
+		w.logger.WithError(err).Info("error: fail to list workspace")
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(w.cfg.Dir, e.Name(), ".git")); err != nil {
+			continue
+		}
+
+		cmd := exec.CommandContext(ctx, "git", "ls-files", "--others", "--ignored", "--exclude-standard", "--directory", "-z")
+		cmd.Dir = filepath.Join(w.cfg.Dir, e.Name())
+		out, err := cmd.Output()
+		if err != nil {
+			w.logger.WithError(err).WithField("repo", e.Name()).Info("error: fail to list ignored files")
+			continue
+		}
+
+		for _, p := range strings.Split(string(out), "\x00") {
+			if p = strings.TrimSuffix(p, "/"); p != "" {
+				ignored[filepath.Join(e.Name(), filepath.FromSlash(p))] = true
+			}
+		}
+	}
+
+	return func(rel string, fi os.FileInfo) bool {
+		if ignored[rel] {
+			return true
+		}
+
+		for _, pattern := range w.cfg.Exclude {
+			if ok, _ := filepath.Match(pattern, fi.Name()); ok {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Restore downloads the last snapshot into the workspace and reports the
+// outcome to the server. It returns ErrNoSnapshot if there is none. A failed
+// restore is retried, and snapshots stay disabled if it keeps failing.
+func (w *Workspace) Restore(ctx context.Context) error {
+	size, err := w.restore(ctx)
+	for i := 1; i < restoreAttempts && err != nil && err != ErrNoSnapshot && ctx.Err() == nil; i++ {
+		wait := time.Duration(i) * w.retryDelay
+		w.logger.WithError(err).WithField("retry_in", wait).Info("error: fail to restore workspace")
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+			size, err = w.restore(ctx)
+		}
+	}
+	w.report(ctx, model.WorkspaceRestore, size, err)
+
+	if err == nil || err == ErrNoSnapshot {
+		w.mu.Lock()
+		w.restored = true
+		w.mu.Unlock()
+	}
+
+	return err
+}
+
+func (w *Workspace) isRestored() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.restored
+}
+
+func (w *Workspace) restore(ctx context.Context) (int64, error) {
+	u, err := w.presignedURL(ctx, model.WorkspaceRestore)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return 0, fmt.Errorf("error: fail to download workspace status=%d body=%s", resp.StatusCode, b)
+	}
+
+	if err := extract(resp.Body, w.cfg.Dir); err != nil {
+		return 0, err
+	}
+
+	w.logger.WithField("size", resp.ContentLength).Info("Restored workspace")
+
+	return resp.ContentLength, nil
+}
+
+// Sync snapshots the workspace every interval until ctx is done. The last
+// snapshot is left to the caller, e.g. once the editor is stopped. It returns
+// ErrNotRestored right away unless the workspace is restored.
+func (w *Workspace) Sync(ctx context.Context, interval time.Duration) error {
+	if !w.isRestored() {
+		return ErrNotRestored
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.Snapshot(ctx); err != nil {
+				w.logger.WithError(err).Info("error: fail to snapshot workspace")
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (w *Workspace) presignedURL(ctx context.Context, op string) (string, error) {
+	resp, err := w.do(ctx, http.MethodPost, "/workspace/"+op, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if op == model.WorkspaceRestore && resp.StatusCode == http.StatusNotFound {
+		return "", ErrNoSnapshot
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", serverError(resp)
+	}
+
+	var u model.WorkspaceURLResponse
+	if err := json.NewDecoder(resp.Body).Decode(&u); err != nil {
+		return "", err
+	}
+
+	return u.URL, nil
+}
+
+func (w *Workspace) report(ctx context.Context, op string, size int64, err error) {
+	status := model.WorkspaceStatus{
+		Operation: op,
+		Status:    model.WorkspaceSucceeded,
+		Size:      size,
+		At:        time.Now(),
+	}
+	if err == ErrNoSnapshot {
+		status.Status = model.WorkspaceEmpty
+	} else if err != nil {
+		status.Status = model.WorkspaceFailed
+		status.Error = err.Error()
+	}
+
+	b, err := json.Marshal(status)
+	if err != nil {
+		return
+	}
+
+	resp, err := w.do(ctx, http.MethodPut, "/workspace/status", b)
+	if err != nil {
+		w.logger.WithError(err).Info("error: fail to report workspace status")
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		w.logger.WithError(serverError(resp)).Info("error: fail to report workspace status")
+	}
+}
+
+func (w *Workspace) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	u := fmt.Sprintf("%s/api/editors/%s%s", w.cfg.ServerURL, w.cfg.EditorID, path)
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+w.cfg.EditorToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	return w.client.Do(req)
+}
+
+func serverError(resp *http.Response) error {
+	var e model.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+		return fmt.Errorf("error: unexpected response from server status=%d", resp.StatusCode)
+	}
+
+	return fmt.Errorf("error: %s", e.Error)
+}
@@ -0,0 +1,324 @@
+package workspace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestArchive(t *testing.T) {
+	src := tempDir(t)
+	defer os.RemoveAll(src)
+	writeFiles(t, src, map[string]string{
+		"repo/main.go":                   "package main",
+		"repo/node_modules/dep/index.js": "module.exports = {}",
+		"notes.txt":                      "todo",
+	})
+
+	var buf bytes.Buffer
+	skip := func(rel string, fi os.FileInfo) bool {
+		return fi.Name() == "node_modules"
+	}
+	if err := archive(src, &buf, skip); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := tempDir(t)
+	defer os.RemoveAll(dst)
+	if err := extract(&buf, filepath.Join(dst, "project")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"notes.txt", "repo/main.go"}
+	if got := listFiles(t, filepath.Join(dst, "project")); !reflect.DeepEqual(got, want) {
+		t.Errorf("want files %v, got %v", want, got)
+	}
+	// nothing is left next to the workspace
+	if got := listFiles(t, dst); len(got) != len(want) {
+		t.Errorf("want only the workspace, got %v", got)
+	}
+}
+
+func TestExtract_Partial(t *testing.T) {
+	src := tempDir(t)
+	defer os.RemoveAll(src)
+	writeFiles(t, src, map[string]string{
+		"repo/a.go": strings.Repeat("a", 64*1024),
+		"repo/b.go": strings.Repeat("b", 64*1024),
+	})
+
+	var buf bytes.Buffer
+	if err := archive(src, &buf, func(string, os.FileInfo) bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := tempDir(t)
+	defer os.RemoveAll(dst)
+	dir := filepath.Join(dst, "project")
+	writeFiles(t, dir, map[string]string{"repo/a.go": "cloned"})
+
+	// e.g. a download cut short
+	truncated := bytes.NewReader(buf.Bytes()[:buf.Len()/2])
+	if err := extract(truncated, dir); err == nil {
+		t.Fatal("want an error extracting a truncated snapshot")
+	}
+
+	if got, want := listFiles(t, dst), []string{"project/repo/a.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want files %v, got %v", want, got)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "repo/a.go")); string(b) != "cloned" {
+		t.Errorf("want the workspace unchanged, got %q", b)
+	}
+}
+
+// fakeServer serves the workspace API of the server and the storage
+type fakeServer struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// downloads are the responses to the downloads of the snapshot in turn,
+	// the last one is repeated
+	downloads []func(w http.ResponseWriter)
+	snapshot  bool
+	uploads   int
+	statuses  []model.WorkspaceStatus
+}
+
+func newFakeServer(snapshot bool, downloads ...func(w http.ResponseWriter)) *fakeServer {
+	s := &fakeServer{snapshot: snapshot, downloads: downloads}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *fakeServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/api/editors/editor1/workspace/restore":
+		if !s.snapshot {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(model.WorkspaceURLResponse{URL: s.URL + "/download"})
+	case "/api/editors/editor1/workspace/snapshot":
+		json.NewEncoder(w).Encode(model.WorkspaceURLResponse{URL: s.URL + "/upload"})
+	case "/api/editors/editor1/workspace/status":
+		var status model.WorkspaceStatus
+		json.NewDecoder(r.Body).Decode(&status)
+		s.statuses = append(s.statuses, status)
+		w.WriteHeader(http.StatusNoContent)
+	case "/download":
+		d := s.downloads[0]
+		if len(s.downloads) > 1 {
+			s.downloads = s.downloads[1:]
+		}
+		d(w)
+	case "/upload":
+		s.uploads++
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	src := tempDir(t)
+	defer os.RemoveAll(src)
+	writeFiles(t, src, map[string]string{"repo/main.go": "package main"})
+
+	var snapshot bytes.Buffer
+	if err := archive(src, &snapshot, func(string, os.FileInfo) bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		ok = func(w http.ResponseWriter) {
+			w.Write(snapshot.Bytes())
+		}
+		unavailable = func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		truncated = func(w http.ResponseWriter) {
+			w.Write(snapshot.Bytes()[:snapshot.Len()/2])
+		}
+	)
+
+	cases := []struct {
+		name      string
+		snapshot  bool
+		downloads []func(w http.ResponseWriter)
+		err       error
+		// restored is whether the workspace has the files of the snapshot
+		restored bool
+		// status is the restore status reported to the server
+		status string
+	}{
+		{
+			name:      "restored",
+			snapshot:  true,
+			downloads: []func(w http.ResponseWriter){ok},
+			restored:  true,
+			status:    model.WorkspaceSucceeded,
+		},
+		{
+			name:     "no snapshot",
+			err:      ErrNoSnapshot,
+			status:   model.WorkspaceEmpty,
+			snapshot: false,
+		},
+		{
+			name:      "restored after a failure",
+			snapshot:  true,
+			downloads: []func(w http.ResponseWriter){unavailable, truncated, ok},
+			restored:  true,
+			status:    model.WorkspaceSucceeded,
+		},
+		{
+			name:      "unavailable",
+			snapshot:  true,
+			downloads: []func(w http.ResponseWriter){unavailable},
+			status:    model.WorkspaceFailed,
+		},
+		{
+			name:      "partial",
+			snapshot:  true,
+			downloads: []func(w http.ResponseWriter){truncated},
+			status:    model.WorkspaceFailed,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			srv := newFakeServer(c.snapshot, c.downloads...)
+			defer srv.Close()
+
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+			// the agent makes the project dir before restoring it
+			project := filepath.Join(dir, "project")
+			if err := os.MkdirAll(project, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			w := newTestWorkspace(srv.URL, project)
+			err := w.Restore(context.Background())
+			if c.err == nil && c.status == model.WorkspaceFailed {
+				if err == nil {
+					t.Fatal("want an error")
+				}
+			} else if err != c.err {
+				t.Fatalf("want error %v, got %v", c.err, err)
+			}
+
+			files := listFiles(t, dir)
+			if c.restored && !reflect.DeepEqual(files, []string{"project/repo/main.go"}) {
+				t.Errorf("want the snapshot restored, got %v", files)
+			}
+			if !c.restored && len(files) != 0 {
+				t.Errorf("want no files, got %v", files)
+			}
+
+			if len(srv.statuses) != 1 || srv.statuses[0].Status != c.status {
+				t.Errorf("want restore status %s, got %+v", c.status, srv.statuses)
+			}
+
+			// a workspace that failed to restore never overwrites the last snapshot
+			err = w.Snapshot(context.Background())
+			if c.status == model.WorkspaceFailed {
+				if err != ErrNotRestored {
+					t.Errorf("want ErrNotRestored, got %v", err)
+				}
+				if err := w.Sync(context.Background(), time.Millisecond); err != ErrNotRestored {
+					t.Errorf("want Sync to return ErrNotRestored, got %v", err)
+				}
+				if srv.uploads != 0 {
+					t.Errorf("want no upload, got %d", srv.uploads)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if srv.uploads != 1 {
+				t.Errorf("want 1 upload, got %d", srv.uploads)
+			}
+		})
+	}
+}
+
+func newTestWorkspace(serverURL, dir string) *Workspace {
+	logger := log.New()
+	logger.Out = ioutil.Discard
+
+	return &Workspace{
+		cfg: Config{
+			ServerURL:   serverURL,
+			EditorID:    "editor1",
+			EditorToken: "token",
+			Dir:         dir,
+		},
+		client:     http.DefaultClient,
+		retryDelay: time.Millisecond,
+		logger:     logger,
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "codeface-workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// listFiles returns the sorted paths of the regular files in dir
+func listFiles(t *testing.T, dir string) []string {
+	var files []string
+	err := filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			rel, err := filepath.Rel(dir, file)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+
+	return files
+}